@@ -2,11 +2,10 @@ package main
 
 import (
 	"fmt"
-	"net/http"
-	"strings"
 
 	"github.com/brutella/hc/accessory"
 	"github.com/brutella/hc/service"
+	"github.com/jurgen-kluft/go-home/conbee/deconz"
 	"github.com/jurgen-kluft/go-home/config"
 )
 
@@ -22,36 +21,18 @@ func (t *television) PowerSelect(state int) {
 type coloredLightbulb struct {
 	*accessory.Accessory
 	Light *service.ColoredLightbulb
-	ID    int // deconz light ID
+	api   *deconz.API
+	group string // deconz group ID
 }
 
-var (
-	setLightStateURL = "http://%s/api/%s/lights/%d/state"
-	setGroupStateURL = "http://%s/api/%s/groups/%d/action"
-)
-
-func turnOnOffLightGroup(groupID int, on bool) {
-	url := fmt.Sprintf(setGroupStateURL, "10.0.0.18", "0A498B9909", groupID)
-	stateJSON := "{ \"on\": false }"
-	if on {
-		stateJSON = "{ \"on\": true }"
-	}
-	body := strings.NewReader(stateJSON)
-	request, err := http.NewRequest("PUT", url, body)
-	if err != nil {
+func (c *coloredLightbulb) Callback(onoff bool) {
+	if c.api == nil || c.group == "" {
 		return
 	}
-	request.Header.Set("Content-Type", "application/json")
-	client := http.Client{}
-	response, err := client.Do(request)
+	err := c.api.SetGroupState(c.group, &deconz.LightState{On: &onoff})
 	if err != nil {
-		return
+		fmt.Printf("unable to turn %s %v: %s\n", c.Info.Name.GetValue(), onoff, err)
 	}
-	response.Body.Close()
-}
-
-func (c *coloredLightbulb) Callback(onoff bool) {
-	turnOnOffLightGroup(18, onoff)
 }
 
 type lightbulb struct {
@@ -181,7 +162,7 @@ type accessories struct {
 	Televisions       []*television
 }
 
-func (a *accessories) initializeFromConfig(config *config.AhkConfig) []*accessory.Accessory {
+func (a *accessories) initializeFromConfig(config *config.AhkConfig, api *deconz.API) []*accessory.Accessory {
 
 	bridgeInfo := accessory.Info{Name: "Bridge", ID: 1}
 	bridgeInfo.FirmwareRevision = "1.0"
@@ -204,6 +185,8 @@ func (a *accessories) initializeFromConfig(config *config.AhkConfig) []*accessor
 	for _, lght := range config.Lights {
 		if lght.Type == "colored" {
 			lightbulb := newColoredLightbulb(accessory.Info{Name: lght.Name, ID: lght.ID, Manufacturer: lght.Manufacturer})
+			lightbulb.api = api
+			lightbulb.group = lght.Group
 			lightbulb.Light.On.OnValueRemoteUpdate(lightbulb.Callback)
 			a.ColoredLights = append(a.ColoredLights, lightbulb)
 		} else if lght.Type == "white" {
@@ -5,10 +5,15 @@ import (
 	"io/ioutil"
 
 	"github.com/brutella/hc"
+	"github.com/jurgen-kluft/go-home/conbee/deconz"
 	"github.com/jurgen-kluft/go-home/config"
 )
 
 func main() {
+	if err := config.LoadSecrets(config.SecretsFile()); err != nil {
+		fmt.Println(err)
+	}
+
 	filename := "../config/ahk.config.json"
 	filedata, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -20,8 +25,26 @@ func main() {
 	var ahkConfig *config.AhkConfig
 	ahkConfig, err = config.AhkConfigFromJSON(jsonbytes)
 
+	// the colored lights are switched through the deCONZ gateway
+	var api *deconz.API
+	conbeedata, err := ioutil.ReadFile("../config/conbee.config.json")
+	if err == nil {
+		var conbeeConfig *config.ConbeeConfig
+		conbeeConfig, err = config.ConbeeConfigFromJSON(conbeedata)
+		var apikey string
+		if err == nil {
+			apikey, err = conbeeConfig.APIKey.Value()
+		}
+		if err == nil {
+			api = &deconz.API{Config: deconz.Config{Addr: conbeeConfig.Addr, APIKey: apikey}}
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+
 	acsrs := &accessories{}
-	accs := acsrs.initializeFromConfig(ahkConfig)
+	accs := acsrs.initializeFromConfig(ahkConfig, api)
 
 	// configure the ip transport
 	config := hc.Config{Pin: ahkConfig.Pin}
@@ -8,56 +8,50 @@ package main
 // - time-based logic (morning 6:20 turn on bedroom lights)
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/jurgen-kluft/go-home/automation/scheduler"
 	"github.com/jurgen-kluft/go-home/config"
 	microservice "github.com/jurgen-kluft/go-home/micro-service"
 )
 
 func main() {
-	register := []string{}
+	register := []string{"state/automation/timers/"}
 	subscribe := []string{"config/automation/"}
 
 	m := microservice.New("automation")
 	m.RegisterAndSubscribe(register, subscribe)
 
-	auto := new()
-	auto.service = m
+	auto := new(realClock{}, &microserviceContext{m: m})
 
 	m.RegisterHandler("config/automation/", func(m *microservice.Service, topic string, msg []byte) bool {
-		// Register used channels and subscribe to channels we are interested in
-		config, err := config.AutomationConfigFromJSON(msg)
-		if err == nil {
-			auto.config = config
+		if auto.handleConfig(msg) {
 			// Register used channels
 			for _, ss := range auto.config.ChannelsToRegister {
-				if err = m.Pubsub.Register(ss); err != nil {
+				if err := m.Pubsub.Register(ss); err != nil {
 					m.Logger.LogError(m.Name, err.Error())
 				}
 			}
 			// Subscribe channels
 			for _, ss := range auto.config.SubChannels {
-				if err = m.Pubsub.Subscribe(ss); err != nil {
+				if err := m.Pubsub.Subscribe(ss); err != nil {
 					m.Logger.LogError(m.Name, err.Error())
 				}
 			}
-		} else {
-			m.Logger.LogError(m.Name, err.Error())
 		}
 		return true
 	})
 
+	m.RegisterHandler("automation/response/", func(m *microservice.Service, topic string, msg []byte) bool {
+		auto.handleResponse(msg)
+		return true
+	})
+
 	m.RegisterHandler("*", func(m *microservice.Service, topic string, msg []byte) bool {
-		if strings.HasPrefix(topic, "state") {
-			state, err := config.SensorStateFromJSON(msg)
-			if err == nil {
-				auto.handleEvent(topic, state)
-			} else {
-				m.Logger.LogError(m.Name, err.Error())
-			}
-		}
+		auto.handleMessage(topic, msg)
 		return true
 	})
 
@@ -65,11 +59,7 @@ func main() {
 	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
 		if (tickCount & 0x1) == 0 {
 			// Every 10 seconds
-			if auto.config != nil {
-				auto.now = time.Now()
-				auto.presenceDetection()
-				auto.updateTimedActions()
-			}
+			auto.tick()
 		}
 		if (tickCount % 30) == 0 {
 			if auto.config == nil {
@@ -91,13 +81,15 @@ type homePresence struct {
 	detectionEvalResult    bool
 	detectionEvalDuration  time.Duration
 	presence               map[string]bool
+	useOccupancy           bool
+	occupied               bool
 }
 
-func newPresence() *homePresence {
+func newPresence(now time.Time) *homePresence {
 	h := &homePresence{}
 	h.peopleAreHome = true
 	h.detectionState = "Open/Closed"
-	h.detectionStamp = time.Now()
+	h.detectionStamp = now
 	h.detectionDelayDuration = time.Minute * 15
 	h.detectionEvalResult = false
 	h.detectionEvalDuration = time.Minute * 15
@@ -112,21 +104,30 @@ func newPresence() *homePresence {
 // - If after the evaluation window nothing is detected we mark 'peopleAreHome' as false
 // - After the evaluation state a scan state is started that will keep looking at
 //   Wifi and Motion etc..
-func (h *homePresence) frontDoorOpenClosed() {
+func (h *homePresence) frontDoorOpenClosed(now time.Time) {
 	h.detectionState = "Open/Closed"
-	h.detectionStamp = time.Now()
+	h.detectionStamp = now
+}
+
+// reportOccupancy() is called with the state of the (bayesian) occupancy sensor of
+// the home, once reported it replaces the door/motion based detection.
+func (h *homePresence) reportOccupancy(occupied bool) {
+	h.useOccupancy = true
+	h.occupied = occupied
 }
 
 // determineIfPeopleAreHome is the only function that is allowed to change
 // the variable 'peopleAreHome'!
 func (h *homePresence) determineIfPeopleAreHome(now time.Time) {
-	if h.detectionState == "OpenClosed" {
+	if h.useOccupancy {
+		h.peopleAreHome = h.occupied
+	} else if h.detectionState == "Open/Closed" {
 		// The door was opened/closed so that means people are/where at home
 		h.peopleAreHome = true
 
 		if now.Sub(h.detectionStamp) > h.detectionDelayDuration {
 			h.detectionState = "Evaluate"
-			h.detectionStamp = time.Now()
+			h.detectionStamp = now
 			h.detectionEvalResult = false
 		}
 	} else if h.detectionState == "Evaluate" {
@@ -172,26 +173,116 @@ func (h *homePresence) reportCausation(now time.Time) {
 }
 
 type automation struct {
-	config                      *config.AutomationConfig
-	sensors                     map[string]string
-	timeofday                   string
-	now                         time.Time
-	lastseenMotionInHouse       time.Time
-	lastseenMotionInKitchenArea time.Time
-	lastseenMotionInBedroom     time.Time
-	timedActions                map[string]*timedBasedAction
-	motionBasedActions          map[string]*motionBasedAction
-	presence                    *homePresence
-	service                     *microservice.Service
-}
-
-func new() *automation {
+	config              *config.AutomationConfig
+	rules               []*rule
+	sensors             map[string]string
+	lastseen            map[string]time.Time
+	timeofday           string
+	now                 time.Time
+	lastTimeRulesMinute time.Time
+	timers              *scheduler.Scheduler
+	presence            *homePresence
+	clock               clock
+	service             serviceContext
+}
+
+func new(clock clock, service serviceContext) *automation {
 	auto := &automation{}
+	auto.clock = clock
+	auto.service = service
+	auto.now = clock.Now()
 	auto.sensors = map[string]string{}
-	auto.presence = newPresence()
+	auto.lastseen = map[string]time.Time{}
+	auto.timers = scheduler.New(nil, scheduler.CatchUp{})
+	auto.presence = newPresence(auto.now)
 	return auto
 }
 
+// handleConfig parses a (re)published configuration, returns false when it is invalid
+func (a *automation) handleConfig(msg []byte) bool {
+	cfg, err := config.AutomationConfigFromJSON(msg)
+	if err != nil {
+		a.service.LogError(err.Error())
+		return false
+	}
+	a.now = a.clock.Now()
+	a.setConfig(cfg)
+	return true
+}
+
+// handleMessage handles all the state messages that we are subscribed to
+func (a *automation) handleMessage(topic string, msg []byte) {
+	if strings.HasPrefix(topic, "state") && a.config != nil {
+		state, err := config.SensorStateFromJSON(msg)
+		if err == nil {
+			a.now = a.clock.Now()
+			a.handleEvent(topic, state)
+		} else {
+			a.service.LogError(err.Error())
+		}
+	}
+}
+
+// tick updates presence detection, time based rules and pending timed actions
+func (a *automation) tick() {
+	if a.config != nil {
+		a.now = a.clock.Now()
+		a.presenceDetection()
+		a.triggerTimeRules()
+		a.updateTimedActions()
+	}
+}
+
+// setConfig (re)loads the configuration and compiles the rules
+func (a *automation) setConfig(cfg *config.AutomationConfig) {
+	rules, errs := compileRules(cfg.Rules)
+	for _, err := range errs {
+		a.service.LogError(err.Error())
+	}
+	restore := a.config == nil
+	a.config = cfg
+	a.rules = rules
+	a.service.LogInfo(fmt.Sprintf("loaded %d automation rules", len(rules)))
+
+	// Only the first configuration restores the persisted timers, after that we
+	// are the owner of the pending timers.
+	if restore {
+		a.restoreTimers()
+	}
+}
+
+// restoreTimers creates the scheduler from the configuration and restores the
+// timed actions that were pending when automation was stopped.
+func (a *automation) restoreTimers() {
+	tc := a.config.Timers
+	catchup := scheduler.CatchUp{Policy: tc.CatchUp}
+	if tc.CatchUpWindow != "" {
+		window, err := time.ParseDuration(tc.CatchUpWindow)
+		if err != nil {
+			a.service.LogError(err.Error())
+		}
+		catchup.Window = window
+	}
+
+	var store scheduler.Store
+	switch tc.Store {
+	case "file":
+		store = scheduler.NewFileStore(tc.Path)
+	case "kv":
+		store = scheduler.NewKeyValueStore(tc.Path, "timers", a.service.Conn)
+	}
+
+	a.timers = scheduler.New(store, catchup)
+	dropped, err := a.timers.Restore(a.clock.Now())
+	if err != nil {
+		a.service.LogError(err.Error())
+	}
+	for _, t := range dropped {
+		a.service.LogInfo(fmt.Sprintf("dropped timed action '%s' which was due at %s", t.Name, t.When.Format(time.RFC3339)))
+	}
+	a.publishTimers()
+}
+
 func (a *automation) peopleAreHome() bool {
 	return a.presence.peopleAreHome
 }
@@ -202,28 +293,76 @@ func (a *automation) sensorHasValue(name string, value string) bool {
 }
 
 func (a *automation) turnOnDevice(name string) error {
-	dc, exists := a.config.DeviceControlCache["name"]
-	if exists {
-		err := a.service.Pubsub.PublishStr(dc.Channel, dc.On)
-		return err
-	}
-	return fmt.Errorf("device with name %s doesn't exist", name)
+	return a.controlDevice(deviceRequest{Device: name, Command: "on"})
 }
 func (a *automation) turnOffDevice(name string) error {
-	dc, exists := a.config.DeviceControlCache["name"]
-	if exists {
-		err := a.service.Pubsub.PublishStr(dc.Channel, dc.Off)
-		return err
-	}
-	return fmt.Errorf("device with name %s doesn't exist", name)
+	return a.controlDevice(deviceRequest{Device: name, Command: "off"})
 }
 func (a *automation) toggleDevice(name string) error {
-	dc, exists := a.config.DeviceControlCache["name"]
-	if exists {
-		err := a.service.Pubsub.PublishStr(dc.Channel, dc.Toggle)
+	return a.controlDevice(deviceRequest{Device: name, Command: "toggle"})
+}
+
+// deviceRequest is the tag of a device command that is send as a request, it comes
+// back with the response so that a failed command can be send again. A device that
+// does not acknowledge its commands gets them published.
+type deviceRequest struct {
+	Device  string `json:"device"`
+	Command string `json:"command"`
+	Attempt int    `json:"attempt,omitempty"`
+}
+
+func (a *automation) controlDevice(dr deviceRequest) error {
+	dc, exists := a.config.DeviceControlCache[dr.Device]
+	if !exists {
+		return fmt.Errorf("device with name %s doesn't exist", dr.Device)
+	}
+	payload := dc.On
+	if dr.Command == "off" {
+		payload = dc.Off
+	} else if dr.Command == "toggle" {
+		payload = dc.Toggle
+	}
+	if !dc.Acknowledged() {
+		return a.service.PublishStr(dc.Channel, payload)
+	}
+	tag, err := json.Marshal(dr)
+	if err != nil {
 		return err
 	}
-	return fmt.Errorf("device with name %s doesn't exist", name)
+	a.service.Request(dc.Channel, payload, string(tag))
+	return nil
+}
+
+// handleResponse handles the reply on a device command, a command that failed is send
+// again until the retries of the device are used up, after that a notification is send.
+// A toggle is never send again, the device may have toggled without a reply in time.
+func (a *automation) handleResponse(msg []byte) {
+	response := &microservice.Response{}
+	if err := json.Unmarshal(msg, response); err != nil {
+		a.service.LogError(err.Error())
+		return
+	}
+	if response.Reply.Success || a.config == nil {
+		return
+	}
+	dr := deviceRequest{}
+	if err := json.Unmarshal([]byte(response.Tag), &dr); err != nil {
+		a.service.LogError(err.Error())
+		return
+	}
+	dc, exists := a.config.DeviceControlCache[dr.Device]
+	if exists && dr.Command != "toggle" && dr.Attempt < dc.Retries {
+		dr.Attempt++
+		a.service.LogInfo(fmt.Sprintf("retrying '%s' of device %s (%s)", dr.Command, dr.Device, response.Reply.Error))
+		a.controlDevice(dr)
+		return
+	}
+	a.service.LogInfo(fmt.Sprintf("'%s' of device %s failed: %s", dr.Command, dr.Device, response.Reply.Error))
+	action := "turn " + dr.Command
+	if dr.Command == "toggle" {
+		action = "toggle"
+	}
+	a.sendNotification(fmt.Sprintf("Failed to %s %s (%s)", action, dr.Device, response.Reply.Error))
 }
 
 func (a *automation) presenceDetection() {
@@ -232,38 +371,9 @@ func (a *automation) presenceDetection() {
 	peopleAreHome := a.peopleAreHome()
 
 	if !peopleWhereHome && peopleAreHome {
-		// Depending on time-of-day
-		switch a.timeofday {
-		case "lunch":
-			a.sendNotification("Turning on kitchen lights since it is noon and someone came home")
-			a.turnOnDevice(config.KitchenLights)
-			break
-		case "evening":
-			a.sendNotification("Turning on kitchen and livingroom lights since it is evening and someone came home")
-			a.turnOnDevice(config.KitchenLights)
-			a.turnOnDevice(config.LivingroomLightMain)
-			a.turnOnDevice(config.LivingroomLightStand)
-			a.turnOnDevice(config.LivingroomLightChandelier)
-			break
-		case "bedtime":
-			a.sendNotification("Turning on kitchen and livingroom lights since it is bedtime and someone came home")
-			a.turnOnDevice(config.KitchenLights)
-			a.turnOnDevice(config.LivingroomLightMain)
-			a.turnOnDevice(config.LivingroomLightStand)
-			a.turnOnDevice(config.LivingroomLightChandelier)
-			break
-		case "sleeptime":
-			a.sendNotification("Turning on kitchen and livingroom lights since it is sleeptime and someone came home")
-			a.turnOnDevice(config.KitchenLights)
-			a.turnOnDevice(config.LivingroomLightMain)
-			a.turnOnDevice(config.LivingroomLightStand)
-			a.turnOnDevice(config.LivingroomLightChandelier)
-			break
-		}
-
+		a.triggerPresenceRules("", "arrived")
 	} else if peopleWhereHome && !peopleAreHome {
-		// Turn off everything
-		a.turnOffEverything()
+		a.triggerPresenceRules("", "left")
 	}
 }
 
@@ -275,225 +385,56 @@ func (a *automation) handleEvent(channel string, state *config.SensorState) {
 		if sensorname == "timeofday" {
 			a.handleTimeOfDay(a.sensors[sensorname])
 		}
-	} else if sensortype == "switch" {
-		if sensorname == config.SophiaRoomSwitch || sensorname == config.BedroomSwitch {
-			a.handleSwitch(sensorname, state)
-		} else if sensorname == config.KitchenMotionSensor || sensorname == config.LivingroomMotionSensor || sensorname == config.BedroomMotionSensor {
-			a.handleMotionSensor(sensorname, state)
-		} else if sensorname == config.FrontdoorMagnetSensor {
-			a.handleMagnetSensor(sensorname, state)
-		}
 	} else if sensortype == "presence" {
 		a.handlePresence(state)
-	}
-}
-func wakeUpParentsForSophiaAndJennifer(ta *timedBasedAction, a *automation) {
-	a.sendNotification("Waking up Parents for Sophia & Jennifer")
-	a.turnOnDevice(config.BedroomLightStand)
-}
-func wakeUpParentsForJennifer(ta *timedBasedAction, a *automation) {
-	a.sendNotification("Waking up Parents for Jennifer")
-	a.turnOnDevice(config.BedroomLightStand)
-}
-func wakeUpParentsForSophia(ta *timedBasedAction, a *automation) {
-	a.sendNotification("Waking up Parents for Sophia")
-	a.turnOnDevice(config.BedroomLightStand)
-}
-func wakeUpParentsForWork(ta *timedBasedAction, a *automation) {
-	a.sendNotification("Waking up Parents")
-	a.turnOnDevice(config.BedroomLightStand)
-}
-func wakeUpJennifer(ta *timedBasedAction, a *automation) {
-	a.sendNotification("Waking up Jennifer")
-	a.turnOnDevice(config.JenniferRoomLightMain)
-}
-func wakeUpSophia(ta *timedBasedAction, a *automation) {
-	a.sendNotification("Waking up Sophia")
-	a.turnOnDevice(config.SophiaRoomLightStand)
-}
-func turnOnFrontdoorHallLight(ta *timedBasedAction, a *automation) {
-	a.turnOnDevice(config.FrontdoorHallLight)
-}
-
-// HandleTimeOfDay deals with time-of-day transitions
-func (a *automation) handleTimeOfDay(to string) {
-	if to != a.timeofday {
-		a.timeofday = to
-		switch to {
-		case "breakfast":
-			jenniferHasSchool := a.sensorHasValue("jennifer", "school")
-			sophiaHasSchool := a.sensorHasValue("sophia", "school")
-			parentsHaveToWork := a.sensorHasValue("parents", "work")
-			if parentsHaveToWork {
-				a.setRealTimeAction("Waking up Parents", 8, 0, wakeUpParentsForWork)
-			}
-			if jenniferHasSchool && sophiaHasSchool {
-				a.setRealTimeAction("Waking up Parents", 6, 20, wakeUpParentsForSophiaAndJennifer)
-			} else if jenniferHasSchool {
-				a.setRealTimeAction("Waking up Parents", 6, 20, wakeUpParentsForJennifer)
-			} else if sophiaHasSchool {
-				a.setRealTimeAction("Waking up Parents", 6, 20, wakeUpParentsForSophia)
-			}
-			if jenniferHasSchool {
-				a.setRealTimeAction("Waking up Jennifer", 6, 30, wakeUpJennifer)
-				a.setRealTimeAction("Turn on Hall Light", 7, 11, turnOnFrontdoorHallLight)
-			}
-			if sophiaHasSchool {
-				a.setRealTimeAction("Waking up Sophia", 6, 30, wakeUpSophia)
-				a.setRealTimeAction("Turn on Hall Light", 7, 11, turnOnFrontdoorHallLight)
-			}
-		case "morning":
-			a.sendNotification("Turning off lights since it is morning")
-			a.turnOffDevice(config.KitchenLights)
-			a.turnOffDevice(config.LivingroomLightStand)
-		case "lunch":
-			if a.peopleAreHome() {
-				a.sendNotification("Turning on lights since it is noon and someone is home")
-				a.turnOnDevice(config.KitchenLights)
-			}
-		case "afternoon":
-			a.sendNotification("Turning off lights since it is afternoon")
-			a.turnOffDevice(config.KitchenLights)
-		case "evening":
-			a.turnOnDevice(config.KitchenLights)
-			a.turnOnDevice(config.LivingroomLightStand)
-		case "bedtime":
-			if a.peopleAreHome() {
-				if a.sensorHasValue("jennifer", "school") {
-					a.turnOnDevice(config.JenniferRoomLightMain)
-				}
-				if a.sensorHasValue("sophia", "school") {
-					a.turnOnDevice(config.SophiaRoomLightMain)
-					a.turnOnDevice(config.SophiaRoomLightStand)
-				}
-			}
-		case "sleeptime":
-			if a.peopleAreHome() {
-				a.turnOnDevice(config.BedroomLightStand)
-				a.turnOnDevice(config.BedroomLightMain)
-				if a.sensorHasValue("jennifer", "school") {
-					a.turnOffDevice(config.JenniferRoomLightMain)
-				}
-				if a.sensorHasValue("sophia", "school") {
-					a.turnOnDevice(config.SophiaRoomLightMain)
-				}
-			}
-		case "night":
-			if a.sensorHasValue("jennifer", "school") || a.sensorHasValue("sophia", "school") {
-				a.turnOffDevice(config.BedroomLightMain)
-			}
-			a.turnOffDevice(config.KitchenLights)
-			a.turnOffDevice(config.LivingroomLightMain)
-			a.turnOffDevice(config.LivingroomLightStand)
-			a.turnOffDevice(config.JenniferRoomLightMain)
-			a.turnOffDevice(config.SophiaRoomLightStand)
-			a.turnOffDevice(config.SophiaRoomLightMain)
-			a.turnOffDevice(config.FrontdoorHallLight)
+	} else if sensortype == "occupancy" {
+		if sensorname == a.config.PresenceOccupancy {
+			a.presence.reportOccupancy(state.GetBoolAttr("occupied", true))
 		}
+	} else {
+		a.handlePresenceInputs(state)
 	}
-}
 
-// HandleSwitch deals with switches being pressed
-func (a *automation) handleSwitch(name string, state *config.SensorState) {
-	if name == config.BedroomSwitch {
-		value := state.GetValueAttr("click", "")
-		if value == config.WirelessSwitchDoubleClick {
-			a.presence.reportCausation(a.now)
-			a.toggleDevice(config.BedroomLightMain)
-		}
-		if value == config.WirelessSwitchSingleClick {
-			a.presence.reportCausation(a.now)
-			a.toggleDevice(config.BedroomLightStand)
-		}
-		if value == config.WirelessSwitchLongPress {
-			a.presence.reportCausation(a.now)
-			a.toggleDevice(config.BedroomPowerPlug)
-		}
-	} else if name == config.SophiaRoomSwitch {
-		value := state.GetValueAttr("click", "")
-		if value == config.WirelessSwitchSingleClick {
-			a.presence.reportCausation(a.now)
-			a.toggleDevice(config.SophiaRoomLightStand)
-		} else if value == config.WirelessSwitchDoubleClick {
-			a.presence.reportCausation(a.now)
-			a.toggleDevice(config.SophiaRoomLightMain)
-		}
+	a.triggerSensorRules(channel, state)
+
+	// Only after evaluating the rules can we update the last seen activity of
+	// this sensor, otherwise 'idle' conditions could never be true.
+	if isActivity(state) {
+		a.lastseen[sensorname] = a.now
 	}
 }
 
-// HandleMotionSensor deals with motion detected
-func (a *automation) handleMotionSensor(name string, state *config.SensorState) {
-	now := time.Now()
-	if name == config.KitchenMotionSensor || name == config.LivingroomMotionSensor {
-		value := state.GetValueAttr("motion", "")
-		if value == "on" {
-			a.presence.reportCausation(now)
-			a.lastseenMotionInHouse = now // Update the time we last detected motion
-			if name == config.KitchenMotionSensor {
-				a.lastseenMotionInKitchenArea = now
-				a.setDelayTimeAction("Turnoff front door hall light", 4*time.Minute, func(ta *timedBasedAction, a *automation) { a.turnOffDevice(config.FrontdoorHallLight) })
-			}
-			if a.timeofday == "breakfast" {
-				a.turnOnDevice(config.KitchenLights)
-				a.turnOnDevice(config.LivingroomLightStand)
-			}
+// isActivity returns true when the state indicates someone is doing something
+// like moving, pressing a switch or opening a door.
+func isActivity(state *config.SensorState) bool {
+	return state.GetValueAttr("motion", "") == "on" || state.GetValueAttr("click", "") != "" || state.GetValueAttr("state", "") == "open"
+}
 
-			if a.timeofday == "night" {
-				if name == config.KitchenMotionSensor {
-					a.sendNotification("Turning on kitchen and livingroom lights since it is night and there is movement in the kitchen area")
-				} else if name == config.LivingroomMotionSensor {
-					a.sendNotification("Turning on kitchen and livingroom lights since it is night and there is movement in the livingroom area")
-				}
-				a.turnOnDevice(config.KitchenLights)
-				a.turnOnDevice(config.LivingroomLightStand)
-				a.setDelayTimeAction(config.KitchenLights, 5*time.Minute, func(ta *timedBasedAction, a *automation) { a.turnOffDevice(config.KitchenLights) })
-				a.setDelayTimeAction(config.KitchenLights, 5*time.Minute, func(ta *timedBasedAction, a *automation) { a.turnOffDevice(config.KitchenLights) })
-			}
-		}
-	} else if name == config.BedroomMotionSensor {
-		value := state.GetValueAttr("motion", "")
-		lastseenDuration := now.Sub(a.lastseenMotionInBedroom)
-		if value == "on" {
-			a.presence.reportCausation(now)
-			a.lastseenMotionInHouse = now // Update the time we last detected motion
-			a.lastseenMotionInBedroom = now
-
-			if a.timeofday == "evening" || a.timeofday == "bedtime" {
-				if lastseenDuration > (time.Duration(15) * time.Minute) {
-					a.turnOnDevice(config.BedroomLightStand)
-					a.turnOnDevice(config.BedroomLightMain)
-				}
-			}
-		} else if value == "off" {
-			if a.timeofday != "night" && a.timeofday != "sleeptime" {
-				if lastseenDuration > (time.Duration(30) * time.Minute) {
-					a.turnOffDevice(config.BedroomLightMain)
-				}
+// handlePresenceInputs feeds motion, switches and doors into the home presence detection
+func (a *automation) handlePresenceInputs(state *config.SensorState) {
+	if state.GetValueAttr("motion", "") == "on" || state.GetValueAttr("click", "") != "" {
+		a.presence.reportCausation(a.now)
+	}
+	for _, door := range a.config.PresenceDoors {
+		if door == state.Name {
+			value := state.GetValueAttr("state", "")
+			if value == "open" || value == "close" {
+				a.presence.frontDoorOpenClosed(a.now)
 			}
 		}
 	}
 }
 
-func (a *automation) handleMagnetSensor(name string, state *config.SensorState) {
-	if name == config.FrontdoorMagnetSensor {
-		value := state.GetValueAttr("state", "")
-		if value == "open" {
-			a.sendNotification("Front door opened")
-			a.turnOnDevice(config.FrontdoorHallLight)
-			a.setDelayTimeAction("Turnoff front door hall light", 10*time.Minute, func(ta *timedBasedAction, a *automation) { a.turnOffDevice(config.FrontdoorHallLight) })
-			a.presence.frontDoorOpenClosed()
-			a.lastseenMotionInKitchenArea = time.Now()
-		} else if value == "close" {
-			a.sendNotification("Front door closed")
-			a.setDelayTimeAction("Turnoff front door hall light", 5*time.Minute, func(ta *timedBasedAction, a *automation) { a.turnOffDevice(config.FrontdoorHallLight) })
-			a.presence.frontDoorOpenClosed()
-			a.lastseenMotionInKitchenArea = time.Now()
-		}
+// HandleTimeOfDay deals with time-of-day transitions
+func (a *automation) handleTimeOfDay(to string) {
+	if to != a.timeofday {
+		a.timeofday = to
+		a.triggerTimeOfDayRules(to)
 	}
 }
 
 func (a *automation) sendNotification(message string) {
-	a.service.Pubsub.PublishStr("shout/message/", message)
+	a.service.PublishStr("shout/message/", message)
 }
 
 func (a *automation) updatePresence(name string, presence bool) (current bool, previous bool) {
@@ -501,8 +442,8 @@ func (a *automation) updatePresence(name string, presence bool) (current bool, p
 	previous, exists = a.presence.presence[name]
 	if !exists {
 		previous = false
-		a.presence.presence[name] = presence
 	}
+	a.presence.presence[name] = presence
 	return presence, previous
 }
 
@@ -513,198 +454,94 @@ func (a *automation) handlePresence(state *config.SensorState) {
 			if presence != previous {
 				if presence == false {
 					a.sendNotification(fmt.Sprintf("%s is not home", attr.Name))
+					a.triggerPresenceRules(attr.Name, "away")
 				} else {
 					a.sendNotification(fmt.Sprintf("%s is home", attr.Name))
+					a.triggerPresenceRules(attr.Name, "home")
 				}
 			}
 		}
 	}
 }
 
-func (a *automation) turnOffEverything() {
-	a.turnOffDevice(config.KitchenLights)
-	a.turnOffDevice(config.LivingroomLightStand)
-	a.turnOffDevice(config.LivingroomLightMain)
-	a.turnOffDevice(config.LivingroomLightChandelier)
-	a.turnOffDevice(config.BedroomLightStand)
-	a.turnOffDevice(config.BedroomLightMain)
-	a.turnOffDevice(config.JenniferRoomLightMain)
-	a.turnOffDevice(config.SophiaRoomLightStand)
-	a.turnOffDevice(config.SophiaRoomLightMain)
-	a.turnOffDevice(config.FrontdoorHallLight)
-
-	a.turnOffDevice(config.BedroomSamsungTV)
-	a.turnOffDevice(config.LivingroomBraviaTV)
-}
-
-// UpdateTimedActions will tick all actions and remove any that have ended
+// updateTimedActions will tick all pending actions, execute the ones that are due
+// and persist the schedule when it has changed.
 func (a *automation) updateTimedActions() {
-	var toremove []string
-	for name, ta := range a.timedActions {
-		if ta.tick(a.now) == true {
-			ta.Action(ta, a)
-			toremove = append(toremove, name)
+	for _, timer := range a.timers.Pending() {
+		ta := &timedBasedAction{}
+		if err := timer.Decode(ta); err != nil {
+			a.service.LogError(err.Error())
+			a.timers.Remove(timer.Name)
+			continue
+		}
+
+		if ta.tick(a.now, timer.When) {
+			a.timers.Remove(timer.Name)
+			a.resumeRule(timer.Name, ta)
 		}
 	}
-	for _, name := range toremove {
-		delete(a.timedActions, name)
+
+	if a.timers.Changed() {
+		if err := a.timers.Flush(); err != nil {
+			a.service.LogError(err.Error())
+		}
+		a.publishTimers()
 	}
 }
 
-// UpdateTimedActions will tick all actions and remove any that have ended
-func (a *automation) updateMotionBasedActions(sensors map[string]bool) {
-	var toremove []string
-	for name, ta := range a.motionBasedActions {
-		if ta.tick(a.now, sensors) == actionTriggered {
-			ta.Action(ta, a)
-			if ta.RemoveWhenTriggered {
-				toremove = append(toremove, name)
-			} else {
-				ta.reset(a.now)
+// resumeRule executes the actions of the rule that the timed action refers to, when the
+// actions of the rule have changed since the timer was set the timed action is dropped.
+func (a *automation) resumeRule(name string, ta *timedBasedAction) {
+	for _, r := range a.rules {
+		if r.name == ta.Rule {
+			if ta.Hash != r.hash {
+				a.service.LogInfo(fmt.Sprintf("dropped timed action '%s' since the actions of rule '%s' have changed", name, ta.Rule))
+			} else if ta.Action < len(r.actions) {
+				a.executeActions(r, r.actions[ta.Action:])
 			}
+			return
 		}
 	}
-	for _, name := range toremove {
-		delete(a.motionBasedActions, name)
-	}
+	a.service.LogError(fmt.Sprintf("timed action '%s' refers to rule '%s' which does not exist (anymore)", name, ta.Rule))
 }
 
-// actionDelegate is the action delegate
-type actionDelegate func(ta *timedBasedAction, a *automation)
-
-// timedBasedAction holds the 'when' and 'action' necessary to trigger
-type timedBasedAction struct {
-	Name   string
-	When   time.Time
-	Action actionDelegate
+// publishTimers publishes the pending timed actions, every timer is a time window
+// from the moment it was set until the moment it is due.
+func (a *automation) publishTimers() {
+	state := config.NewSensorState("timers", "automation")
+	for _, timer := range a.timers.Pending() {
+		state.AddTimeWndAttr(timer.Name, timer.Since, timer.When)
+	}
+	data, err := state.ToJSON()
+	if err == nil {
+		err = a.service.Publish("state/automation/timers/", data)
+	}
+	if err != nil {
+		a.service.LogError(err.Error())
+	}
 }
 
-// setRealTimeAction sets an action that will trigger at a specific time
-func (a *automation) setRealTimeAction(name string, hour int, minute int, ad actionDelegate) {
-	action, exists := a.timedActions[name]
-	if !exists {
-		when := time.Date(a.now.Year(), a.now.Month(), a.now.Day(), hour, minute, 0, 0, a.now.Location())
-		action = &timedBasedAction{Name: name, When: when, Action: ad}
-		a.timedActions[name] = action
-	}
+// timedBasedAction is the persisted part of a pending action, it refers to a rule, the
+// hash of its actions and the index of the first action of that rule to execute once it
+// triggers.
+type timedBasedAction struct {
+	Rule   string `json:"rule"`
+	Hash   string `json:"hash"`
+	Action int    `json:"action"`
 }
 
 // setDelayTimeAction sets an action that trigger after 'duration'
-func (a *automation) setDelayTimeAction(name string, duration time.Duration, actiondelegate actionDelegate) {
-	action, exists := a.timedActions[name]
-	if !exists {
-		action = &timedBasedAction{Name: name, When: time.Now().Add(duration), Action: actiondelegate}
-		a.timedActions[name] = action
-	} else {
-		action.When = time.Now().Add(duration)
-	}
+func (a *automation) setDelayTimeAction(name string, duration time.Duration, r *rule, index int) {
+	a.setTimer(name, a.now.Add(duration), &timedBasedAction{Rule: r.name, Hash: r.hash, Action: index})
 }
 
-// tick returns true when the action has ended, false otherwise
-func (ta *timedBasedAction) tick(now time.Time) bool {
-	return now.After(ta.When)
-}
-
-// Examples:
-// A) While there is motion every 10 minutes keep the lights ON, once there is not turn the
-//    lights OFF and auto-delete
-// B) Once there is no motion in the kitchen for 15 minutes turn OFF the lights and auto-delete
-// C) For the next duration once there is motion turn ON the lights and auto-delete
-//
-type motionDelegate func(ta *motionBasedAction, a *automation)
-type motionType int
-
-const (
-	whileMotionEveryPeriodThen motionType = iota
-	whileNoMotionForCertainDurationThen
-	whileNoMotionUntilMotion
-)
-
-type motionBasedAction struct {
-	Name                string
-	Sensors             []string
-	From                time.Time
-	Duration            time.Duration
-	Count               int
-	Action              motionDelegate
-	Type                motionType
-	RemoveWhenTriggered bool // When this action fired should we remove ourselves?
-}
-
-// setNoMotionAction sets an action that will trigger at a specific time
-func (a *automation) setMotionAction(name string, motiontype motionType, sensors []string, hour int, minute int, actiondelegate motionDelegate, removeWhenTriggered bool) {
-	action, exists := a.motionBasedActions[name]
-	duration := time.Date(a.now.Year(), a.now.Month(), a.now.Day(), hour, minute, 0, 0, a.now.Location()).Sub(a.now)
-	if !exists {
-		action = &motionBasedAction{Name: name}
-		action.Sensors = sensors
-		action.From = a.now
-		action.Duration = duration
-		action.Count = 0
-		action.Action = actiondelegate
-		action.Type = motiontype
-		action.RemoveWhenTriggered = removeWhenTriggered
-		a.motionBasedActions[name] = action
-	} else {
-		action.Sensors = sensors
-		action.From = a.now
-		action.Duration = duration
-		action.Count = 0
-		action.Action = actiondelegate
-		action.Type = motiontype
-		action.RemoveWhenTriggered = removeWhenTriggered
+func (a *automation) setTimer(name string, when time.Time, ta *timedBasedAction) {
+	if err := a.timers.Set(name, a.now, when, ta); err != nil {
+		a.service.LogError(err.Error())
 	}
 }
 
-// resetMotionAction sets an action that trigger after 'motion'
-func (ta *motionBasedAction) reset(now time.Time) {
-	ta.From = now
-	ta.Count = 0
-}
-
-type tickResult int
-
-const (
-	actionTriggered tickResult = iota
-	actionEvaluating
-	actionFailed
-)
-
-// tick returns the result when the action is still evaluating, has been triggered or has failed
-func (ta *motionBasedAction) tick(now time.Time, sensors map[string]bool) tickResult {
-	motion := false
-	for _, name := range ta.Sensors {
-		state, exists := sensors[name]
-		if exists {
-			motion = motion || state
-		}
-	}
-	if ta.Type == whileMotionEveryPeriodThen {
-		until := ta.From.Add(ta.Duration)
-		if now.After(until) {
-			if ta.Count > 0 {
-				ta.From = now
-				ta.Count = 0
-				return actionEvaluating
-			}
-			return actionTriggered
-		} else if motion {
-			ta.Count++
-		}
-		return actionEvaluating
-	} else if ta.Type == whileNoMotionForCertainDurationThen {
-		when := ta.From.Add(ta.Duration)
-		if now.After(when) {
-			return actionTriggered
-		}
-		if !motion {
-			return actionEvaluating
-		}
-	} else if ta.Type == whileNoMotionUntilMotion {
-		if motion {
-			return actionTriggered
-		}
-		return actionEvaluating
-	}
-	return actionFailed
+// tick returns true when the action has ended, false otherwise
+func (ta *timedBasedAction) tick(now time.Time, when time.Time) bool {
+	return now.After(when)
 }
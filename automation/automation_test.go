@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+func TestDeviceRequests(t *testing.T) {
+	start := time.Date(2019, 3, 4, 21, 0, 0, 0, time.UTC)
+	clock := &virtualClock{now: start}
+	service := newScenarioService(t, clock)
+	auto := new(clock, service)
+	auto.config = &config.AutomationConfig{DeviceControlCache: map[string]config.DeviceControl{
+		"Kitchen":    {Channel: "state/light/automation/", On: "kitchen on", Off: "kitchen off"},
+		"Bedroom TV": {Channel: "state/samsung.tv/automation/", On: "tv on", Off: "tv off", Retries: 2},
+		"Plug":       {Channel: "state/switch/automation/", Toggle: "plug toggle", Retries: 2},
+	}}
+	for _, channel := range []string{"state/light/automation/", "state/samsung.tv/automation/", "state/switch/automation/", "shout/message/"} {
+		service.m.Register(channel)
+	}
+	service.acknowledge(microservice.Failed(errors.New("timeout")), "state/samsung.tv/automation/", "state/switch/automation/")
+
+	if err := auto.turnOnDevice("Kitchen"); err != nil {
+		t.Fatal(err)
+	}
+	if published := service.take(); len(published) != 1 || published[0].payload != "kitchen on" {
+		t.Fatalf("turnOnDevice(Kitchen) published %v", published)
+	}
+	if service.requests != 0 {
+		t.Errorf("a device without ack or retries got a request")
+	}
+
+	// A failed command is send again 'retries' times and then notified
+	auto.turnOffDevice("Bedroom TV")
+	service.handleResponses(auto)
+	published := service.take()
+	if len(published) != 4 {
+		t.Fatalf("turnOffDevice(Bedroom TV) published %v", published)
+	}
+	for _, p := range published[:3] {
+		if p.subject != microservice.NormalizeTopic("state/samsung.tv/automation/") || p.payload != "tv off" {
+			t.Errorf("retry published %v", p)
+		}
+	}
+	if n := published[3]; n.subject != microservice.NormalizeTopic("shout/message/") || n.payload != "Failed to turn off Bedroom TV (timeout)" {
+		t.Errorf("notification = %v", n)
+	}
+
+	// A toggle is not send again since the device may have toggled
+	auto.toggleDevice("Plug")
+	service.handleResponses(auto)
+	published = service.take()
+	if len(published) != 2 || published[0].payload != "plug toggle" {
+		t.Fatalf("toggleDevice(Plug) published %v", published)
+	}
+	if n := published[1]; n.payload != "Failed to toggle Plug (timeout)" {
+		t.Errorf("notification = %v", n)
+	}
+
+	if err := auto.toggleDevice("Garage"); err == nil {
+		t.Errorf("toggleDevice(Garage) should fail")
+	}
+}
+
+func TestTimedActionOfChangedRule(t *testing.T) {
+	start := time.Date(2019, 3, 4, 21, 0, 0, 0, time.UTC)
+	clock := &virtualClock{now: start}
+	service := newScenarioService(t, clock)
+	service.m.Register("state/light/automation/")
+	auto := new(clock, service)
+	rules := func(device string) *config.AutomationConfig {
+		return &config.AutomationConfig{
+			DeviceControlCache: map[string]config.DeviceControl{
+				"Kitchen": {Channel: "state/light/automation/", On: "kitchen on", Off: "kitchen off"},
+				"Hallway": {Channel: "state/light/automation/", On: "hallway on", Off: "hallway off"},
+			},
+			Rules: []config.AutomationRule{{
+				Name:    "lights",
+				Trigger: config.AutomationTrigger{Type: "timeofday", Value: "evening"},
+				Actions: []config.AutomationAction{
+					{Type: "turnon", Device: device},
+					{Type: "delay", Duration: "10m"},
+					{Type: "turnoff", Device: device},
+				},
+			}},
+		}
+	}
+
+	auto.setConfig(rules("Kitchen"))
+	auto.executeActions(auto.rules[0], auto.rules[0].actions)
+	auto.setConfig(rules("Hallway"))
+	service.take()
+	clock.now = start.Add(11 * time.Minute)
+	auto.now = clock.now
+	auto.updateTimedActions()
+	for _, p := range service.take() {
+		if p.subject == microservice.NormalizeTopic("state/light/automation/") {
+			t.Errorf("the timed action of a changed rule published %v", p)
+		}
+	}
+	if len(auto.timers.Pending()) != 0 {
+		t.Errorf("the timed action of a changed rule is still pending")
+	}
+
+	auto.executeActions(auto.rules[0], auto.rules[0].actions)
+	service.take()
+	clock.now = start.Add(22 * time.Minute)
+	auto.now = clock.now
+	auto.updateTimedActions()
+	if published := service.take(); len(published) == 0 || published[0].payload != "hallway off" {
+		t.Errorf("the timed action of an unchanged rule published %v", published)
+	}
+}
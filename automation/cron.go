@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed 5 field cron specification: 'minute hour day-of-month month day-of-week'
+// Every field supports '*', lists ('1,15'), ranges ('1-5') and steps ('*/10', '0-30/5').
+type cronSpec struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	anyDom bool
+	anyDow bool
+}
+
+type cronField struct {
+	min int
+	max int
+}
+
+var cronFields = []cronField{
+	{min: 0, max: 59}, // minute
+	{min: 0, max: 23}, // hour
+	{min: 1, max: 31}, // day of month
+	{min: 1, max: 12}, // month
+	{min: 0, max: 7},  // day of week, 0 and 7 are both sunday
+}
+
+func parseCron(spec string) (*cronSpec, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron '%s' should have %d fields", spec, len(cronFields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron '%s': %s", spec, err.Error())
+		}
+		bits[i] = b
+	}
+
+	c := &cronSpec{minute: bits[0], hour: bits[1], dom: bits[2], month: bits[3], dow: bits[4]}
+	if (c.dow & (1 << 7)) != 0 {
+		c.dow |= 1
+	}
+	c.anyDom = fields[2] == "*"
+	c.anyDow = fields[4] == "*"
+	return c, nil
+}
+
+func parseCronField(field string, r cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+			step = s
+			part = part[:i]
+		}
+
+		from, to := r.min, r.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			v, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value '%s'", part)
+			}
+			from, to = v, v
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid range '%s'", part)
+				}
+			} else if step > 1 {
+				to = r.max
+			}
+		}
+		if from < r.min || to > r.max || from > to {
+			return 0, fmt.Errorf("'%s' is out of range [%d-%d]", part, r.min, r.max)
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matches returns true when 't' (at minute resolution) is matched by the cron specification
+func (c *cronSpec) matches(t time.Time) bool {
+	if (c.minute&(1<<uint(t.Minute()))) == 0 || (c.hour&(1<<uint(t.Hour()))) == 0 || (c.month&(1<<uint(t.Month()))) == 0 {
+		return false
+	}
+
+	// Like cron, when both day-of-month and day-of-week are restricted either one can match
+	dom := (c.dom & (1 << uint(t.Day()))) != 0
+	dow := (c.dow & (1 << uint(t.Weekday()))) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
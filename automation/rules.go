@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+// rule is the compiled form of a config.AutomationRule
+type rule struct {
+	name       string
+	trigger    config.AutomationTrigger
+	cron       *cronSpec
+	conditions []*condition
+	actions    []*action
+	hash       string // of the actions, a timed action only resumes the actions it was set by
+}
+
+type condition struct {
+	kind     string
+	name     string
+	value    string
+	values   []string
+	duration time.Duration
+	not      bool
+}
+
+type action struct {
+	kind     string
+	name     string
+	device   string
+	message  string
+	duration time.Duration
+}
+
+// compileRules converts the rules in the configuration, rules that fail to compile
+// are reported and skipped.
+func compileRules(cfg []config.AutomationRule) (rules []*rule, errs []error) {
+	for _, rc := range cfg {
+		r, err := compileRule(rc)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			rules = append(rules, r)
+		}
+	}
+	return rules, errs
+}
+
+func compileRule(rc config.AutomationRule) (*rule, error) {
+	r := &rule{name: rc.Name, trigger: rc.Trigger}
+	if r.name == "" {
+		return nil, fmt.Errorf("rule without a name")
+	}
+
+	switch rc.Trigger.Type {
+	case "sensor":
+		if rc.Trigger.Topic == "" && rc.Trigger.Name == "" {
+			return nil, fmt.Errorf("rule '%s', sensor trigger needs a topic or a name", r.name)
+		}
+		r.trigger.Topic = microservice.NormalizeTopic(rc.Trigger.Topic)
+	case "timeofday":
+	case "presence":
+	case "time":
+		spec, err := parseCron(rc.Trigger.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("rule '%s', %s", r.name, err.Error())
+		}
+		r.cron = spec
+	default:
+		return nil, fmt.Errorf("rule '%s', unknown trigger type '%s'", r.name, rc.Trigger.Type)
+	}
+
+	for _, cc := range rc.Conditions {
+		c := &condition{kind: cc.Type, name: cc.Name, value: cc.Value, values: cc.Values, not: cc.Not}
+		switch cc.Type {
+		case "sensor", "day":
+		case "peoplehome":
+			if _, err := strconv.ParseBool(cc.Value); err != nil {
+				return nil, fmt.Errorf("rule '%s', peoplehome condition needs 'true' or 'false'", r.name)
+			}
+		case "idle":
+			d, err := time.ParseDuration(cc.Duration)
+			if err != nil {
+				return nil, fmt.Errorf("rule '%s', %s", r.name, err.Error())
+			}
+			c.duration = d
+		default:
+			return nil, fmt.Errorf("rule '%s', unknown condition type '%s'", r.name, cc.Type)
+		}
+		r.conditions = append(r.conditions, c)
+	}
+
+	for _, ac := range rc.Actions {
+		a := &action{kind: ac.Type, name: ac.Name, device: ac.Device, message: ac.Message}
+		switch ac.Type {
+		case "turnon", "turnoff", "toggle":
+			if ac.Device == "" {
+				return nil, fmt.Errorf("rule '%s', action '%s' needs a device", r.name, ac.Type)
+			}
+		case "notify":
+		case "delay":
+			d, err := time.ParseDuration(ac.Duration)
+			if err != nil {
+				return nil, fmt.Errorf("rule '%s', %s", r.name, err.Error())
+			}
+			a.duration = d
+			if a.name == "" {
+				a.name = r.name
+			}
+		default:
+			return nil, fmt.Errorf("rule '%s', unknown action type '%s'", r.name, ac.Type)
+		}
+		r.actions = append(r.actions, a)
+	}
+	r.hash = hashActions(rc.Actions)
+	return r, nil
+}
+
+func hashActions(actions []config.AutomationAction) string {
+	data, _ := json.Marshal(actions)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func matchTopicPattern(pattern string, topic string) bool {
+	if pattern == "" {
+		return true
+	}
+	pchapters := strings.Split(pattern, ".")
+	tchapters := strings.Split(microservice.NormalizeTopic(topic), ".")
+	for i, p := range pchapters {
+		if p == ">" {
+			return i < len(tchapters)
+		}
+		if i >= len(tchapters) || (p != "*" && p != tchapters[i]) {
+			return false
+		}
+	}
+	return len(pchapters) == len(tchapters)
+}
+
+func (r *rule) matchesSensor(topic string, state *config.SensorState) bool {
+	t := r.trigger
+	if t.Type != "sensor" || !matchTopicPattern(t.Topic, topic) {
+		return false
+	}
+	if t.Name != "" && t.Name != state.Name {
+		return false
+	}
+	if t.Attribute == "" {
+		return true
+	}
+	for _, sa := range state.StringAttrs {
+		if sa.Name == t.Attribute {
+			return t.Value == "" || sa.Value == t.Value
+		}
+	}
+	for _, ba := range state.BoolAttrs {
+		if ba.Name == t.Attribute {
+			return t.Value == "" || strconv.FormatBool(ba.Value) == t.Value
+		}
+	}
+	return false
+}
+
+func (a *automation) conditionHolds(c *condition) bool {
+	result := false
+	switch c.kind {
+	case "sensor":
+		result = a.sensorHasValue(c.name, c.value)
+	case "peoplehome":
+		expected, _ := strconv.ParseBool(c.value)
+		result = a.peopleAreHome() == expected
+	case "day":
+		result = matchDay(a.now, c.values)
+	case "idle":
+		lastseen, exists := a.lastseen[c.name]
+		result = !exists || a.now.Sub(lastseen) >= c.duration
+	}
+	return result != c.not
+}
+
+func matchDay(now time.Time, days []string) bool {
+	weekday := now.Weekday()
+	for _, day := range days {
+		switch strings.ToLower(day) {
+		case "weekday":
+			if weekday != time.Saturday && weekday != time.Sunday {
+				return true
+			}
+		case "weekend":
+			if weekday == time.Saturday || weekday == time.Sunday {
+				return true
+			}
+		default:
+			if strings.EqualFold(day, weekday.String()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fireRules evaluates the conditions of the given (triggered) rules and executes their actions
+func (a *automation) fireRules(triggered []*rule) {
+	for _, r := range triggered {
+		holds := true
+		for _, c := range r.conditions {
+			if !a.conditionHolds(c) {
+				holds = false
+				break
+			}
+		}
+		if holds {
+			a.executeActions(r, r.actions)
+		}
+	}
+}
+
+func (a *automation) executeActions(r *rule, actions []*action) {
+	for i, ac := range actions {
+		var err error
+		switch ac.kind {
+		case "turnon":
+			err = a.turnOnDevice(ac.device)
+		case "turnoff":
+			err = a.turnOffDevice(ac.device)
+		case "toggle":
+			err = a.toggleDevice(ac.device)
+		case "notify":
+			a.sendNotification(ac.message)
+		case "delay":
+			// The remaining actions are referred to by their index in the rule so that
+			// the timed action can be persisted and restored.
+			a.setDelayTimeAction(ac.name, ac.duration, r, len(r.actions)-len(actions)+i+1)
+			return
+		}
+		if err != nil {
+			a.service.LogError(fmt.Sprintf("rule '%s', %s", r.name, err.Error()))
+		}
+	}
+}
+
+func (a *automation) triggerSensorRules(topic string, state *config.SensorState) {
+	triggered := []*rule{}
+	for _, r := range a.rules {
+		if r.matchesSensor(topic, state) {
+			triggered = append(triggered, r)
+		}
+	}
+	a.fireRules(triggered)
+}
+
+func (a *automation) triggerTimeOfDayRules(timeofday string) {
+	triggered := []*rule{}
+	for _, r := range a.rules {
+		if r.trigger.Type == "timeofday" && r.trigger.Value == timeofday {
+			triggered = append(triggered, r)
+		}
+	}
+	a.fireRules(triggered)
+}
+
+// triggerPresenceRules fires presence rules, 'name' is empty for the whole house
+func (a *automation) triggerPresenceRules(name string, value string) {
+	triggered := []*rule{}
+	for _, r := range a.rules {
+		if r.trigger.Type == "presence" && r.trigger.Name == name && r.trigger.Value == value {
+			triggered = append(triggered, r)
+		}
+	}
+	a.fireRules(triggered)
+}
+
+// triggerTimeRules fires time rules at most once for every minute
+func (a *automation) triggerTimeRules() {
+	minute := a.now.Truncate(time.Minute)
+	if minute.Equal(a.lastTimeRulesMinute) {
+		return
+	}
+	a.lastTimeRulesMinute = minute
+
+	triggered := []*rule{}
+	for _, r := range a.rules {
+		if r.cron != nil && r.cron.matches(minute) {
+			triggered = append(triggered, r)
+		}
+	}
+	a.fireRules(triggered)
+}
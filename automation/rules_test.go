@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+func TestCronMatches(t *testing.T) {
+	tests := []struct {
+		spec string
+		when time.Time
+		want bool
+	}{
+		{"20 6 * * *", time.Date(2019, 3, 4, 6, 20, 0, 0, time.Local), true},
+		{"20 6 * * *", time.Date(2019, 3, 4, 6, 21, 0, 0, time.Local), false},
+		{"*/15 * * * *", time.Date(2019, 3, 4, 13, 45, 0, 0, time.Local), true},
+		{"*/15 * * * *", time.Date(2019, 3, 4, 13, 46, 0, 0, time.Local), false},
+		{"0 8 * * 1-5", time.Date(2019, 3, 4, 8, 0, 0, 0, time.Local), true},  // monday
+		{"0 8 * * 1-5", time.Date(2019, 3, 9, 8, 0, 0, 0, time.Local), false}, // saturday
+		{"0 8 * * 7", time.Date(2019, 3, 10, 8, 0, 0, 0, time.Local), true},   // sunday
+		{"0 8 1 * 1", time.Date(2019, 3, 1, 8, 0, 0, 0, time.Local), true},    // day-of-month or day-of-week
+	}
+	for _, tt := range tests {
+		spec, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%s) = %v", tt.spec, err)
+		}
+		if got := spec.matches(tt.when); got != tt.want {
+			t.Errorf("cron(%s).matches(%v) = %v, want %v", tt.spec, tt.when, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%s) should fail", spec)
+		}
+	}
+}
+
+func TestMatchTopicPattern(t *testing.T) {
+	if !matchTopicPattern(microservice.NormalizeTopic("state/sensor/conbee/"), "state.sensor.conbee") {
+		t.Fail()
+	}
+	if !matchTopicPattern(microservice.NormalizeTopic("state/*/conbee/"), "state.switch.conbee") {
+		t.Fail()
+	}
+	if !matchTopicPattern(microservice.NormalizeTopic("state/>"), "state.switch.conbee") {
+		t.Fail()
+	}
+	if matchTopicPattern(microservice.NormalizeTopic("state/sensor/"), "state.sensor.conbee") {
+		t.Fail()
+	}
+}
+
+func TestAutomationConfigRulesCompile(t *testing.T) {
+	data, err := ioutil.ReadFile("../config/automation.config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.AutomationConfigFromJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, errs := compileRules(cfg.Rules)
+	for _, err := range errs {
+		t.Error(err)
+	}
+	if len(rules) != len(cfg.Rules) {
+		t.Errorf("compiled %d rules, want %d", len(rules), len(cfg.Rules))
+	}
+	for _, r := range rules {
+		for _, a := range r.actions {
+			if a.device == "" {
+				continue
+			}
+			if _, exists := cfg.DeviceControlCache[a.device]; !exists {
+				t.Errorf("rule '%s' uses unknown device '%s'", r.name, a.device)
+			}
+		}
+	}
+}
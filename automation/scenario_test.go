@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+// A scenario replays timed SensorState events into automation on a virtual clock and
+// verifies the device commands and notifications that are published.
+//
+//  {
+//      "description": "...",
+//      "start": "2019-03-04T06:00:00+01:00",
+//      "duration": "80m",
+//      "config": "../config/automation.config.json",
+//      "events": [
+//          { "at": "20m", "topic": "state/sensor/xiaomi/", "state": { "name": "Bedroom Motion", ... } }
+//      ],
+//      "expect": [
+//          { "at": "20m", "device": "Bedroom Stand", "command": "on" },
+//          { "at": "20m", "notify": "Waking up Parents" },
+//          { "at": "30m", "topic": "state/light/automation/", "payload": "..." }
+//      ]
+//  }
+//
+// The config filename is relative to the automation directory and is optional.
+// Automation runs on a microservice.Service on the memory transport, its device commands
+// that are requests are acknowledged.
+// Automation ticks every 'tick' (default 10s) of virtual time, so a published message
+// matches an expectation when it was published within one tick after 'at'. Every
+// published message needs to be expected, except for the timers state.
+type scenario struct {
+	Description string              `json:"description"`
+	Start       time.Time           `json:"start"`
+	Duration    string              `json:"duration"`
+	Tick        string              `json:"tick"`
+	Config      string              `json:"config"`
+	Events      []scenarioEvent     `json:"events"`
+	Expect      []scenarioPublished `json:"expect"`
+}
+
+type scenarioEvent struct {
+	At    string             `json:"at"`
+	Topic string             `json:"topic"`
+	State config.SensorState `json:"state"`
+	at    time.Duration
+}
+
+type scenarioPublished struct {
+	At      string `json:"at"`
+	Topic   string `json:"topic,omitempty"`
+	Payload string `json:"payload,omitempty"`
+	Device  string `json:"device,omitempty"`
+	Command string `json:"command,omitempty"`
+	Notify  string `json:"notify,omitempty"`
+	at      time.Duration
+}
+
+type virtualClock struct {
+	now time.Time
+}
+
+func (c *virtualClock) Now() time.Time {
+	return c.now
+}
+
+type publishedMessage struct {
+	at      time.Duration
+	subject string
+	payload string
+}
+
+// scenarioService is the microservice.Service of automation on the memory transport, every
+// message that it publishes is recorded at the time of the virtual clock. The requests are
+// published by RequestAsync, they are recorded before their response is handled.
+type scenarioService struct {
+	microserviceContext
+	t         *testing.T
+	clock     *virtualClock
+	start     time.Time
+	mutex     sync.Mutex
+	published []publishedMessage
+	requests  int // the requests that automation did not get the response of yet
+}
+
+func newScenarioService(t *testing.T, clock *virtualClock) *scenarioService {
+	memory := map[string]string{"transport": "memory"}
+	m := microservice.New("automation")
+	m.PubsubConfig = memory
+	m.Pubsub = pubsub.New(memory)
+	if err := m.Pubsub.Connect(m.Name, []string{"state/automation/timers/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Pubsub.Close)
+	s := &scenarioService{microserviceContext: microserviceContext{m: m}, t: t, clock: clock, start: clock.now}
+	m.Pubsub.OnPublish = func(msg *pubsub.Msg) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.published = append(s.published, publishedMessage{at: s.clock.now.Sub(s.start), subject: msg.Subject, payload: string(msg.Data)})
+	}
+	return s
+}
+
+func (s *scenarioService) Request(channel string, message string, tag string) {
+	s.requests++
+	s.microserviceContext.Request(channel, message, tag)
+}
+
+func (s *scenarioService) LogInfo(line string) {
+	s.t.Logf("[%s] %s", s.clock.now.Format("15:04:05"), line)
+}
+
+func (s *scenarioService) LogError(line string) {
+	s.t.Errorf("[%s] %s", s.clock.now.Format("15:04:05"), line)
+}
+
+// acknowledge answers the requests on 'channels' with 'reply', like a device service that
+// acknowledges its commands
+func (s *scenarioService) acknowledge(reply *microservice.Reply, channels ...string) {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	device := pubsub.New(s.m.PubsubConfig)
+	if err := device.Connect("device", nil, channels); err != nil {
+		s.t.Fatal(err)
+	}
+	s.t.Cleanup(func() { device.Drain() })
+	go func() {
+		for msg := range device.InMsgs {
+			if msg.Subject == "client/closed/" {
+				device.Close()
+				return
+			}
+			if msg.Reply != "" {
+				device.Respond(msg, data)
+			}
+		}
+	}()
+}
+
+// handleResponses hands the responses on the requests to automation, like the service
+// does, until automation does not wait for a response anymore
+func (s *scenarioService) handleResponses(auto *automation) {
+	for s.requests > 0 {
+		s.requests--
+		select {
+		case msg := <-s.m.ProcessMessages:
+			auto.handleResponse(msg.Payload)
+		case <-time.After(time.Second):
+			s.t.Fatal("no response on a request")
+		}
+	}
+}
+
+// take returns the messages that were published and forgets them
+func (s *scenarioService) take() []publishedMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	published := s.published
+	s.published = nil
+	return published
+}
+
+func parseDurationOr(value string, defaultvalue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultvalue, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func loadScenario(filename string) (*scenario, *config.AutomationConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc := &scenario{Config: "../config/automation.config.json"}
+	if err = json.Unmarshal(data, sc); err != nil {
+		return nil, nil, err
+	}
+	for i := range sc.Events {
+		if sc.Events[i].at, err = parseDurationOr(sc.Events[i].At, 0); err != nil {
+			return nil, nil, err
+		}
+	}
+	sort.SliceStable(sc.Events, func(i, j int) bool { return sc.Events[i].at < sc.Events[j].at })
+
+	cfgdata, err := ioutil.ReadFile(sc.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := config.AutomationConfigFromJSON(cfgdata)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Resolve device commands and notifications to the messages that should be published
+	for i := range sc.Expect {
+		e := &sc.Expect[i]
+		if e.at, err = parseDurationOr(e.At, 0); err != nil {
+			return nil, nil, err
+		}
+		if e.Device != "" {
+			dc, exists := cfg.DeviceControlCache[e.Device]
+			if !exists {
+				return nil, nil, fmt.Errorf("unknown device '%s'", e.Device)
+			}
+			e.Topic = dc.Channel
+			switch e.Command {
+			case "on":
+				e.Payload = dc.On
+			case "off":
+				e.Payload = dc.Off
+			case "toggle":
+				e.Payload = dc.Toggle
+			default:
+				return nil, nil, fmt.Errorf("unknown command '%s' for device '%s'", e.Command, e.Device)
+			}
+		} else if e.Notify != "" {
+			e.Topic = "shout/message/"
+			e.Payload = e.Notify
+		}
+	}
+
+	// Scenarios always run with in-memory timers
+	cfg.Timers = config.AutomationTimers{}
+	return sc, cfg, nil
+}
+
+func runScenario(t *testing.T, filename string) {
+	sc, cfg, err := loadScenario(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duration, err := parseDurationOr(sc.Duration, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tick, err := parseDurationOr(sc.Tick, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &virtualClock{now: sc.Start}
+	service := newScenarioService(t, clock)
+	auto := new(clock, service)
+	cfgdata, err := cfg.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auto.handleConfig(cfgdata) {
+		t.Fatal("configuration was not accepted")
+	}
+	for _, channel := range auto.config.ChannelsToRegister {
+		service.m.Register(channel)
+	}
+	acknowledged := map[string]bool{}
+	for _, dc := range auto.config.DeviceControlCache {
+		if dc.Acknowledged() && !acknowledged[dc.Channel] {
+			acknowledged[dc.Channel] = true
+			service.acknowledge(microservice.Succeeded(nil), dc.Channel)
+		}
+	}
+
+	events := sc.Events
+	for at := time.Duration(0); at <= duration; at += tick {
+		for len(events) > 0 && events[0].at <= at {
+			e := events[0]
+			events = events[1:]
+			clock.now = sc.Start.Add(e.at)
+			e.State.Time = clock.now
+			data, err := e.State.ToJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			auto.handleMessage(microservice.NormalizeTopic(e.Topic), data)
+			service.handleResponses(auto)
+		}
+		clock.now = sc.Start.Add(at)
+		auto.tick()
+		service.handleResponses(auto)
+	}
+
+	published := service.take()
+	matched := make([]bool, len(published))
+	for _, e := range sc.Expect {
+		found := false
+		for i, p := range published {
+			if !matched[i] && p.subject == microservice.NormalizeTopic(e.Topic) && p.payload == e.Payload && p.at >= e.at && p.at <= e.at+tick {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected at %v on '%s': %s", e.at, e.Topic, e.Payload)
+		}
+	}
+	for i, p := range published {
+		if !matched[i] && p.subject != microservice.NormalizeTopic("state/automation/timers/") {
+			t.Errorf("unexpected at %v on '%s': %s", p.at, p.subject, p.payload)
+		}
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	filenames, err := filepath.Glob(filepath.Join("testdata", "scenarios", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) == 0 {
+		t.Fatal("no scenarios found")
+	}
+	for _, filename := range filenames {
+		filename := filename
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			runScenario(t, filename)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Timer is a pending action identified by Name that is due at When. Data is owned
+// by the user of the scheduler and should hold everything that is needed to execute
+// the action, also after a restart of the process.
+type Timer struct {
+	Name  string          `json:"name"`
+	Since time.Time       `json:"since"`
+	When  time.Time       `json:"when"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// Store is where the scheduler persists its pending timers
+type Store interface {
+	Load() ([]*Timer, error)
+	Save(timers []*Timer) error
+}
+
+// CatchUp policies decide what happens with timers that became overdue while
+// the process was not running:
+//   - "all": all overdue timers will fire
+//   - "none": all overdue timers are dropped
+//   - "window": only timers that are overdue for less than 'Window' will fire
+type CatchUp struct {
+	Policy string
+	Window time.Duration
+}
+
+// Scheduler holds the pending timers and persists them in a Store
+type Scheduler struct {
+	timers  map[string]*Timer
+	store   Store
+	catchup CatchUp
+	dirty   bool
+}
+
+// New creates a scheduler, 'store' can be nil in which case nothing is persisted
+func New(store Store, catchup CatchUp) *Scheduler {
+	s := &Scheduler{}
+	s.timers = map[string]*Timer{}
+	s.store = store
+	s.catchup = catchup
+	return s
+}
+
+// Restore loads the timers from the store and applies the catch-up policy to the
+// timers that are overdue. Overdue timers that are kept stay pending, so the user fires
+// them like any other timer that is due, and the timers that are dropped are returned.
+func (s *Scheduler) Restore(now time.Time) (dropped []*Timer, err error) {
+	if s.store == nil {
+		return nil, nil
+	}
+	timers, err := s.store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range timers {
+		if t.When.Before(now) && !s.catchup.allows(now.Sub(t.When)) {
+			dropped = append(dropped, t)
+			s.dirty = true
+			continue
+		}
+		s.timers[t.Name] = t
+	}
+	return dropped, nil
+}
+
+func (c CatchUp) allows(overdue time.Duration) bool {
+	switch c.Policy {
+	case "none":
+		return false
+	case "window":
+		return overdue <= c.Window
+	}
+	return true
+}
+
+// Set adds or replaces the timer with 'name', 'data' is marshalled to JSON
+func (s *Scheduler) Set(name string, since time.Time, when time.Time, data interface{}) error {
+	t := &Timer{Name: name, Since: since, When: when}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("timer '%s': %s", name, err.Error())
+		}
+		t.Data = raw
+	}
+	s.timers[name] = t
+	s.dirty = true
+	return nil
+}
+
+// Get returns the timer with 'name'
+func (s *Scheduler) Get(name string) (*Timer, bool) {
+	t, exists := s.timers[name]
+	return t, exists
+}
+
+// Remove deletes the timer with 'name'
+func (s *Scheduler) Remove(name string) {
+	if _, exists := s.timers[name]; exists {
+		delete(s.timers, name)
+		s.dirty = true
+	}
+}
+
+// Pending returns all timers sorted on when they are due
+func (s *Scheduler) Pending() []*Timer {
+	timers := make([]*Timer, 0, len(s.timers))
+	for _, t := range s.timers {
+		timers = append(timers, t)
+	}
+	sort.Slice(timers, func(i, j int) bool {
+		if timers[i].When.Equal(timers[j].When) {
+			return timers[i].Name < timers[j].Name
+		}
+		return timers[i].When.Before(timers[j].When)
+	})
+	return timers
+}
+
+// Changed returns true when the timers have changed since the last Flush
+func (s *Scheduler) Changed() bool {
+	return s.dirty
+}
+
+// Flush saves the timers to the store when they have changed
+func (s *Scheduler) Flush() error {
+	if !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Save(s.Pending())
+}
+
+// Decode unmarshals the Data of the timer into 'v'
+func (t *Timer) Decode(v interface{}) error {
+	if len(t.Data) == 0 {
+		return fmt.Errorf("timer '%s' has no data", t.Name)
+	}
+	return json.Unmarshal(t.Data, v)
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testData struct {
+	Rule   string `json:"rule"`
+	Action int    `json:"action"`
+}
+
+func TestSchedulerPending(t *testing.T) {
+	now := time.Date(2019, 3, 4, 6, 0, 0, 0, time.UTC)
+	s := New(nil, CatchUp{})
+	s.Set("b", now, now.Add(10*time.Minute), &testData{Rule: "b"})
+	s.Set("a", now, now.Add(5*time.Minute), &testData{Rule: "a"})
+	s.Set("c", now, now.Add(15*time.Minute), nil)
+
+	pending := s.Pending()
+	if len(pending) != 3 || pending[0].Name != "a" || pending[1].Name != "b" || pending[2].Name != "c" {
+		t.Fatalf("Pending() returned %v, want [a b c]", pending)
+	}
+	data := &testData{}
+	if err := pending[1].Decode(data); err != nil || data.Rule != "b" {
+		t.Errorf("Decode() = %v, %v", data, err)
+	}
+	s.Remove("a")
+	s.Remove("b")
+	if pending := s.Pending(); len(pending) != 1 || pending[0].Name != "c" {
+		t.Errorf("Pending() = %v, want [c]", pending)
+	}
+}
+
+func TestSchedulerRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "timers.json")
+
+	then := time.Date(2019, 3, 4, 6, 0, 0, 0, time.UTC)
+	s := New(NewFileStore(filename), CatchUp{})
+	s.Set("long overdue", then, then.Add(time.Minute), &testData{Rule: "x"})
+	s.Set("just overdue", then, then.Add(50*time.Minute), &testData{Rule: "y"})
+	s.Set("pending", then, then.Add(2*time.Hour), &testData{Rule: "z"})
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := then.Add(time.Hour)
+	tests := []struct {
+		catchup CatchUp
+		dropped int
+		due     int
+	}{
+		{CatchUp{Policy: "all"}, 0, 2},
+		{CatchUp{Policy: "none"}, 2, 0},
+		{CatchUp{Policy: "window", Window: 30 * time.Minute}, 1, 1},
+	}
+	for _, tt := range tests {
+		r := New(NewFileStore(filename), tt.catchup)
+		dropped, err := r.Restore(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dropped) != tt.dropped {
+			t.Errorf("policy %s dropped %d timers, want %d", tt.catchup.Policy, len(dropped), tt.dropped)
+		}
+		due := 0
+		for _, timer := range r.Pending() {
+			if timer.When.Before(now) {
+				due++
+			}
+		}
+		if due != tt.due {
+			t.Errorf("policy %s has %d due timers, want %d", tt.catchup.Policy, due, tt.due)
+		}
+		if _, exists := r.Get("pending"); !exists {
+			t.Errorf("policy %s lost the pending timer", tt.catchup.Policy)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// FileStore persists timers as JSON in a file
+type FileStore struct {
+	Filename string
+}
+
+// NewFileStore returns a store that saves the timers in 'filename'
+func NewFileStore(filename string) *FileStore {
+	return &FileStore{Filename: filename}
+}
+
+// Load reads the timers from the file, a missing file means there are no timers
+func (f *FileStore) Load() ([]*Timer, error) {
+	data, err := ioutil.ReadFile(f.Filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	timers := []*Timer{}
+	err = json.Unmarshal(data, &timers)
+	return timers, err
+}
+
+// Save writes the timers to a temporary file and then renames it so that a crash
+// while writing never leaves a half written file behind.
+func (f *FileStore) Save(timers []*Timer) error {
+	data, err := json.MarshalIndent(timers, "", "    ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(f.Filename)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(f.Filename))
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Filename)
+}
+
+// KeyValueStore persists timers in a NATS JetStream key-value bucket
+type KeyValueStore struct {
+	Bucket string
+	Key    string
+	conn   func() *nats.Conn
+}
+
+// NewKeyValueStore returns a store that saves the timers under 'key' in 'bucket', the
+// connection is obtained through 'conn' since it can change when reconnecting.
+func NewKeyValueStore(bucket string, key string, conn func() *nats.Conn) *KeyValueStore {
+	return &KeyValueStore{Bucket: bucket, Key: key, conn: conn}
+}
+
+func (k *KeyValueStore) keyValue() (nats.KeyValue, error) {
+	nc := k.conn()
+	if nc == nil {
+		return nil, fmt.Errorf("key-value bucket '%s' is not available when not connected", k.Bucket)
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	kv, err := js.KeyValue(k.Bucket)
+	if err == nats.ErrBucketNotFound {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: k.Bucket})
+	}
+	return kv, err
+}
+
+// Load reads the timers from the bucket
+func (k *KeyValueStore) Load() ([]*Timer, error) {
+	kv, err := k.keyValue()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := kv.Get(k.Key)
+	if err == nats.ErrKeyNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	timers := []*Timer{}
+	err = json.Unmarshal(entry.Value(), &timers)
+	return timers, err
+}
+
+// Save writes the timers to the bucket
+func (k *KeyValueStore) Save(timers []*Timer) error {
+	kv, err := k.keyValue()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(timers)
+	if err != nil {
+		return err
+	}
+	_, err = kv.Put(k.Key, data)
+	return err
+}
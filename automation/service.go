@@ -0,0 +1,66 @@
+package main
+
+import (
+	"time"
+
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+	nats "github.com/nats-io/nats.go"
+)
+
+// clock is where automation gets the current time from, this allows tests to run
+// scenarios on a virtual clock.
+type clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (c realClock) Now() time.Time {
+	return time.Now()
+}
+
+// requestTimeout is the time that a device service has to reply on a command
+const requestTimeout = 5 * time.Second
+
+// serviceContext is the part of the micro-service that automation depends on
+type serviceContext interface {
+	Publish(channel string, message []byte) error
+	PublishStr(channel string, message string) error
+	// Request sends a request without waiting, the reply is handled by handleResponse
+	Request(channel string, message string, tag string)
+	LogInfo(line string)
+	LogError(line string)
+	Conn() *nats.Conn
+}
+
+// microserviceContext implements serviceContext on top of a microservice.Service
+type microserviceContext struct {
+	m *microservice.Service
+}
+
+func (s *microserviceContext) Publish(channel string, message []byte) error {
+	return s.m.Pubsub.Publish(channel, message)
+}
+
+func (s *microserviceContext) PublishStr(channel string, message string) error {
+	return s.m.Pubsub.PublishStr(channel, message)
+}
+
+func (s *microserviceContext) Request(channel string, message string, tag string) {
+	s.m.RequestAsync(channel, []byte(message), requestTimeout, "automation/response/", tag)
+}
+
+func (s *microserviceContext) LogInfo(line string) {
+	s.m.Logger.LogInfo(s.m.Name, line)
+}
+
+func (s *microserviceContext) LogError(line string) {
+	s.m.Logger.LogError(s.m.Name, line)
+}
+
+func (s *microserviceContext) Conn() *nats.Conn {
+	if s.m.Pubsub == nil {
+		return nil
+	}
+	return s.m.Pubsub.Conn()
+}
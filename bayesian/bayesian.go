@@ -1,6 +1,10 @@
 package bayesian
 
-import "sort"
+import (
+	"math"
+	"sort"
+	"time"
+)
 
 type dataInput struct {
 	id             int64
@@ -8,23 +12,47 @@ type dataInput struct {
 	probGivenTrue  float64
 	probGivenFalse float64
 	state          bool
+	stamp          time.Time
+	halfLife       time.Duration
 }
 
 type observation struct {
+	id        int64
 	probTrue  float64
 	probFalse float64
+	active    bool
+	weight    float64
+}
+
+// Contribution explains how much an input contributed to the current probability
+type Contribution struct {
+	ID      int64   // The ID of the input
+	Active  bool    // The input is in its 'true' state and contributes
+	Weight  float64 // 1.0 for a fresh observation, decays to 0.0 according to the half-life
+	LogOdds float64 // The (weighted) log-likelihood ratio that the input adds to the log-odds
+	Without float64 // The probability when this input would not contribute
+}
+
+// Sample is a probability computed at a certain time
+type Sample struct {
+	Time        time.Time
+	Probability float64
 }
 
 // Instance is the Bayesian object
 type Instance struct {
-	inputs             map[int64]*dataInput
-	observations       []*observation
-	observationsSorted []int
-	id2Observation     map[int64]int
+	inputs         map[int64]*dataInput
+	observations   []*observation
+	id2Observation map[int64]int
 
 	prior       float64
 	threshold   float64
 	probability float64
+	now         time.Time
+
+	history      []Sample
+	historyHead  int
+	historyCount int
 }
 
 // New creates a new instance of a Bayesian object
@@ -32,7 +60,6 @@ func New(prior float64, threshold float64) *Instance {
 	inst := &Instance{}
 	inst.inputs = map[int64]*dataInput{}
 	inst.observations = []*observation{}
-	inst.observationsSorted = []int{}
 	inst.id2Observation = map[int64]int{}
 	inst.prior = prior
 	inst.threshold = threshold
@@ -46,60 +73,143 @@ func (b *Instance) AddInput(id int64, truestate bool, probGivenTrue float64, pro
 	b.inputs[id] = input
 }
 
+// AddDecayingInput adds an input of which the contribution halves every 'halfLife' after
+// the time it was set to its 'true' state with SetInputStateAt.
+func (b *Instance) AddDecayingInput(id int64, truestate bool, probGivenTrue float64, probGivenFalse float64, halfLife time.Duration) {
+	input := &dataInput{id: id, trueState: truestate, probGivenTrue: probGivenTrue, probGivenFalse: probGivenFalse, state: false, halfLife: halfLife}
+	b.inputs[id] = input
+}
+
 // SetInputState will set the input state of one of the inputs that is identified by ID 'id'
 func (b *Instance) SetInputState(id int64, state bool) {
 	input, contains := b.inputs[id]
 	if contains {
 		input.state = state
+		input.stamp = time.Time{}
 	}
 }
 
+// SetInputStateAt will set the input state of one of the inputs that is identified by ID 'id'
+// together with the time at which it was observed, this is where the decay starts from.
+func (b *Instance) SetInputStateAt(id int64, state bool, stamp time.Time) {
+	input, contains := b.inputs[id]
+	if contains {
+		input.state = state
+		input.stamp = stamp
+	}
+}
+
+// SetHistorySize sets the number of probabilities that are remembered, the history is cleared
+func (b *Instance) SetHistorySize(size int) {
+	b.history = make([]Sample, size)
+	b.historyHead = 0
+	b.historyCount = 0
+}
+
 // ReadState will return true/false according to the bayesian computation
 func (b *Instance) ReadState() bool {
+	return b.ReadStateAt(time.Now())
+}
+
+// ReadStateAt will return true/false according to the bayesian computation at time 'now',
+// the probability is also added to the history.
+func (b *Instance) ReadStateAt(now time.Time) bool {
+	b.now = now
 	b.processState()
 	b.updateState()
+	b.record(Sample{Time: now, Probability: b.probability})
 	return b.probability >= b.threshold
 }
 
-func (b *Instance) processState() {
-	for _, input := range b.inputs {
-		// Add entity to current observations if state conditions are met
-		if input.state {
-			probtrue := input.probGivenTrue
-			probfalse := input.probGivenFalse
+// Probability returns the probability that was computed by the last call to ReadState
+func (b *Instance) Probability() float64 {
+	return b.probability
+}
 
-			var obs *observation
-			obsi, exists := b.id2Observation[input.id]
-			if !exists {
-				obs = &observation{probTrue: probtrue, probFalse: probfalse}
-				obsi = len(b.observations)
+// Contributions returns, ordered by input ID, how every input contributed to the probability
+// that was computed by the last call to ReadState.
+func (b *Instance) Contributions() []Contribution {
+	contributions := make([]Contribution, 0, len(b.observations))
+	for _, obs := range b.observations {
+		c := Contribution{ID: obs.id, Active: obs.active, Without: b.probability}
+		if obs.active {
+			c.Weight = obs.weight
+			c.LogOdds = obs.weight * math.Log(obs.probTrue/obs.probFalse)
+			c.Without = b.compute(obs)
+		}
+		contributions = append(contributions, c)
+	}
+	sort.Slice(contributions, func(i, j int) bool { return contributions[i].ID < contributions[j].ID })
+	return contributions
+}
 
-				b.observations = append(b.observations, obs)
+// History returns the remembered probabilities, oldest first
+func (b *Instance) History() []Sample {
+	samples := make([]Sample, 0, b.historyCount)
+	for i := 0; i < b.historyCount; i++ {
+		samples = append(samples, b.history[(b.historyHead+len(b.history)-b.historyCount+i)%len(b.history)])
+	}
+	return samples
+}
 
-				b.observationsSorted = append(b.observationsSorted, obsi)
-				sort.Ints(b.observationsSorted)
+func (b *Instance) record(sample Sample) {
+	if len(b.history) == 0 {
+		return
+	}
+	b.history[b.historyHead] = sample
+	b.historyHead = (b.historyHead + 1) % len(b.history)
+	if b.historyCount < len(b.history) {
+		b.historyCount++
+	}
+}
 
-				b.id2Observation[input.id] = obsi
-			}
-			obs.probTrue = probtrue
-			obs.probFalse = probfalse
-		} else {
-			obsi, exists := b.id2Observation[input.id]
-			if exists {
-				sobsi := sort.SearchInts(b.observationsSorted, obsi)
-				b.observationsSorted = append(b.observationsSorted[:sobsi], b.observationsSorted[sobsi+1:]...)
-				delete(b.id2Observation, input.id)
-			}
+func (b *Instance) processState() {
+	for _, input := range b.inputs {
+		obsi, exists := b.id2Observation[input.id]
+		if !exists {
+			obsi = len(b.observations)
+			b.observations = append(b.observations, &observation{id: input.id})
+			b.id2Observation[input.id] = obsi
 		}
+
+		// An input only contributes to the computation when it is in its 'true' state
+		obs := b.observations[obsi]
+		obs.active = input.state == input.trueState
+		obs.probTrue = input.probGivenTrue
+		obs.probFalse = input.probGivenFalse
+		obs.weight = input.weight(b.now)
+	}
+}
+
+// weight returns how much the input still counts at time 'now'
+func (input *dataInput) weight(now time.Time) float64 {
+	if input.halfLife <= 0 || input.stamp.IsZero() || !now.After(input.stamp) {
+		return 1.0
 	}
+	return math.Pow(0.5, float64(now.Sub(input.stamp))/float64(input.halfLife))
 }
 
 func (b *Instance) updateState() {
+	b.probability = b.compute(nil)
+}
+
+// compute returns the probability of all active observations except 'exclude'
+func (b *Instance) compute(exclude *observation) float64 {
 	prior := b.prior
 	for _, obs := range b.observations {
-		prior = b.computeProbability(prior, obs.probTrue, obs.probFalse)
+		if obs.active && obs != exclude {
+			// A decayed observation has its likelihood ratio raised to the power
+			// of its weight, it moves towards 1.0 and thus has less influence.
+			probTrue := obs.probTrue
+			probFalse := obs.probFalse
+			if obs.weight != 1.0 {
+				probTrue = math.Pow(probTrue, obs.weight)
+				probFalse = math.Pow(probFalse, obs.weight)
+			}
+			prior = b.computeProbability(prior, probTrue, probFalse)
+		}
 	}
-	b.probability = prior
+	return prior
 }
 
 func (b *Instance) computeProbability(prior float64, probTrue float64, probFalse float64) float64 {
@@ -4,6 +4,7 @@ import (
 	"math"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const float64EqualityThreshold = 1e-9
@@ -63,7 +64,7 @@ func TestInstance_SetInputState(t *testing.T) {
 		{name: "test input 1", b: &Instance{inputs: map[int64]*dataInput{0: &dataInput{id: 0, trueState: true, probGivenTrue: 0.95, probGivenFalse: 0.7, state: true}}, id2Observation: map[int64]int{}, prior: 0.7, threshold: 0.9}, args: args{id: 0, state: false}, want: false},
 		{name: "test input 2", b: &Instance{inputs: map[int64]*dataInput{0: &dataInput{id: 0, trueState: true, probGivenTrue: 0.95, probGivenFalse: 0.7, state: true}}, id2Observation: map[int64]int{}, prior: 0.7, threshold: 0.9}, args: args{id: 0, state: true}, want: false},
 		{name: "test input 3", b: &Instance{inputs: map[int64]*dataInput{0: &dataInput{id: 0, trueState: true, probGivenTrue: 0.95, probGivenFalse: 0.7, state: true}}, id2Observation: map[int64]int{}, prior: 0.7, threshold: 0.9}, args: args{id: 0, state: false}, want: false},
-		{name: "test input 4", b: &Instance{inputs: map[int64]*dataInput{0: &dataInput{id: 0, trueState: true, probGivenTrue: 0.95, probGivenFalse: 0.7, state: true}}, id2Observation: map[int64]int{}, prior: 0.7, threshold: 0.9}, args: args{id: 0, state: true}, want: true},
+		{name: "test input 4", b: &Instance{inputs: map[int64]*dataInput{0: &dataInput{id: 0, trueState: true, probGivenTrue: 0.95, probGivenFalse: 0.2, state: true}}, id2Observation: map[int64]int{}, prior: 0.7, threshold: 0.9}, args: args{id: 0, state: true}, want: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -147,3 +148,75 @@ func TestInstance_computeProbability(t *testing.T) {
 		})
 	}
 }
+
+func TestInstance_Probability(t *testing.T) {
+	b := New(0.5, 0.9)
+	b.AddInput(0, true, 0.9, 0.2)
+	b.AddInput(1, false, 0.8, 0.4)
+
+	b.SetInputState(0, true)
+	b.SetInputState(1, true)
+	b.ReadState()
+	if want := b.computeProbability(0.5, 0.9, 0.2); !almostEqual(b.Probability(), want) {
+		t.Errorf("Instance.Probability() = %v, want %v", b.Probability(), want)
+	}
+
+	// An input that leaves its 'true' state should no longer contribute
+	b.SetInputState(0, false)
+	b.SetInputState(1, false)
+	b.ReadState()
+	if want := b.computeProbability(0.5, 0.8, 0.4); !almostEqual(b.Probability(), want) {
+		t.Errorf("Instance.Probability() = %v, want %v", b.Probability(), want)
+	}
+
+	b.SetInputState(1, true)
+	if b.ReadState() || !almostEqual(b.Probability(), 0.5) {
+		t.Errorf("Instance.Probability() = %v, want %v", b.Probability(), 0.5)
+	}
+}
+
+func TestInstance_DecayingInput(t *testing.T) {
+	now := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+	b := New(0.5, 0.8)
+	b.AddDecayingInput(0, true, 0.9, 0.1, 10*time.Minute)
+	b.SetInputStateAt(0, true, now)
+
+	if !b.ReadStateAt(now) || !almostEqual(b.Probability(), 0.9) {
+		t.Errorf("Instance.Probability() = %v, want %v", b.Probability(), 0.9)
+	}
+
+	// After one half-life the likelihood ratio of 9 has become 3
+	if b.ReadStateAt(now.Add(10*time.Minute)) || !almostEqual(b.Probability(), 0.75) {
+		t.Errorf("Instance.Probability() = %v, want %v", b.Probability(), 0.75)
+	}
+	c := b.Contributions()
+	if len(c) != 1 || !c[0].Active || !almostEqual(c[0].Weight, 0.5) || !almostEqual(c[0].LogOdds, math.Log(3)) || !almostEqual(c[0].Without, 0.5) {
+		t.Errorf("Instance.Contributions() = %v", c)
+	}
+
+	if b.ReadStateAt(now.Add(24 * time.Hour)); math.Abs(b.Probability()-0.5) > 1e-6 {
+		t.Errorf("Instance.Probability() = %v, want %v", b.Probability(), 0.5)
+	}
+}
+
+func TestInstance_History(t *testing.T) {
+	now := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+	b := New(0.5, 0.8)
+	b.AddInput(0, true, 0.9, 0.1)
+	b.SetHistorySize(3)
+	for i := 0; i < 5; i++ {
+		b.SetInputState(0, i%2 == 0)
+		b.ReadStateAt(now.Add(time.Duration(i) * time.Minute))
+	}
+
+	history := b.History()
+	want := []Sample{{now.Add(2 * time.Minute), 0.9}, {now.Add(3 * time.Minute), 0.5}, {now.Add(4 * time.Minute), 0.9}}
+	if len(history) != len(want) {
+		t.Fatalf("Instance.History() = %v, want %v", history, want)
+	}
+	for i := range want {
+		if !history[i].Time.Equal(want[i].Time) || !almostEqual(history[i].Probability, want[i].Probability) {
+			t.Errorf("Instance.History()[%d] = %v, want %v", i, history[i], want[i])
+		}
+	}
+}
@@ -101,25 +101,28 @@ func main() {
 		return true
 	})
 
-	m.RegisterHandler("state/bravia.tv/*/", func(m *microservice.Service, topic string, msg []byte) bool {
+	m.RegisterReplyHandler("state/bravia.tv/*/", func(m *microservice.Service, topic string, msg []byte) *microservice.Reply {
 		m.Logger.LogInfo(m.Name, "received state")
 		state, err := config.SensorStateFromJSON(msg)
-		if err == nil {
-			power := state.GetValueAttr("power", "none")
-			c.changePower(state.Name, power)
-			if power != "none" {
-				m.Logger.LogInfo(m.Name, fmt.Sprintf("TV '%s'; power -> '%s'", state.Name, power))
-			}
+		if err != nil {
+			return microservice.Failed(err)
+		}
+		if _, exists := c.tvs[state.Name]; !exists {
+			return microservice.Failed(fmt.Errorf("TV '%s' doesn't exist", state.Name))
+		}
 
-			input := state.GetValueAttr("input", "none")
-			c.changeInput(state.Name, input)
-			if input != "none" {
-				m.Logger.LogInfo(m.Name, fmt.Sprintf("TV '%s'; input -> '%s'", state.Name, input))
-			}
-		} else {
-			m.Logger.LogError(m.Name, err.Error())
+		power := state.GetValueAttr("power", "none")
+		c.changePower(state.Name, power)
+		if power != "none" {
+			m.Logger.LogInfo(m.Name, fmt.Sprintf("TV '%s'; power -> '%s'", state.Name, power))
 		}
-		return true
+
+		input := state.GetValueAttr("input", "none")
+		c.changeInput(state.Name, input)
+		if input != "none" {
+			m.Logger.LogInfo(m.Name, fmt.Sprintf("TV '%s'; input -> '%s'", state.Name, input))
+		}
+		return microservice.Succeeded(nil)
 	})
 
 	tickCount := 0
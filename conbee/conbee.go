@@ -1,21 +1,27 @@
 package main
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/jurgen-kluft/go-home/conbee/deconz"
 	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
 )
 
 /*
 STATE
 
 State {Read} [
+	Websocket events of motion, contact and switch sensors and of lights
+	Polling of the state of all lights (reachability, last-seen)
+	Polling of the battery and reachability of the sensors and switches (health)
 ]
 
 State {Write} [
+	On/Off/Toggle, CT and BRI of lights and groups
 ]
 
 When turning ON a light from automation logic we inform Conbee. We will keep
@@ -26,11 +32,14 @@ reading the state which will be the only factual state.
 type lightState struct {
 	Name      string
 	IDs       []string
+	Group     string
 	LastSeen  time.Time
 	CT        float32
 	BRI       float32
 	Reachable bool
 	OnOff     bool
+	reachable map[string]bool // per light unique-id
+	on        map[string]bool // per light unique-id
 }
 
 type motionSensorState struct {
@@ -48,124 +57,593 @@ type contactSensorState struct {
 }
 
 type switchState struct {
-	Name     string
-	ID       string
-	LastSeen time.Time
-	Contact  bool
+	Name        string
+	ID          string
+	LastSeen    time.Time
+	ButtonEvent int
+}
+
+// deviceHealth is the battery, reachability and last-seen of a sensor or switch,
+// Battery is a percentage and -1 when it is not known.
+type deviceHealth struct {
+	Name        string
+	ID          string
+	Device      string // motion, contact or switch
+	BatteryType string
+	LastSeen    time.Time
+	Battery     float64
+	Reachable   bool
 }
 
 type fullstate struct {
-	switches       map[string]switchState
-	motionSensors  map[string]motionSensorState
-	contactSensors map[string]contactSensorState
-	lights         map[string]*lightState
+	switches       map[string]*switchState
+	motionSensors  map[string]*motionSensorState
+	contactSensors map[string]*contactSensorState
+	lights         map[string]*lightState // per light unique-id
+	lightList      []*lightState
+	health         map[string]*deviceHealth // per sensor unique-id
+	healthList     []*deviceHealth
 }
 
 func configToFullState(c config.ConbeeConfig) fullstate {
 	full := fullstate{}
-	full.switches = make(map[string]switchState)
-	full.motionSensors = make(map[string]motionSensorState)
-	full.contactSensors = make(map[string]contactSensorState)
+	full.switches = make(map[string]*switchState)
+	full.motionSensors = make(map[string]*motionSensorState)
+	full.contactSensors = make(map[string]*contactSensorState)
 	full.lights = make(map[string]*lightState)
+	full.health = make(map[string]*deviceHealth)
 
+	addHealth := func(e config.ConbeeDevice, device string) {
+		health := &deviceHealth{Name: e.Name, ID: e.ID, Device: device, BatteryType: e.BatteryType, LastSeen: time.Now(), Battery: -1, Reachable: true}
+		full.health[health.ID] = health
+		full.healthList = append(full.healthList, health)
+	}
 	for _, e := range c.Switches {
-		state := switchState{Name: e.Name, ID: e.ID, LastSeen: time.Now(), Contact: false}
+		state := &switchState{Name: e.Name, ID: e.ID, LastSeen: time.Now()}
 		full.switches[state.ID] = state
+		addHealth(e, "switch")
 	}
 	for _, e := range c.Sensors.Motion {
-		state := motionSensorState{Name: e.Name, ID: e.ID, LastSeen: time.Now(), Motion: false}
+		state := &motionSensorState{Name: e.Name, ID: e.ID, LastSeen: time.Now(), Motion: false}
 		full.motionSensors[state.ID] = state
+		addHealth(e, "motion")
 	}
 	for _, e := range c.Sensors.Contact {
-		state := contactSensorState{Name: e.Name, ID: e.ID, LastSeen: time.Now(), Contact: false}
+		state := &contactSensorState{Name: e.Name, ID: e.ID, LastSeen: time.Now(), Contact: false}
 		full.contactSensors[state.ID] = state
+		addHealth(e, "contact")
 	}
 	for _, e := range c.Lights {
-		state := &lightState{Name: e.Name, IDs: e.IDS, LastSeen: time.Now(), Reachable: false, OnOff: false}
+		state := &lightState{Name: e.Name, IDs: e.IDS, Group: e.Group, LastSeen: time.Now(), Reachable: false, OnOff: false}
+		state.reachable = map[string]bool{}
+		state.on = map[string]bool{}
 		for _, id := range state.IDs {
 			full.lights[id] = state
 		}
+		full.lightList = append(full.lightList, state)
 	}
 
 	return full
 }
 
-func main() {
-	config := defaultConfiguration()
+// deviceEvent is a websocket event as it is passed from the event reader onto the
+// process messages channel of the micro-service.
+type deviceEvent struct {
+	UniqueID string          `json:"uniqueid"`
+	Resource string          `json:"resource"`
+	Type     string          `json:"type"`
+	Name     string          `json:"name"`
+	State    json.RawMessage `json:"state"`
+}
+
+// deviceState holds the state attributes of sensors and lights that we are interested in
+type deviceState struct {
+	Presence    *bool `json:"presence"`
+	Open        *bool `json:"open"`
+	ButtonEvent *int  `json:"buttonevent"`
+	On          *bool `json:"on"`
+	Bri         *int  `json:"bri"`
+	CT          *int  `json:"ct"`
+	Reachable   *bool `json:"reachable"`
+}
+
+// published is a sensor state that should be published on a channel
+type published struct {
+	channel string
+	state   *config.SensorState
+}
+
+// command is a light state that should be PUT to a deCONZ light or group
+type command struct {
+	light string
+	group string
+	state *deconz.LightState
+}
+
+type conbee struct {
+	config  *config.ConbeeConfig
+	api     *deconz.API
+	state   fullstate
+	restIDs map[string]string // light unique-id -> deCONZ REST id
+	reader  *deconz.DeviceEventReader
+	polling bool
+}
 
-	deconzConfig := deconz.Config{Addr: config.Addr, APIKey: config.APIKey}
-	eventChan, err := eventChan(deconzConfig)
+func new() *conbee {
+	c := &conbee{}
+	c.restIDs = map[string]string{}
+	return c
+}
+
+func (c *conbee) initialize(jsondata []byte) (err error) {
+	c.config, err = config.ConbeeConfigFromJSON(jsondata)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if c.config.PollIntervalSec <= 0 {
+		c.config.PollIntervalSec = 60
 	}
+	c.state = configToFullState(*c.config)
+	apikey := ""
+	if c.config.APIKey.Name != "" {
+		if apikey, err = c.config.APIKey.Value(); err != nil {
+			return err
+		}
+	}
+	c.api = &deconz.API{Config: deconz.Config{Addr: c.config.Addr, APIKey: apikey}}
+	return nil
+}
 
-	log.Printf("Connected to deCONZ at %s", deconzConfig.Addr)
+// clickOfButtonEvent converts a button event (e.g. 1002) to the click names that
+// automation uses, the thousands are the button and the remainder is the action.
+func clickOfButtonEvent(buttonevent int) string {
+	switch buttonevent % 1000 {
+	case 1:
+		return "long press"
+	case 2:
+		return "single click"
+	case 4:
+		return "double click"
+	case 5:
+		return "triple click"
+	}
+	return ""
+}
 
-	//TODO: figure out how to create a timer that is stopped
-	timeout := time.NewTimer(1 * time.Second)
-	timeout.Stop()
+// handleEvent updates the state from a websocket event and returns what to publish
+func (c *conbee) handleEvent(ev *deviceEvent, now time.Time) ([]published, error) {
+	ds := deviceState{}
+	if err := json.Unmarshal(ev.State, &ds); err != nil {
+		return nil, fmt.Errorf("unable to decode state of '%s': %s", ev.Name, err)
+	}
 
-	for {
+	result := []published{}
+	if health, exists := c.state.health[ev.UniqueID]; exists {
+		health.LastSeen = now
+	}
+	if motion, exists := c.state.motionSensors[ev.UniqueID]; exists && ds.Presence != nil {
+		motion.Motion = *ds.Presence
+		motion.LastSeen = now
+		sensor := config.NewSensorState(motion.Name, "motion")
+		sensor.Time = now
+		sensor.AddStringAttr("motion", onOff(motion.Motion))
+		result = append(result, published{channel: c.config.SensorsOut, state: sensor})
+	}
+	if contact, exists := c.state.contactSensors[ev.UniqueID]; exists && ds.Open != nil {
+		contact.Contact = *ds.Open
+		contact.LastSeen = now
+		sensor := config.NewSensorState(contact.Name, "contact")
+		sensor.Time = now
+		if contact.Contact {
+			sensor.AddStringAttr("state", "open")
+		} else {
+			sensor.AddStringAttr("state", "close")
+		}
+		result = append(result, published{channel: c.config.SensorsOut, state: sensor})
+	}
+	if sw, exists := c.state.switches[ev.UniqueID]; exists && ds.ButtonEvent != nil {
+		sw.ButtonEvent = *ds.ButtonEvent
+		sw.LastSeen = now
+		sensor := config.NewSensorState(sw.Name, "switch")
+		sensor.Time = now
+		sensor.AddIntAttr("buttonevent", int64(sw.ButtonEvent))
+		if click := clickOfButtonEvent(sw.ButtonEvent); click != "" {
+			sensor.AddStringAttr("click", click)
+		}
+		result = append(result, published{channel: c.config.SwitchesOut, state: sensor})
+	}
+	if light, exists := c.state.lights[ev.UniqueID]; exists {
+		light.LastSeen = now
+		if light.update(ev.UniqueID, ds) {
+			result = append(result, published{channel: c.config.LightsOut, state: light.sensorState()})
+		}
+	}
+	return result, nil
+}
+
+// handleLights updates the state of the lights from polling the REST api and returns
+// the state of the lights that changed.
+func (c *conbee) handleLights(lights map[string]deconz.Light, now time.Time) []published {
+	changed := map[*lightState]bool{}
+	for id, l := range lights {
+		c.restIDs[l.UniqueID] = id
+		light, exists := c.state.lights[l.UniqueID]
+		if !exists {
+			continue
+		}
+		ds := deviceState{On: l.State.On, Bri: l.State.Bri, CT: l.State.CT, Reachable: l.State.Reachable}
+		if light.update(l.UniqueID, ds) {
+			changed[light] = true
+		}
+		if lastseen, err := time.Parse("2006-01-02T15:04Z", l.LastSeen); err == nil {
+			if lastseen.After(light.LastSeen) {
+				light.LastSeen = lastseen
+			}
+		} else if l.State.Reachable != nil && *l.State.Reachable {
+			light.LastSeen = now
+		}
+	}
+	result := []published{}
+	for light := range changed {
+		result = append(result, published{channel: c.config.LightsOut, state: light.sensorState()})
+	}
+	return result
+}
+
+// handleSensors updates the health of the configured sensors and switches from polling
+// the REST api, a device that deCONZ does not know is reported as not reachable.
+func (c *conbee) handleSensors(sensors map[string]deconz.Sensor) []published {
+	if c.config.HealthOut == "" {
+		return nil
+	}
+	found := map[string]deconz.Sensor{}
+	for _, s := range sensors {
+		found[s.UniqueID] = s
+	}
+	result := []published{}
+	for _, health := range c.state.healthList {
+		s, exists := found[health.ID]
+		health.Reachable = exists && (s.Config.Reachable == nil || *s.Config.Reachable)
+		if exists && s.Config.Battery != nil {
+			health.Battery = float64(*s.Config.Battery)
+		}
+		if lastseen, err := time.Parse("2006-01-02T15:04Z", s.LastSeen); err == nil && lastseen.After(health.LastSeen) {
+			health.LastSeen = lastseen
+		}
+		result = append(result, published{channel: c.config.HealthOut, state: health.sensorState()})
+	}
+	return result
+}
+
+func (h *deviceHealth) sensorState() *config.SensorState {
+	sensor := config.NewSensorState(h.Name, "health")
+	sensor.Time = h.LastSeen
+	sensor.AddStringAttr("source", "conbee")
+	sensor.AddStringAttr("device", h.Device)
+	if h.BatteryType != "" {
+		sensor.AddStringAttr("battery_type", h.BatteryType)
+	}
+	if h.Battery >= 0 {
+		sensor.AddFloatAttr("battery", h.Battery)
+	}
+	sensor.AddBoolAttr("reachable", h.Reachable)
+	return sensor
+}
+
+// update applies the state of one of the lights, it returns true when the state changed
+func (l *lightState) update(id string, ds deviceState) bool {
+	before := *l
+	if ds.Reachable != nil {
+		l.reachable[id] = *ds.Reachable
+	}
+	if ds.On != nil {
+		l.on[id] = *ds.On
+	}
+	if ds.Bri != nil {
+		l.BRI = float32(*ds.Bri)
+	}
+	if ds.CT != nil {
+		l.CT = float32(*ds.CT)
+	}
+	l.Reachable = false
+	for _, r := range l.reachable {
+		l.Reachable = l.Reachable || r
+	}
+	l.OnOff = false
+	for _, on := range l.on {
+		l.OnOff = l.OnOff || on
+	}
+	return before.Reachable != l.Reachable || before.OnOff != l.OnOff || before.BRI != l.BRI || before.CT != l.CT
+}
 
-		select {
-		case ev := <-eventChan:
-			fields, err := ev.Fields()
+func (l *lightState) sensorState() *config.SensorState {
+	sensor := config.NewSensorState(l.Name, "light")
+	sensor.Time = l.LastSeen
+	sensor.AddStringAttr("power", onOff(l.OnOff))
+	sensor.AddFloatAttr("CT", float64(l.CT))
+	sensor.AddFloatAttr("BRI", float64(l.BRI))
+	sensor.AddBoolAttr("reachable", l.Reachable)
+	return sensor
+}
 
-			if err != nil {
-				//log.Printf("skip event: '%s'", err)
-				continue
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// lightCommands converts a light command into the deCONZ light or group states to PUT.
+// The command is a SensorState with 'name' a light (or "all") and attributes "power"
+// (on, off or toggle), "CT" and "BRI".
+func (c *conbee) lightCommands(cmd *config.SensorState) ([]command, error) {
+	lights := []*lightState{}
+	for _, light := range c.state.lightList {
+		if cmd.Name == "all" || cmd.Name == light.Name {
+			lights = append(lights, light)
+		}
+	}
+	if len(lights) == 0 {
+		return nil, fmt.Errorf("light '%s' doesn't exist", cmd.Name)
+	}
+
+	commands := []command{}
+	for _, light := range lights {
+		state := &deconz.LightState{}
+		power := strings.ToLower(cmd.GetValueAttr("power", ""))
+		switch power {
+		case "on", "off":
+			on := power == "on"
+			state.On = &on
+		case "toggle":
+			if light.Group != "" {
+				toggle := true
+				state.Toggle = &toggle
+			} else {
+				on := !light.OnOff
+				state.On = &on
 			}
+		}
+		execFloatAttr(cmd, "ct", func(ct float64) {
+			value := int(ct)
+			state.CT = &value
+		})
+		execFloatAttr(cmd, "bri", func(bri float64) {
+			value := int(bri)
+			state.Bri = &value
+		})
+
+		// Color temperature and brightness of all lights (e.g. from flux) only go to the
+		// lights that are on, otherwise they would turn on.
+		if cmd.Name == "all" && state.On == nil && state.Toggle == nil && !light.OnOff {
+			continue
+		}
 
-			for k, v := range fields {
-				if strings.HasPrefix(k, "presence") {
-					log.Printf("motion:  %s -> %s = %v (uuid: %s)", ev.Name, k, v, ev.UniqueID)
-				} else if strings.HasPrefix(k, "open") {
-					log.Printf("magnet:  %s -> %s = %v (uuid: %s)", ev.Name, k, v, ev.UniqueID)
-				} else if strings.HasPrefix(k, "button") {
-					log.Printf("switch:  %s -> %s = %v (uuid: %s)", ev.Name, k, v, ev.UniqueID)
-				} else if strings.HasPrefix(k, "bri") {
-					log.Printf("light:  %s -> %s = %v (uuid: %s)", ev.Name, k, v, ev.UniqueID)
+		if light.Group != "" {
+			commands = append(commands, command{group: light.Group, state: state})
+		} else {
+			for _, id := range light.IDs {
+				restid, exists := c.restIDs[id]
+				if !exists {
+					return commands, fmt.Errorf("light '%s' with unique-id '%s' is unknown to deCONZ", light.Name, id)
 				}
+				commands = append(commands, command{light: restid, state: state})
 			}
+		}
+	}
+	return commands, nil
+}
 
-			timeout.Reset(1 * time.Second)
+// execFloatAttr executes 'action' when the float attribute exists, the name is matched
+// case-insensitive since flux uses "CT" and "BRI" where others use "ct" and "bri".
+func execFloatAttr(state *config.SensorState, name string, action func(float64)) bool {
+	for _, fa := range state.FloatAttrs {
+		if strings.EqualFold(fa.Name, name) {
+			action(fa.Value)
+			return true
+		}
+	}
+	return false
+}
 
-		case <-timeout.C:
-			// Currently does nothing
-			// Request the state of all lights?
+func (c *conbee) execute(commands []command) error {
+	for _, cmd := range commands {
+		var err error
+		if cmd.group != "" {
+			err = c.api.SetGroupState(cmd.group, cmd.state)
+		} else {
+			err = c.api.SetLightState(cmd.light, cmd.state)
+		}
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func eventChan(c deconz.Config) (chan *deconz.DeviceEvent, error) {
-	// get an event reader from the API
-	d := deconz.API{Config: c}
-	reader, err := d.EventReader()
-	if err != nil {
-		return nil, err
+func publish(m *microservice.Service, states []published) {
+	for _, p := range states {
+		jsonbytes, err := p.state.ToJSON()
+		if err == nil {
+			err = m.Pubsub.Publish(p.channel, jsonbytes)
+		}
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		}
 	}
+}
 
-	// Dial the reader
-	err = reader.Dial()
+// startEventReader connects to the deCONZ websocket and forwards the events onto the
+// process messages channel of the micro-service.
+func (c *conbee) startEventReader(m *microservice.Service) error {
+	reader, err := c.api.EventReader()
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	// create a new reader, embedding the event reader
-	eventReader := d.DeviceEventReader(reader)
+	c.reader = c.api.DeviceEventReader(reader)
 	channel := make(chan *deconz.DeviceEvent)
-	// start it, it starts its own thread
-	eventReader.Start(channel)
-	// return the channel
-	return channel, nil
+	if err = c.reader.Start(channel); err != nil {
+		return err
+	}
+
+	go func() {
+		for ev := range channel {
+			de := &deviceEvent{UniqueID: ev.UniqueID, Resource: ev.Resource, Type: ev.Device.Type, Name: ev.Device.Name, State: ev.RawState}
+			jsondata, err := json.Marshal(de)
+			if err == nil {
+				m.ProcessMessages <- &microservice.Message{Topic: "conbee/event/", Payload: jsondata}
+			}
+		}
+	}()
+	return nil
 }
 
-func defaultConfiguration() *config.ConbeeConfig {
-	// this is the default configuration
-	c := &config.ConbeeConfig{
-		Addr:   "http://10.0.0.18/api",
-		APIKey: "0A498B9909",
+// pollTicks returns the poll interval in ticks, a tick is every 2 seconds
+func (c *conbee) pollTicks() int {
+	if c.config.PollIntervalSec < 2 {
+		return 1
 	}
+	return c.config.PollIntervalSec / 2
+}
 
-	return c
+// poll requests the state of all lights and sensors and puts them onto the process
+// messages channel of the micro-service, the HTTP requests are not done on the service loop.
+func (c *conbee) poll(m *microservice.Service) {
+	if c.polling {
+		return
+	}
+	c.polling = true
+	api := c.api
+	go func() {
+		sensors, err := api.Sensors()
+		if err == nil {
+			jsondata, _ := json.Marshal(sensors)
+			m.ProcessMessages <- &microservice.Message{Topic: "conbee/sensors/", Payload: jsondata}
+		} else {
+			m.Logger.LogError(m.Name, err.Error())
+		}
+
+		lights, err := api.Lights()
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			lights = nil
+		}
+		jsondata, _ := json.Marshal(lights)
+		m.ProcessMessages <- &microservice.Message{Topic: "conbee/lights/", Payload: jsondata}
+	}()
+}
+
+func main() {
+	conbee := new()
+
+	register := []string{"config/request/"}
+	subscribe := []string{"config/conbee/"}
+
+	m := microservice.New("conbee")
+	m.RegisterAndSubscribe(register, subscribe)
+
+	// Light commands are answered with the result when they are send as a request
+	handleLightCommand := func(m *microservice.Service, topic string, msg []byte) *microservice.Reply {
+		if conbee.config == nil {
+			return microservice.Failed(fmt.Errorf("conbee is not configured"))
+		}
+		cmd, err := config.SensorStateFromJSON(msg)
+		if err != nil {
+			return microservice.Failed(err)
+		}
+		commands, err := conbee.lightCommands(cmd)
+		if exerr := conbee.execute(commands); exerr != nil {
+			err = exerr
+		}
+		if err != nil {
+			return microservice.Failed(err)
+		}
+		return microservice.Succeeded(nil)
+	}
+
+	subscribed := false
+	m.RegisterHandler("config/conbee/", func(m *microservice.Service, topic string, msg []byte) bool {
+		m.Logger.LogInfo(m.Name, "received configuration")
+		err := conbee.initialize(msg)
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+
+		for _, channel := range []string{conbee.config.LightsOut, conbee.config.SwitchesOut, conbee.config.SensorsOut, conbee.config.HealthOut} {
+			if channel != "" {
+				m.Register(channel)
+			}
+		}
+		// Only the first configuration subscribes, the subscriptions are restored on a reconnect
+		for _, channel := range conbee.config.LightsIn {
+			m.RegisterReplyHandler(channel, handleLightCommand)
+			if !subscribed {
+				if err := m.Subscribe(channel); err != nil {
+					m.Logger.LogError(m.Name, err.Error())
+				}
+			}
+		}
+		subscribed = true
+
+		if conbee.reader == nil {
+			if err := conbee.startEventReader(m); err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+			} else {
+				m.Logger.LogInfo(m.Name, fmt.Sprintf("connected to deCONZ at %s", conbee.config.Addr))
+			}
+		}
+		conbee.poll(m)
+		return true
+	})
+
+	m.RegisterHandler("conbee/event/", func(m *microservice.Service, topic string, msg []byte) bool {
+		ev := &deviceEvent{}
+		if err := json.Unmarshal(msg, ev); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		states, err := conbee.handleEvent(ev, time.Now())
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		}
+		publish(m, states)
+		return true
+	})
+
+	m.RegisterHandler("conbee/sensors/", func(m *microservice.Service, topic string, msg []byte) bool {
+		sensors := map[string]deconz.Sensor{}
+		if err := json.Unmarshal(msg, &sensors); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		publish(m, conbee.handleSensors(sensors))
+		return true
+	})
+
+	m.RegisterHandler("conbee/lights/", func(m *microservice.Service, topic string, msg []byte) bool {
+		conbee.polling = false
+		lights := map[string]deconz.Light{}
+		if err := json.Unmarshal(msg, &lights); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		publish(m, conbee.handleLights(lights, time.Now()))
+		return true
+	})
+
+	tickCount := 0
+	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
+		if conbee.config == nil {
+			if tickCount%5 == 0 { // every 10 seconds
+				m.Pubsub.PublishStr("config/request/", m.Name)
+			}
+		} else if tickCount%conbee.pollTicks() == 0 {
+			conbee.poll(m)
+		}
+		tickCount++
+		return true
+	})
+
+	m.Loop()
 }
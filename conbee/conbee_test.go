@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/conbee/deconz"
+	"github.com/jurgen-kluft/go-home/conbee/deconz/deconztest"
+	"github.com/jurgen-kluft/go-home/config"
+)
+
+const testConfig = `{
+	"lights.out": "state/light/conbee/",
+	"switches.out": "state/switch/conbee/",
+	"sensors.out": "state/sensor/conbee/",
+	"health.out": "state/health/conbee/",
+	"switches": [{"id": "switch-1", "name": "Bedroom Switch", "battery_type": "cr2032"}],
+	"sensors": {
+		"motion": [{"id": "motion-1", "name": "Kitchen Motion"}],
+		"contact": [{"id": "contact-1", "name": "Front Door Magnet"}]
+	},
+	"lights": [
+		{"name": "Kitchen", "ids": ["light-1", "light-2"]},
+		{"name": "Bedroom Main", "ids": ["light-3"], "group": "4"}
+	]
+}`
+
+func newTestConbee(t *testing.T) *conbee {
+	c := new()
+	if err := c.initialize([]byte(testConfig)); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestHandleEvent(t *testing.T) {
+	c := newTestConbee(t)
+	now := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		event     deviceEvent
+		channel   string
+		name      string
+		attribute string
+		value     string
+	}{
+		{deviceEvent{UniqueID: "motion-1", State: []byte(`{"presence": true}`)}, "state/sensor/conbee/", "Kitchen Motion", "motion", "on"},
+		{deviceEvent{UniqueID: "contact-1", State: []byte(`{"open": false}`)}, "state/sensor/conbee/", "Front Door Magnet", "state", "close"},
+		{deviceEvent{UniqueID: "switch-1", State: []byte(`{"buttonevent": 1004}`)}, "state/switch/conbee/", "Bedroom Switch", "click", "double click"},
+		{deviceEvent{UniqueID: "light-2", State: []byte(`{"on": true, "bri": 120, "reachable": true}`)}, "state/light/conbee/", "Kitchen", "power", "on"},
+	}
+	for _, tt := range tests {
+		states, err := c.handleEvent(&tt.event, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(states) != 1 || states[0].channel != tt.channel || states[0].state.Name != tt.name {
+			t.Fatalf("handleEvent(%s) = %v", tt.event.UniqueID, states)
+		}
+		if value := states[0].state.GetValueAttr(tt.attribute, ""); value != tt.value {
+			t.Errorf("handleEvent(%s) %s = '%s', want '%s'", tt.event.UniqueID, tt.attribute, value, tt.value)
+		}
+	}
+
+	// The same light state should not be published again
+	states, _ := c.handleEvent(&deviceEvent{UniqueID: "light-2", State: []byte(`{"on": true}`)}, now)
+	if len(states) != 0 {
+		t.Errorf("handleEvent() published an unchanged light")
+	}
+}
+
+func TestHandleSensors(t *testing.T) {
+	c := newTestConbee(t)
+	battery := 9
+	reachable := false
+	states := c.handleSensors(map[string]deconz.Sensor{
+		"2": {UniqueID: "switch-1", LastSeen: "2030-01-02T03:04Z", Config: deconz.SensorConfig{Battery: &battery}},
+		"5": {UniqueID: "motion-1", Config: deconz.SensorConfig{Reachable: &reachable}},
+	})
+	if len(states) != 3 {
+		t.Fatalf("handleSensors() = %d states, want 3", len(states))
+	}
+	for _, p := range states {
+		s := p.state
+		if p.channel != "state/health/conbee/" || s.Type != "health" {
+			t.Errorf("handleSensors() published %s on %s", s.Type, p.channel)
+		}
+		switch s.Name {
+		case "Bedroom Switch":
+			if s.GetFloatAttr("battery", -1) != 9 || s.GetValueAttr("battery_type", "") != "cr2032" || !s.GetBoolAttr("reachable", false) || s.Time.Year() != 2030 {
+				t.Errorf("health of %s = %+v", s.Name, s)
+			}
+		case "Kitchen Motion", "Front Door Magnet":
+			// the motion sensor is not reachable and deCONZ does not know the magnet
+			if s.GetBoolAttr("reachable", true) || s.GetFloatAttr("battery", -1) != -1 {
+				t.Errorf("health of %s = %+v", s.Name, s)
+			}
+		}
+	}
+}
+
+func TestLightCommands(t *testing.T) {
+	c := newTestConbee(t)
+	reachable := true
+	on := false
+	c.handleLights(map[string]deconz.Light{
+		"1": {UniqueID: "light-1", State: deconz.LightState{On: &on, Reachable: &reachable}},
+		"2": {UniqueID: "light-2", State: deconz.LightState{On: &on, Reachable: &reachable}},
+		"3": {UniqueID: "light-3", State: deconz.LightState{On: &on, Reachable: &reachable}},
+	}, time.Now())
+
+	cmd := config.NewSensorState("Kitchen", "light")
+	cmd.AddStringAttr("power", "on")
+	cmd.AddFloatAttr("bri", 200)
+	commands, err := c.lightCommands(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 2 || commands[0].light != "1" || commands[1].light != "2" || !*commands[0].state.On || *commands[0].state.Bri != 200 {
+		t.Errorf("lightCommands(Kitchen) = %v", commands)
+	}
+
+	cmd = config.NewSensorState("Bedroom Main", "light")
+	cmd.AddStringAttr("power", "toggle")
+	commands, err = c.lightCommands(cmd)
+	if err != nil || len(commands) != 1 || commands[0].group != "4" || !*commands[0].state.Toggle {
+		t.Errorf("lightCommands(Bedroom Main) = %v, %v", commands, err)
+	}
+
+	// Flux only changes the lights that are on
+	cmd = config.NewSensorState("all", "flux")
+	cmd.AddFloatAttr("CT", 300)
+	if commands, err = c.lightCommands(cmd); err != nil || len(commands) != 0 {
+		t.Errorf("lightCommands(all) = %v, %v", commands, err)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	g := deconztest.NewGateway("ABCDEF1234")
+	defer g.Close()
+	g.Add("lights", "1", map[string]interface{}{"uniqueid": "light-1", "state": map[string]interface{}{"on": false, "reachable": true}})
+	g.Add("lights", "2", map[string]interface{}{"uniqueid": "light-2", "state": map[string]interface{}{"on": false, "reachable": true}})
+	g.Add("lights", "3", map[string]interface{}{"uniqueid": "light-3", "state": map[string]interface{}{"on": false, "reachable": true}})
+	g.Add("groups", "4", map[string]interface{}{"name": "Bedroom", "lights": []string{"3"}})
+
+	c := newTestConbee(t)
+	c.api = &deconz.API{Config: deconz.Config{Addr: g.Addr(), APIKey: g.APIKey}}
+	lights, err := c.api.Lights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.handleLights(lights, time.Now())
+
+	for _, name := range []string{"Kitchen", "Bedroom Main"} {
+		cmd := config.NewSensorState(name, "light")
+		cmd.AddStringAttr("power", "on")
+		commands, err := c.lightCommands(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.execute(commands); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resources := []string{}
+	for _, r := range g.Requests() {
+		if r.Method == "PUT" {
+			resources = append(resources, r.Resource)
+		}
+	}
+	if strings.Join(resources, ",") != "lights/1/state,lights/2/state,groups/4/action" {
+		t.Errorf("execute() sent %v", resources)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if on, _ := g.Resource("lights/" + id)["state"].(map[string]interface{})["on"].(bool); !on {
+			t.Errorf("light %s is not on", id)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package deconz
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -12,27 +11,16 @@ import (
 type API struct {
 	Config      Config
 	deviceCache *CachedDeviceStore
+	httpClient  *http.Client
 }
 
 // Devices returns a map of devices
 func (a *API) Devices() (*Devices, error) {
-	var resp *http.Response
-	var err error
-	var url string
 	devices := Devices{}
 
-	url = fmt.Sprintf("%s/%s/sensors", a.Config.Addr, a.Config.APIKey)
-	resp, err = http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("Devices() unable to get %s: %s", url, err)
-	}
-	defer resp.Body.Close()
-	var sensors map[int]Device
-	var dec *json.Decoder
-	dec = json.NewDecoder(resp.Body)
-	err = dec.Decode(&sensors)
-	if err != nil {
-		return nil, fmt.Errorf("unable to decode deCONZ response: %s", err)
+	var sensors map[string]Device
+	if err := a.get("sensors", &sensors); err != nil {
+		return nil, fmt.Errorf("Devices() unable to get sensors: %s", err)
 	}
 	for _, s := range sensors {
 		// TODO: Check if the DeviceID is formatted consistently the same way
@@ -40,17 +28,9 @@ func (a *API) Devices() (*Devices, error) {
 		devices[s.DeviceID] = s
 	}
 
-	url = fmt.Sprintf("%s/%s/lights", a.Config.Addr, a.Config.APIKey)
-	resp, err = http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("Devices() unable to get %s: %s", url, err)
-	}
-	defer resp.Body.Close()
-	var lights map[int]Device
-	dec = json.NewDecoder(resp.Body)
-	err = dec.Decode(&lights)
-	if err != nil {
-		return nil, fmt.Errorf("unable to decode deCONZ response: %s", err)
+	var lights map[string]Device
+	if err := a.get("lights", &lights); err != nil {
+		return nil, fmt.Errorf("Devices() unable to get lights: %s", err)
 	}
 	for _, l := range lights {
 		// TODO: Check if the DeviceID is formatted consistently the same way
@@ -59,7 +39,6 @@ func (a *API) Devices() (*Devices, error) {
 	}
 
 	return &devices, nil
-
 }
 
 // EventReader returns a event.Reader with a default cached type store
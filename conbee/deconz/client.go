@@ -0,0 +1,121 @@
+package deconz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiResponse is an item of the response of the deCONZ REST api on a PUT, POST or DELETE,
+// e.g. [{"success": {"/lights/1/state/on": true}}, {"error": {"type": 3, "address": "/lights/9", "description": "..."}}]
+type apiResponse struct {
+	Success map[string]interface{} `json:"success"`
+	Error   *struct {
+		Type        int    `json:"type"`
+		Address     string `json:"address"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+func (a *API) client() *http.Client {
+	if a.httpClient == nil {
+		return defaultClient
+	}
+	return a.httpClient
+}
+
+// SetHTTPClient changes the http client that is used to talk to deCONZ
+func (a *API) SetHTTPClient(client *http.Client) {
+	a.httpClient = client
+}
+
+func (a *API) url(resource string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(a.Config.Addr, "/"), a.Config.APIKey, resource)
+}
+
+// do sends a request to the REST api and returns the response body, deCONZ errors are
+// returned as an error.
+func (a *API) do(method string, resource string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, a.url(resource), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := a.client().Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to %s %s: %s", method, resource, err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read deCONZ response: %s", err)
+	}
+	var items []apiResponse
+	if json.Unmarshal(reply, &items) == nil {
+		for _, item := range items {
+			if item.Error != nil {
+				return nil, fmt.Errorf("deCONZ error %d at %s: %s", item.Error.Type, item.Error.Address, item.Error.Description)
+			}
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected statuscode from deCONZ: %d", resp.StatusCode)
+	}
+	return reply, nil
+}
+
+func (a *API) get(resource string, v interface{}) error {
+	reply, err := a.do("GET", resource, nil)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(reply, v); err != nil {
+		return fmt.Errorf("unable to decode deCONZ response: %s", err)
+	}
+	return nil
+}
+
+func (a *API) put(resource string, body interface{}) error {
+	_, err := a.do("PUT", resource, body)
+	return err
+}
+
+func (a *API) delete(resource string) error {
+	_, err := a.do("DELETE", resource, nil)
+	return err
+}
+
+// post sends a POST request and returns the id of the created resource (if any)
+func (a *API) post(resource string, body interface{}) (string, error) {
+	reply, err := a.do("POST", resource, body)
+	if err != nil {
+		return "", err
+	}
+	var items []apiResponse
+	if json.Unmarshal(reply, &items) == nil {
+		for _, item := range items {
+			if id, ok := item.Success["id"].(string); ok {
+				return id, nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,265 @@
+package deconz
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jurgen-kluft/go-home/conbee/deconz/deconztest"
+)
+
+func newTestGateway(t *testing.T) (*deconztest.Gateway, *API) {
+	g := deconztest.NewGateway("ABCDEF1234")
+	g.Add("lights", "1", map[string]interface{}{"name": "Kitchen", "type": "Color temperature light", "uniqueid": "light-1", "state": map[string]interface{}{"on": false, "bri": 100, "reachable": true}})
+	g.Add("lights", "2", map[string]interface{}{"name": "Kitchen Stand", "type": "Color temperature light", "uniqueid": "light-2", "state": map[string]interface{}{"on": false, "bri": 100, "reachable": true}})
+	g.Add("groups", "18", map[string]interface{}{"name": "Kitchen", "lights": []string{"1", "2"}})
+	g.Add("sensors", "5", map[string]interface{}{"name": "Kitchen Motion", "type": "ZHAPresence", "uniqueid": "motion-1", "config": map[string]interface{}{"on": true, "battery": 90, "duration": 60}, "state": map[string]interface{}{"presence": false}})
+	api := &API{Config: Config{Addr: g.Addr(), APIKey: g.APIKey}}
+	return g, api
+}
+
+func lightOn(g *deconztest.Gateway, id string) bool {
+	on, _ := g.Resource("lights/" + id)["state"].(map[string]interface{})["on"].(bool)
+	return on
+}
+
+func TestLights(t *testing.T) {
+	g, api := newTestGateway(t)
+	defer g.Close()
+
+	lights, err := api.Lights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lights) != 2 || lights["1"].ID != "1" || lights["1"].UniqueID != "light-1" || *lights["1"].State.Bri != 100 {
+		t.Fatalf("Lights() = %+v", lights)
+	}
+
+	on, ct := true, 300
+	if err = api.SetLightState("1", &LightState{On: &on, CT: &ct}); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.RenameLight("1", "Kitchen Main"); err != nil {
+		t.Fatal(err)
+	}
+	light, err := api.Light("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if light.Name != "Kitchen Main" || !*light.State.On || *light.State.CT != 300 {
+		t.Errorf("Light(1) = %+v", light)
+	}
+
+	requests := g.Requests()
+	if r := requests[1]; r.Method != "PUT" || r.Resource != "lights/1/state" || r.Body != `{"on":true,"ct":300}` {
+		t.Errorf("SetLightState sent %+v", r)
+	}
+}
+
+func TestGroupsAndScenes(t *testing.T) {
+	g, api := newTestGateway(t)
+	defer g.Close()
+
+	on := true
+	if err := api.SetGroupState("18", &LightState{On: &on}); err != nil {
+		t.Fatal(err)
+	}
+	if !lightOn(g, "1") || !lightOn(g, "2") {
+		t.Fatal("SetGroupState did not turn on the lights of the group")
+	}
+	group, err := api.Group("18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.ID != "18" || !group.State.AllOn || len(group.Lights) != 2 {
+		t.Fatalf("Group(18) = %+v", group)
+	}
+
+	scene, err := api.CreateScene("18", "Dinner")
+	if err != nil || scene == "" {
+		t.Fatalf("CreateScene() = '%s', %v", scene, err)
+	}
+	if err = api.StoreScene("18", scene); err != nil {
+		t.Fatal(err)
+	}
+	off := false
+	if err = api.SetGroupState("18", &LightState{On: &off}); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.RecallScene("18", scene); err != nil {
+		t.Fatal(err)
+	}
+	if !lightOn(g, "1") || !lightOn(g, "2") {
+		t.Error("RecallScene did not restore the lights of the group")
+	}
+	scenes, err := api.Scenes("18")
+	if err != nil || len(scenes) != 1 || scenes[scene].Name != "Dinner" {
+		t.Errorf("Scenes(18) = %+v, %v", scenes, err)
+	}
+	if err = api.DeleteScene("18", scene); err != nil {
+		t.Fatal(err)
+	}
+	if group, _ = api.Group("18"); len(group.Scenes) != 0 {
+		t.Errorf("DeleteScene did not delete the scene, %+v", group.Scenes)
+	}
+
+	id, err := api.CreateGroup("Bedroom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = api.SetGroupLights(id, "Bedroom", []string{"2"}); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := api.Groups()
+	if err != nil || len(groups) != 2 || groups[id].Name != "Bedroom" || len(groups[id].Lights) != 1 {
+		t.Fatalf("Groups() = %+v, %v", groups, err)
+	}
+	if err = api.DeleteGroup(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.Group(id); err == nil {
+		t.Error("DeleteGroup did not delete the group")
+	}
+}
+
+func TestSensors(t *testing.T) {
+	g, api := newTestGateway(t)
+	defer g.Close()
+
+	sensors, err := api.Sensors()
+	if err != nil || len(sensors) != 1 || sensors["5"].Type != "ZHAPresence" || *sensors["5"].Config.Battery != 90 {
+		t.Fatalf("Sensors() = %+v, %v", sensors, err)
+	}
+
+	duration := 180
+	if err = api.SetSensorConfig("5", &SensorConfig{Duration: &duration}); err != nil {
+		t.Fatal(err)
+	}
+	sensor, err := api.Sensor("5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *sensor.Config.Duration != 180 || *sensor.Config.Battery != 90 {
+		t.Errorf("Sensor(5) config = %+v", sensor.Config)
+	}
+	var state struct{ Presence bool }
+	if err = json.Unmarshal(sensor.State, &state); err != nil || state.Presence {
+		t.Errorf("Sensor(5) state = %s, %v", sensor.State, err)
+	}
+}
+
+func TestRules(t *testing.T) {
+	g, api := newTestGateway(t)
+	defer g.Close()
+
+	rule := &Rule{
+		Name:       "Kitchen motion",
+		Conditions: []RuleCondition{{Address: "/sensors/5/state/presence", Operator: "eq", Value: "true"}},
+		Actions:    []RuleAction{{Address: "/groups/18/action", Method: "PUT", Body: json.RawMessage(`{"on":true}`)}},
+	}
+	id, err := api.CreateRule(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule.Status = "disabled"
+	if err = api.UpdateRule(id, rule); err != nil {
+		t.Fatal(err)
+	}
+	r, err := api.Rule(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != id || r.Status != "disabled" || len(r.Conditions) != 1 || string(r.Actions[0].Body) != `{"on":true}` {
+		t.Errorf("Rule(%s) = %+v", id, r)
+	}
+	rules, err := api.Rules()
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("Rules() = %+v, %v", rules, err)
+	}
+	if err = api.DeleteRule(id); err != nil {
+		t.Fatal(err)
+	}
+	if rules, _ = api.Rules(); len(rules) != 0 {
+		t.Errorf("DeleteRule did not delete the rule")
+	}
+}
+
+func TestGateway(t *testing.T) {
+	g, api := newTestGateway(t)
+	defer g.Close()
+
+	if err := api.PermitJoin(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.PermitJoin(300); err == nil {
+		t.Error("PermitJoin(300) should fail")
+	}
+	name := "Home"
+	if err := api.SetGatewayConfig(&GatewayConfigUpdate{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := api.GatewayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "Home" || c.PermitJoin != 60 || c.Websocketport != 443 {
+		t.Errorf("GatewayConfig() = %+v", c)
+	}
+
+	g.AddTouchlinkDevice("1", map[string]interface{}{"address": "0x00212EFFFF000001", "channel": 11, "factorynew": false, "panid": 1234, "rssi": -45})
+	if err = api.StartTouchlinkScan(); err != nil {
+		t.Fatal(err)
+	}
+	scan, err := api.TouchlinkScan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Result) != 1 || scan.Result["1"].RSSI != -45 {
+		t.Fatalf("TouchlinkScan() = %+v", scan)
+	}
+	if err = api.TouchlinkIdentify("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.TouchlinkReset("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.TouchlinkIdentify("1"); err == nil {
+		t.Error("TouchlinkIdentify of a reset device should fail")
+	}
+}
+
+func TestDevices(t *testing.T) {
+	g, api := newTestGateway(t)
+	defer g.Close()
+
+	devices, err := api.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*devices) != 3 || (*devices)["motion-1"].Type != "ZHAPresence" || (*devices)["light-2"].Name != "Kitchen Stand" {
+		t.Errorf("Devices() = %+v", devices)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	g, api := newTestGateway(t)
+	defer g.Close()
+
+	if _, err := api.Light("9"); err == nil || !strings.Contains(err.Error(), "resource, /lights/9, not available") {
+		t.Errorf("Light(9) error = %v", err)
+	}
+	on := true
+	if err := api.SetLightState("9", &LightState{On: &on}); err == nil || !strings.Contains(err.Error(), "deCONZ error 3") {
+		t.Errorf("SetLightState(9) error = %v", err)
+	}
+
+	unauthorized := &API{Config: Config{Addr: g.Addr(), APIKey: "WRONG"}}
+	if _, err := unauthorized.Lights(); err == nil || !strings.Contains(err.Error(), "unauthorized user") {
+		t.Errorf("Lights() with a wrong api key error = %v", err)
+	}
+
+	g.Close()
+	if _, err := api.Lights(); err == nil {
+		t.Error("Lights() on a closed gateway should fail")
+	}
+}
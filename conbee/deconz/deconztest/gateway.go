@@ -0,0 +1,420 @@
+// Package deconztest provides a fake deCONZ gateway that serves the REST api from
+// memory, so that code talking to deCONZ can be tested without a ConBee stick.
+package deconztest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Request is a request as it was received by the gateway
+type Request struct {
+	Method   string
+	Resource string // e.g. "lights/1/state", without the api key
+	Body     string
+}
+
+// Gateway is a fake deCONZ gateway, resources are kept as generic JSON objects
+// indexed by collection ("lights", "groups/1/scenes") and id.
+type Gateway struct {
+	*httptest.Server
+	APIKey string
+
+	mutex     sync.Mutex
+	resources map[string]map[string]map[string]interface{}
+	config    map[string]interface{}
+	touchlink map[string]interface{}
+	requests  []Request
+	nextID    int
+}
+
+// NewGateway starts a fake gateway that accepts 'apikey', call Close when done
+func NewGateway(apikey string) *Gateway {
+	g := &Gateway{APIKey: apikey, nextID: 1}
+	g.resources = map[string]map[string]map[string]interface{}{
+		"lights":  {},
+		"groups":  {},
+		"sensors": {},
+		"rules":   {},
+	}
+	g.config = map[string]interface{}{
+		"name":          "deCONZ-GW",
+		"apiversion":    "1.16.0",
+		"swversion":     "2.05.69",
+		"mac":           "00:21:2e:ff:ff:00:00:01",
+		"ipaddress":     "127.0.0.1",
+		"websocketport": 443,
+		"zigbeechannel": 15,
+		"permitjoin":    0,
+		"timezone":      "Asia/Shanghai",
+	}
+	g.touchlink = map[string]interface{}{"scanstate": "idle", "lastscan": "none", "result": map[string]interface{}{}}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serve))
+	return g
+}
+
+// Addr returns the address of the REST api as it is used in deconz.Config
+func (g *Gateway) Addr() string {
+	return g.URL + "/api"
+}
+
+// Add adds (or replaces) the resource 'id' in 'collection', 'resource' is anything
+// that marshals to a JSON object
+func (g *Gateway) Add(collection string, id string, resource interface{}) {
+	object := toObject(resource)
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if collection == "groups" {
+		if _, ok := object["action"]; !ok {
+			object["action"] = map[string]interface{}{}
+		}
+		g.collection("groups/" + id + "/scenes")
+	}
+	g.collection(collection)[id] = object
+	g.updateGroups()
+}
+
+// AddTouchlinkDevice adds a device that will be found by a touchlink scan
+func (g *Gateway) AddTouchlinkDevice(id string, device interface{}) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.touchlink["result"].(map[string]interface{})[id] = toObject(device)
+}
+
+// Resource returns a copy of the resource at 'path', e.g. "lights/1" or "config"
+func (g *Gateway) Resource(path string) map[string]interface{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var object map[string]interface{}
+	if path == "config" {
+		object = g.config
+	} else if i := strings.LastIndex(path, "/"); i > 0 {
+		object = g.resources[path[:i]][path[i+1:]]
+	}
+	if object == nil {
+		return nil
+	}
+	return toObject(object)
+}
+
+// Requests returns the requests received so far
+func (g *Gateway) Requests() []Request {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return append([]Request(nil), g.requests...)
+}
+
+func toObject(v interface{}) map[string]interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	object := map[string]interface{}{}
+	if err = json.Unmarshal(data, &object); err != nil {
+		panic(err)
+	}
+	return object
+}
+
+func (g *Gateway) collection(name string) map[string]map[string]interface{} {
+	c, ok := g.resources[name]
+	if !ok {
+		c = map[string]map[string]interface{}{}
+		g.resources[name] = c
+	}
+	return c
+}
+
+// apiError is the error that deCONZ returns, type 1 is 'unauthorized user', type 2 is
+// 'body contains invalid JSON' and type 3 is 'resource not available'
+type apiError struct {
+	status      int
+	kind        int
+	address     string
+	description string
+}
+
+func notAvailable(resource string) *apiError {
+	return &apiError{http.StatusNotFound, 3, "/" + resource, fmt.Sprintf("resource, /%s, not available", resource)}
+}
+
+func (g *Gateway) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(parts) < 2 || parts[0] != "api" || parts[1] != g.APIKey {
+		resource := ""
+		if len(parts) > 2 {
+			resource = strings.Join(parts[2:], "/")
+		}
+		writeError(w, &apiError{http.StatusForbidden, 1, "/" + resource, "unauthorized user"})
+		return
+	}
+	resource := strings.Join(parts[2:], "/")
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.requests = append(g.requests, Request{Method: r.Method, Resource: resource, Body: string(body)})
+
+	var object map[string]interface{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &object); err != nil {
+			writeError(w, &apiError{http.StatusBadRequest, 2, "/" + resource, "body contains invalid JSON"})
+			return
+		}
+	}
+
+	reply, e := g.handle(r.Method, parts[2:], object)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+func writeError(w http.ResponseWriter, e *apiError) {
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode([]interface{}{
+		map[string]interface{}{"error": map[string]interface{}{"type": e.kind, "address": e.address, "description": e.description}},
+	})
+}
+
+func success(key string, value interface{}) []interface{} {
+	return []interface{}{map[string]interface{}{"success": map[string]interface{}{key: value}}}
+}
+
+// changed returns the success response of a PUT, one item per changed attribute
+func changed(resource string, object map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	reply := []interface{}{}
+	for _, k := range keys {
+		reply = append(reply, success("/"+resource+"/"+k, object[k])...)
+	}
+	return reply
+}
+
+func merge(into map[string]interface{}, from map[string]interface{}) {
+	for k, v := range from {
+		into[k] = v
+	}
+}
+
+func (g *Gateway) handle(method string, parts []string, body map[string]interface{}) (interface{}, *apiError) {
+	resource := strings.Join(parts, "/")
+	if len(parts) == 0 {
+		return nil, notAvailable(resource)
+	}
+
+	switch parts[0] {
+	case "config":
+		if len(parts) != 1 {
+			return nil, notAvailable(resource)
+		}
+		if method == "GET" {
+			return g.config, nil
+		} else if method == "PUT" {
+			merge(g.config, body)
+			return changed("config", body), nil
+		}
+		return nil, notAvailable(resource)
+	case "touchlink":
+		return g.handleTouchlink(method, parts)
+	}
+
+	// collection, item or an action on an item, e.g. "groups", "groups/1", "groups/1/action",
+	// "groups/1/scenes", "groups/1/scenes/2" and "groups/1/scenes/2/recall"
+	if len(parts)%2 == 1 {
+		name := strings.Join(parts, "/")
+		if c, ok := g.resources[name]; ok {
+			return g.handleCollection(method, name, c, body)
+		}
+		if len(parts) == 1 {
+			return nil, notAvailable(resource)
+		}
+		name = strings.Join(parts[:len(parts)-2], "/")
+		object := g.resources[name][parts[len(parts)-2]]
+		if object == nil {
+			return nil, notAvailable(strings.Join(parts[:len(parts)-1], "/"))
+		}
+		return g.handleAction(method, parts, object, body)
+	}
+
+	name := strings.Join(parts[:len(parts)-1], "/")
+	id := parts[len(parts)-1]
+	object := g.resources[name][id]
+	if object == nil {
+		return nil, notAvailable(resource)
+	}
+	switch method {
+	case "GET":
+		return g.view(name, id, object), nil
+	case "PUT":
+		merge(object, body)
+		g.updateGroups()
+		return changed(resource, body), nil
+	case "DELETE":
+		delete(g.resources[name], id)
+		if name == "groups" {
+			delete(g.resources, "groups/"+id+"/scenes")
+		}
+		return success("id", id), nil
+	}
+	return nil, notAvailable(resource)
+}
+
+func (g *Gateway) handleCollection(method string, name string, c map[string]map[string]interface{}, body map[string]interface{}) (interface{}, *apiError) {
+	switch method {
+	case "GET":
+		view := map[string]interface{}{}
+		for id, object := range c {
+			view[id] = g.view(name, id, object)
+		}
+		return view, nil
+	case "POST":
+		id := strconv.Itoa(g.nextID)
+		g.nextID++
+		object := map[string]interface{}{}
+		merge(object, body)
+		if name == "groups" {
+			object["action"] = map[string]interface{}{}
+			g.collection("groups/" + id + "/scenes")
+		}
+		c[id] = object
+		g.updateGroups()
+		return success("id", id), nil
+	}
+	return nil, notAvailable(name)
+}
+
+func (g *Gateway) handleAction(method string, parts []string, object map[string]interface{}, body map[string]interface{}) (interface{}, *apiError) {
+	resource := strings.Join(parts, "/")
+	action := parts[len(parts)-1]
+	if method != "PUT" {
+		return nil, notAvailable(resource)
+	}
+
+	switch {
+	case parts[0] == "lights" && action == "state", parts[0] == "sensors" && action == "config":
+		attrs, _ := object[action].(map[string]interface{})
+		if attrs == nil {
+			attrs = map[string]interface{}{}
+			object[action] = attrs
+		}
+		merge(attrs, body)
+	case parts[0] == "groups" && action == "action":
+		attrs := object["action"].(map[string]interface{})
+		merge(attrs, body)
+		for _, light := range g.members(object) {
+			state := light["state"].(map[string]interface{})
+			if toggle, _ := body["toggle"].(bool); toggle {
+				on, _ := state["on"].(bool)
+				state["on"] = !on
+			}
+			for k, v := range body {
+				if k != "toggle" {
+					state[k] = v
+				}
+			}
+		}
+	case parts[0] == "groups" && action == "store":
+		group := g.resources["groups"][parts[1]]
+		lightstates := map[string]interface{}{}
+		for id, light := range g.members(group) {
+			lightstates[id] = toObject(light["state"])
+		}
+		object["lightstates"] = lightstates
+		object["lightcount"] = len(lightstates)
+	case parts[0] == "groups" && action == "recall":
+		lightstates, _ := object["lightstates"].(map[string]interface{})
+		for id, state := range lightstates {
+			if light := g.resources["lights"][id]; light != nil {
+				merge(light["state"].(map[string]interface{}), state.(map[string]interface{}))
+			}
+		}
+	default:
+		return nil, notAvailable(resource)
+	}
+	g.updateGroups()
+	return changed(resource, body), nil
+}
+
+func (g *Gateway) handleTouchlink(method string, parts []string) (interface{}, *apiError) {
+	resource := strings.Join(parts, "/")
+	if len(parts) == 2 && parts[1] == "scan" {
+		if method == "GET" {
+			return g.touchlink, nil
+		} else if method == "POST" {
+			// the scan completes immediately, deCONZ reports "scanning" for about 10 seconds
+			g.touchlink["lastscan"] = "2019-03-04T09:00:00"
+			return success("/touchlink/scan", "ok"), nil
+		}
+	} else if len(parts) == 3 && method == "POST" && (parts[2] == "identify" || parts[2] == "reset") {
+		result := g.touchlink["result"].(map[string]interface{})
+		if _, ok := result[parts[1]]; !ok {
+			return nil, notAvailable(strings.Join(parts[:2], "/"))
+		}
+		if parts[2] == "reset" {
+			delete(result, parts[1])
+		}
+		return success("/"+resource, "ok"), nil
+	}
+	return nil, notAvailable(resource)
+}
+
+// view returns the resource as it is returned by a GET, a group lists its scenes
+func (g *Gateway) view(name string, id string, object map[string]interface{}) interface{} {
+	if name != "groups" {
+		return object
+	}
+	scenes := []interface{}{}
+	for sceneID, scene := range g.resources["groups/"+id+"/scenes"] {
+		scenes = append(scenes, map[string]interface{}{"id": sceneID, "name": scene["name"], "lightcount": scene["lightcount"]})
+	}
+	view := map[string]interface{}{}
+	merge(view, object)
+	view["scenes"] = scenes
+	return view
+}
+
+// members returns the lights of a group indexed by their id
+func (g *Gateway) members(group map[string]interface{}) map[string]map[string]interface{} {
+	lights := map[string]map[string]interface{}{}
+	ids, _ := group["lights"].([]interface{})
+	for _, id := range ids {
+		light := g.resources["lights"][fmt.Sprint(id)]
+		if light == nil {
+			continue
+		}
+		if _, ok := light["state"].(map[string]interface{}); !ok {
+			light["state"] = map[string]interface{}{}
+		}
+		lights[fmt.Sprint(id)] = light
+	}
+	return lights
+}
+
+// updateGroups updates the 'all_on' and 'any_on' state of the groups
+func (g *Gateway) updateGroups() {
+	for _, group := range g.resources["groups"] {
+		lights := g.members(group)
+		allOn, anyOn := len(lights) > 0, false
+		for _, light := range lights {
+			on, _ := light["state"].(map[string]interface{})["on"].(bool)
+			allOn = allOn && on
+			anyOn = anyOn || on
+		}
+		group["state"] = map[string]interface{}{"all_on": allOn, "any_on": anyOn}
+	}
+}
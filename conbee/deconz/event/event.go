@@ -114,7 +114,7 @@ func (e *Event) ParseState(tl TypeLookuper) error {
 		var s Daylight
 		err = json.Unmarshal(e.RawState, &s)
 		e.State = &s
-	case "Extended color light":
+	case "Extended color light", "Color temperature light", "Color light":
 		var s ExtendedColorLightState
 		err = json.Unmarshal(e.RawState, &s)
 		e.State = &s
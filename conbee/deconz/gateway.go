@@ -0,0 +1,88 @@
+package deconz
+
+import "fmt"
+
+// GatewayConfig is the configuration of the deCONZ gateway
+type GatewayConfig struct {
+	Name          string `json:"name"`
+	APIVersion    string `json:"apiversion"`
+	SWVersion     string `json:"swversion"`
+	Mac           string `json:"mac"`
+	IPAddress     string `json:"ipaddress"`
+	Websocketport int    `json:"websocketport"`
+	ZigbeeChannel int    `json:"zigbeechannel"`
+	PermitJoin    int    `json:"permitjoin"`
+	TimeZone      string `json:"timezone"`
+}
+
+// GatewayConfigUpdate changes the configuration of the gateway, fields that are nil are not changed
+type GatewayConfigUpdate struct {
+	Name       *string `json:"name,omitempty"`
+	PermitJoin *int    `json:"permitjoin,omitempty"`
+	TimeZone   *string `json:"timezone,omitempty"`
+}
+
+// TouchlinkScan is the result of a touchlink scan
+type TouchlinkScan struct {
+	ScanState string                     `json:"scanstate"` // "scanning" or "idle"
+	LastScan  string                     `json:"lastscan"`
+	Result    map[string]TouchlinkDevice `json:"result"`
+}
+
+// TouchlinkDevice is a device that was found by a touchlink scan
+type TouchlinkDevice struct {
+	Address    string `json:"address"`
+	Channel    int    `json:"channel"`
+	FactoryNew bool   `json:"factorynew"`
+	PanID      int    `json:"panid"`
+	RSSI       int    `json:"rssi"`
+}
+
+// GatewayConfig returns the configuration of the gateway
+func (a *API) GatewayConfig() (*GatewayConfig, error) {
+	c := &GatewayConfig{}
+	if err := a.get("config", c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetGatewayConfig changes the configuration of the gateway
+func (a *API) SetGatewayConfig(update *GatewayConfigUpdate) error {
+	return a.put("config", update)
+}
+
+// PermitJoin allows new devices to join the network for 'seconds' (1-255), 0 closes the network
+func (a *API) PermitJoin(seconds int) error {
+	if seconds < 0 || seconds > 255 {
+		return fmt.Errorf("permit join of %d seconds is out of range", seconds)
+	}
+	return a.SetGatewayConfig(&GatewayConfigUpdate{PermitJoin: &seconds})
+}
+
+// StartTouchlinkScan starts a touchlink scan for lights that are close to the gateway
+func (a *API) StartTouchlinkScan() error {
+	_, err := a.post("touchlink/scan", nil)
+	return err
+}
+
+// TouchlinkScan returns the (intermediate) result of the last touchlink scan
+func (a *API) TouchlinkScan() (*TouchlinkScan, error) {
+	scan := &TouchlinkScan{}
+	if err := a.get("touchlink/scan", scan); err != nil {
+		return nil, err
+	}
+	return scan, nil
+}
+
+// TouchlinkIdentify makes the device with id 'id' from the touchlink scan blink
+func (a *API) TouchlinkIdentify(id string) error {
+	_, err := a.post(fmt.Sprintf("touchlink/%s/identify", id), nil)
+	return err
+}
+
+// TouchlinkReset resets the device with id 'id' from the touchlink scan to factory new
+func (a *API) TouchlinkReset(id string) error {
+	_, err := a.post(fmt.Sprintf("touchlink/%s/reset", id), nil)
+	return err
+}
@@ -0,0 +1,101 @@
+package deconz
+
+import "fmt"
+
+// Group is a group of lights as known by the deCONZ REST api
+type Group struct {
+	ID     string     `json:"-"`
+	Name   string     `json:"name"`
+	Lights []string   `json:"lights,omitempty"`
+	Action LightState `json:"action"`
+	Scenes []Scene    `json:"scenes,omitempty"`
+	State  struct {
+		AllOn bool `json:"all_on"`
+		AnyOn bool `json:"any_on"`
+	} `json:"state"`
+}
+
+// Scene is a stored state of the lights of a group
+type Scene struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	TransitionTime int    `json:"transitiontime,omitempty"`
+	LightCount     int    `json:"lightcount,omitempty"`
+}
+
+// Groups returns all the groups indexed by their id
+func (a *API) Groups() (map[string]Group, error) {
+	groups := map[string]Group{}
+	if err := a.get("groups", &groups); err != nil {
+		return nil, err
+	}
+	for id, g := range groups {
+		g.ID = id
+		groups[id] = g
+	}
+	return groups, nil
+}
+
+// Group returns the group with id 'id'
+func (a *API) Group(id string) (*Group, error) {
+	g := &Group{}
+	if err := a.get(fmt.Sprintf("groups/%s", id), g); err != nil {
+		return nil, err
+	}
+	g.ID = id
+	return g, nil
+}
+
+// CreateGroup creates a new group and returns its id
+func (a *API) CreateGroup(name string) (string, error) {
+	return a.post("groups", map[string]string{"name": name})
+}
+
+// SetGroupLights changes the name and the lights of the group with id 'id'
+func (a *API) SetGroupLights(id string, name string, lights []string) error {
+	return a.put(fmt.Sprintf("groups/%s", id), map[string]interface{}{"name": name, "lights": lights})
+}
+
+// DeleteGroup deletes the group with id 'id'
+func (a *API) DeleteGroup(id string) error {
+	return a.delete(fmt.Sprintf("groups/%s", id))
+}
+
+// SetGroupState changes the state of all the lights in the group with id 'id'
+func (a *API) SetGroupState(id string, state *LightState) error {
+	return a.put(fmt.Sprintf("groups/%s/action", id), state)
+}
+
+// Scenes returns the scenes of the group with id 'group' indexed by their id
+func (a *API) Scenes(group string) (map[string]Scene, error) {
+	scenes := map[string]Scene{}
+	if err := a.get(fmt.Sprintf("groups/%s/scenes", group), &scenes); err != nil {
+		return nil, err
+	}
+	for id, s := range scenes {
+		s.ID = id
+		scenes[id] = s
+	}
+	return scenes, nil
+}
+
+// CreateScene creates a scene from the current state of the lights of the group and
+// returns its id
+func (a *API) CreateScene(group string, name string) (string, error) {
+	return a.post(fmt.Sprintf("groups/%s/scenes", group), map[string]string{"name": name})
+}
+
+// StoreScene stores the current state of the lights of the group in the scene
+func (a *API) StoreScene(group string, scene string) error {
+	return a.put(fmt.Sprintf("groups/%s/scenes/%s/store", group, scene), struct{}{})
+}
+
+// RecallScene changes the lights of the group to the state stored in the scene
+func (a *API) RecallScene(group string, scene string) error {
+	return a.put(fmt.Sprintf("groups/%s/scenes/%s/recall", group, scene), struct{}{})
+}
+
+// DeleteScene deletes the scene of the group
+func (a *API) DeleteScene(group string, scene string) error {
+	return a.delete(fmt.Sprintf("groups/%s/scenes/%s", group, scene))
+}
@@ -0,0 +1,58 @@
+package deconz
+
+import "fmt"
+
+// Light is a light as known by the deCONZ REST api
+type Light struct {
+	ID       string     `json:"-"`
+	Name     string     `json:"name"`
+	Type     string     `json:"type"`
+	UniqueID string     `json:"uniqueid"`
+	LastSeen string     `json:"lastseen,omitempty"`
+	State    LightState `json:"state"`
+}
+
+// LightState is the state of a light, it is also used to change the state of a light
+// or a group, fields that are nil are not changed.
+// Bri is 0-255, CT is in mired (153-500) and TransitionTime is in 1/10 of a second.
+type LightState struct {
+	On             *bool `json:"on,omitempty"`
+	Toggle         *bool `json:"toggle,omitempty"` // only for groups
+	Bri            *int  `json:"bri,omitempty"`
+	CT             *int  `json:"ct,omitempty"`
+	TransitionTime *int  `json:"transitiontime,omitempty"`
+	Reachable      *bool `json:"reachable,omitempty"`
+}
+
+// Lights returns all the lights indexed by their id
+func (a *API) Lights() (map[string]Light, error) {
+	lights := map[string]Light{}
+	if err := a.get("lights", &lights); err != nil {
+		return nil, err
+	}
+	for id, l := range lights {
+		l.ID = id
+		lights[id] = l
+	}
+	return lights, nil
+}
+
+// Light returns the light with id 'id'
+func (a *API) Light(id string) (*Light, error) {
+	l := &Light{}
+	if err := a.get(fmt.Sprintf("lights/%s", id), l); err != nil {
+		return nil, err
+	}
+	l.ID = id
+	return l, nil
+}
+
+// SetLightState changes the state of the light with id 'id'
+func (a *API) SetLightState(id string, state *LightState) error {
+	return a.put(fmt.Sprintf("lights/%s/state", id), state)
+}
+
+// RenameLight changes the name of the light with id 'id'
+func (a *API) RenameLight(id string, name string) error {
+	return a.put(fmt.Sprintf("lights/%s", id), map[string]string{"name": name})
+}
@@ -0,0 +1,68 @@
+package deconz
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Rule is a rule that is executed by the deCONZ gateway itself, for example to turn
+// on a light when a sensor reports presence even when go-home is not running.
+type Rule struct {
+	ID         string          `json:"-"`
+	Name       string          `json:"name"`
+	Status     string          `json:"status,omitempty"` // "enabled" or "disabled"
+	Conditions []RuleCondition `json:"conditions"`
+	Actions    []RuleAction    `json:"actions"`
+}
+
+// RuleCondition is a condition of a rule, e.g. {"address": "/sensors/2/state/presence", "operator": "eq", "value": "true"}
+type RuleCondition struct {
+	Address  string `json:"address"`
+	Operator string `json:"operator"`
+	Value    string `json:"value,omitempty"`
+}
+
+// RuleAction is an action of a rule, e.g. {"address": "/groups/1/action", "method": "PUT", "body": {"on": true}}
+type RuleAction struct {
+	Address string          `json:"address"`
+	Method  string          `json:"method"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// Rules returns all the rules indexed by their id
+func (a *API) Rules() (map[string]Rule, error) {
+	rules := map[string]Rule{}
+	if err := a.get("rules", &rules); err != nil {
+		return nil, err
+	}
+	for id, r := range rules {
+		r.ID = id
+		rules[id] = r
+	}
+	return rules, nil
+}
+
+// Rule returns the rule with id 'id'
+func (a *API) Rule(id string) (*Rule, error) {
+	r := &Rule{}
+	if err := a.get(fmt.Sprintf("rules/%s", id), r); err != nil {
+		return nil, err
+	}
+	r.ID = id
+	return r, nil
+}
+
+// CreateRule creates a new rule and returns its id
+func (a *API) CreateRule(rule *Rule) (string, error) {
+	return a.post("rules", rule)
+}
+
+// UpdateRule changes the rule with id 'id'
+func (a *API) UpdateRule(id string, rule *Rule) error {
+	return a.put(fmt.Sprintf("rules/%s", id), rule)
+}
+
+// DeleteRule deletes the rule with id 'id'
+func (a *API) DeleteRule(id string) error {
+	return a.delete(fmt.Sprintf("rules/%s", id))
+}
@@ -0,0 +1,58 @@
+package deconz
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Sensor is a sensor as known by the deCONZ REST api, the state depends on the type
+// of the sensor (see the event package for the known states).
+type Sensor struct {
+	ID       string          `json:"-"`
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	UniqueID string          `json:"uniqueid"`
+	LastSeen string          `json:"lastseen,omitempty"`
+	Config   SensorConfig    `json:"config"`
+	State    json.RawMessage `json:"state,omitempty"`
+}
+
+// SensorConfig is the configuration of a sensor, it is also used to change the
+// configuration of a sensor, fields that are nil are not changed.
+// Duration is the time in seconds a presence sensor keeps reporting presence.
+type SensorConfig struct {
+	On          *bool `json:"on,omitempty"`
+	Reachable   *bool `json:"reachable,omitempty"`
+	Battery     *int  `json:"battery,omitempty"`
+	Duration    *int  `json:"duration,omitempty"`
+	Sensitivity *int  `json:"sensitivity,omitempty"`
+	Delay       *int  `json:"delay,omitempty"`
+}
+
+// Sensors returns all the sensors indexed by their id
+func (a *API) Sensors() (map[string]Sensor, error) {
+	sensors := map[string]Sensor{}
+	if err := a.get("sensors", &sensors); err != nil {
+		return nil, err
+	}
+	for id, s := range sensors {
+		s.ID = id
+		sensors[id] = s
+	}
+	return sensors, nil
+}
+
+// Sensor returns the sensor with id 'id'
+func (a *API) Sensor(id string) (*Sensor, error) {
+	s := &Sensor{}
+	if err := a.get(fmt.Sprintf("sensors/%s", id), s); err != nil {
+		return nil, err
+	}
+	s.ID = id
+	return s, nil
+}
+
+// SetSensorConfig changes the configuration of the sensor with id 'id'
+func (a *API) SetSensorConfig(id string, config *SensorConfig) error {
+	return a.put(fmt.Sprintf("sensors/%s/config", id), config)
+}
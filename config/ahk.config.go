@@ -43,6 +43,7 @@ type AhkDevice struct {
 	Channel      *AhkRegister `json:"channel,omitempty"`
 	ID           uint64       `json:"id"`
 	Manufacturer string       `json:"manufacturer"`
+	Group        string       `json:"group,omitempty"` // deCONZ group that is switched by this device
 }
 
 type AhkRegister string
@@ -38,17 +38,97 @@ func (r *AutomationConfig) ToJSON() ([]byte, error) {
 	return nil, err
 }
 
-// AutomationConfig holds the configuration for automation
+// AutomationConfig holds the configuration for automation.
+// When PresenceOccupancy names an occupancy sensor (see the occupancy service) it decides
+// if people are home, otherwise this is detected from the PresenceDoors and activity.
 type AutomationConfig struct {
 	SubChannels        []string                 `json:"subscribing_channels"`
 	ChannelsToRegister []string                 `json:"register_channels"`
 	DeviceControlCache map[string]DeviceControl `json:"device_control_json_cache"`
+	PresenceDoors      []string                 `json:"presence_doors"`
+	PresenceOccupancy  string                   `json:"presence_occupancy,omitempty"`
+	Rules              []AutomationRule         `json:"rules"`
+	Timers             AutomationTimers         `json:"timers"`
 }
 
-// DeviceControl holds the configuration to control a device
+// AutomationTimers configures where pending timed actions are persisted and what to do
+// with actions that became overdue while automation was not running.
+// Store is "file" (Path is a filename) or "kv" (Path is the NATS key-value bucket), CatchUp
+// is "all", "none" or "window" where CatchUpWindow (e.g. "30m") is the maximum overdue time.
+type AutomationTimers struct {
+	Store         string `json:"store"`
+	Path          string `json:"path"`
+	CatchUp       string `json:"catchup"`
+	CatchUpWindow string `json:"catchup_window"`
+}
+
+// AutomationRule is a single 'when trigger, if conditions, then actions' rule
+type AutomationRule struct {
+	Name       string                `json:"name"`
+	Trigger    AutomationTrigger     `json:"trigger"`
+	Conditions []AutomationCondition `json:"conditions,omitempty"`
+	Actions    []AutomationAction    `json:"actions"`
+}
+
+// AutomationTrigger describes the event that will make a rule evaluate its conditions
+// Type can be one of:
+//   - "sensor": a SensorState received on 'topic' with 'name' where attribute 'attribute' equals 'value'
+//   - "timeofday": the time-of-day sensor transitioned to 'value'
+//   - "time": the clock matches the cron specification in 'cron' (e.g. "20 6 * * 1-5")
+//   - "presence": people arrived ('value' = "arrived") or left ('value' = "left") the house, or
+//     when 'name' is set that specific person changed to 'value' ("home" or "away")
+type AutomationTrigger struct {
+	Type      string `json:"type"`
+	Topic     string `json:"topic,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Attribute string `json:"attribute,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Cron      string `json:"cron,omitempty"`
+}
+
+// AutomationCondition is a condition that needs to be true for the actions of a rule to execute,
+// setting 'not' inverts the result of the condition.
+// Type can be one of:
+//   - "sensor": the last known value of sensor 'name' equals 'value'
+//   - "peoplehome": 'value' is "true" or "false" and should match the home presence state
+//   - "day": today is one of 'values' ("weekday", "weekend", "monday", ...)
+//   - "idle": sensor 'name' has not been active for at least 'duration' (e.g. "15m")
+type AutomationCondition struct {
+	Type     string   `json:"type"`
+	Name     string   `json:"name,omitempty"`
+	Value    string   `json:"value,omitempty"`
+	Values   []string `json:"values,omitempty"`
+	Duration string   `json:"duration,omitempty"`
+	Not      bool     `json:"not,omitempty"`
+}
+
+// AutomationAction is an action executed by a rule
+// Type can be one of:
+//   - "turnon", "turnoff", "toggle": control 'device' which is an entry in the device control cache
+//   - "notify": send 'message' to shout
+//   - "delay": execute the remaining actions of the rule after 'duration', the delay is identified
+//     by 'name' (default is the rule name) and is restarted when triggered again while waiting
+type AutomationAction struct {
+	Type     string `json:"type"`
+	Name     string `json:"name,omitempty"`
+	Device   string `json:"device,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// DeviceControl holds the configuration to control a device, commands are published
+// unless the service of the device replies on them (Ack or Retries), then they are send
+// as a request and a failed 'on' or 'off' is send again at most Retries times.
 type DeviceControl struct {
 	Channel string `json:"channel"`
 	On      string `json:"on"`
 	Off     string `json:"off"`
 	Toggle  string `json:"toggle"`
+	Ack     bool   `json:"ack,omitempty"`
+	Retries int    `json:"retries,omitempty"`
+}
+
+// Acknowledged returns true when the commands of the device are send as a request
+func (dc DeviceControl) Acknowledged() bool {
+	return dc.Ack || dc.Retries > 0
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jurgen-kluft/go-home/config"
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+// identity is how a service authenticates, with the public key of its NKey or with its
+// name and the bcrypt hash of its password
+type identity struct {
+	NKey     string
+	Password string
+}
+
+// subject converts a channel to a NATS subject, e.g. "state/light/conbee/" to "state.light.conbee"
+func subject(channel string) string {
+	return strings.TrimSuffix(strings.Replace(channel, "/", ".", -1), ".")
+}
+
+func subjects(channels ...[]string) []string {
+	unique := map[string]bool{}
+	for _, list := range channels {
+		for _, channel := range list {
+			unique[subject(channel)] = true
+		}
+	}
+	result := []string{}
+	for s := range unique {
+		result = append(result, s)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// permissionsOf returns the subjects that service 'p' may publish and subscribe to, next to
+// the common ones it may subscribe to its configuration and to the replies of its requests
+func permissionsOf(cfg *config.PermissionsConfig, p config.ServicePermissions) (publish []string, subscribe []string) {
+	publish = subjects(cfg.Common.Publish, p.Publish)
+	own := []string{"config/" + p.Name + "/", pubsub.InboxPrefix(p.Name) + ".>"}
+	subscribe = subjects(cfg.Common.Subscribe, p.Subscribe, own)
+	return publish, subscribe
+}
+
+func quoted(list []string) string {
+	q := []string{}
+	for _, s := range list {
+		q = append(q, strconv.Quote(s))
+	}
+	return "[" + strings.Join(q, ", ") + "]"
+}
+
+// authorization returns the authorization block of a NATS server configuration with a user
+// for every service that has an identity
+func authorization(cfg *config.PermissionsConfig, identities map[string]identity) (string, error) {
+	var b strings.Builder
+	b.WriteString("# generated by busauth from the permissions of the services, do not edit\n")
+	b.WriteString("authorization {\n  users = [\n")
+	for _, p := range cfg.Services {
+		id, ok := identities[p.Name]
+		if !ok {
+			return "", fmt.Errorf("%s has no identity", p.Name)
+		}
+		b.WriteString("    {\n")
+		b.WriteString("      # " + p.Name + "\n")
+		if id.NKey != "" {
+			b.WriteString("      nkey: " + strconv.Quote(id.NKey) + "\n")
+		} else {
+			b.WriteString("      user: " + strconv.Quote(p.Name) + "\n")
+			b.WriteString("      password: " + strconv.Quote(id.Password) + "\n")
+		}
+		publish, subscribe := permissionsOf(cfg, p)
+		b.WriteString("      permissions: {\n")
+		b.WriteString("        publish: { allow: " + quoted(publish) + " }\n")
+		b.WriteString("        subscribe: { allow: " + quoted(subscribe) + " }\n")
+		b.WriteString("        allow_responses: true\n")
+		b.WriteString("      }\n")
+		b.WriteString("    }\n")
+	}
+	b.WriteString("  ]\n}\n")
+	return b.String(), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jurgen-kluft/go-home/config"
+)
+
+func TestAuthorization(t *testing.T) {
+	cfg := &config.PermissionsConfig{
+		Common: config.ServicePermissions{Publish: []string{"config/request/", "service/heartbeat/"}},
+		Services: []config.ServicePermissions{
+			{Name: "aqi", Publish: []string{"state/sensor/aqi/"}},
+			{Name: "samsung.tv", Publish: []string{"state/samsung.tv/"}, Subscribe: []string{"state/samsung.tv/automation/"}},
+		},
+	}
+
+	publish, subscribe := permissionsOf(cfg, cfg.Services[1])
+	if want := []string{"config.request", "service.heartbeat", "state.samsung.tv"}; !reflect.DeepEqual(publish, want) {
+		t.Errorf("publish of samsung.tv = %q, want %q", publish, want)
+	}
+	if want := []string{"_INBOX_samsung_tv.>", "config.samsung.tv", "state.samsung.tv.automation"}; !reflect.DeepEqual(subscribe, want) {
+		t.Errorf("subscribe of samsung.tv = %q, want %q", subscribe, want)
+	}
+
+	identities := map[string]identity{"aqi": {NKey: "UAQI"}, "samsung.tv": {Password: "$2a$10$hash"}}
+	conf, err := authorization(cfg, identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`nkey: "UAQI"`,
+		`publish: { allow: ["config.request", "service.heartbeat", "state.sensor.aqi"] }`,
+		`subscribe: { allow: ["_INBOX_aqi.>", "config.aqi"] }`,
+		`user: "samsung.tv"`,
+		`password: "$2a$10$hash"`,
+	} {
+		if !strings.Contains(conf, line) {
+			t.Errorf("authorization does not contain %s:\n%s", line, conf)
+		}
+	}
+
+	delete(identities, "aqi")
+	if _, err := authorization(cfg, identities); err == nil {
+		t.Errorf("authorization() of a service without an identity succeeded")
+	}
+}
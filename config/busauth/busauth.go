@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jurgen-kluft/go-home/config"
+	"github.com/nats-io/nkeys"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// nkeyOf returns the public key of the NKey of a service, the seed is generated when
+// the service does not have one yet
+func nkeyOf(dir string, name string) (string, error) {
+	filename := filepath.Join(dir, name+".nk")
+	seed, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		user, err := nkeys.CreateUser()
+		if err != nil {
+			return "", err
+		}
+		if seed, err = user.Seed(); err != nil {
+			return "", err
+		}
+		if err = ioutil.WriteFile(filename, seed, 0600); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+	user, err := nkeys.FromSeed([]byte(strings.TrimSpace(string(seed))))
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", filename, err)
+	}
+	return user.PublicKey()
+}
+
+// passwordOf returns the bcrypt hash of the password of a service, the password is
+// generated when the service does not have one yet
+func passwordOf(dir string, name string) (string, error) {
+	filename := filepath.Join(dir, name+".password")
+	password, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		random := make([]byte, 24)
+		if _, err = rand.Read(random); err != nil {
+			return "", err
+		}
+		password = []byte(base64.RawURLEncoding.EncodeToString(random))
+		if err = ioutil.WriteFile(filename, password, 0600); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimSpace(string(password))), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "busauth"
+	app.Usage = "Generate the identities of the services and the authorization of the NATS server"
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "permissions",
+			Value: "permissions.config.json",
+			Usage: "The channels that every service may publish and subscribe to",
+		},
+		&cli.StringFlag{
+			Name:  "credentials",
+			Value: "credentials",
+			Usage: "The directory with the identities of the services, existing ones are kept",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Value: "nats-auth.conf",
+			Usage: "The NATS server authorization configuration to write",
+		},
+		&cli.BoolFlag{
+			Name:  "passwords",
+			Usage: "Generate passwords instead of NKeys for the services that have no identity yet",
+		},
+	}
+
+	app.Action = func(c *cli.Context) error {
+		data, err := ioutil.ReadFile(c.String("permissions"))
+		if err != nil {
+			return err
+		}
+		cfg, err := config.PermissionsConfigFromJSON(data)
+		if err != nil {
+			return err
+		}
+		dir := c.String("credentials")
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+
+		identities := map[string]identity{}
+		for _, p := range cfg.Services {
+			// like the services, prefer an NKey over a password
+			var id identity
+			_, noseed := os.Stat(filepath.Join(dir, p.Name+".nk"))
+			_, nopassword := os.Stat(filepath.Join(dir, p.Name+".password"))
+			if noseed != nil && (nopassword == nil || c.Bool("passwords")) {
+				id.Password, err = passwordOf(dir, p.Name)
+			} else {
+				id.NKey, err = nkeyOf(dir, p.Name)
+			}
+			if err != nil {
+				return err
+			}
+			identities[p.Name] = id
+		}
+
+		conf, err := authorization(cfg, identities)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(c.String("out"), []byte(conf), 0600); err != nil {
+			return err
+		}
+		fmt.Printf("wrote the authorization of %d services to %s\n", len(cfg.Services), c.String("out"))
+		return nil
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
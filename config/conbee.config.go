@@ -18,30 +18,40 @@ func (r *ConbeeConfig) FromJSON(data []byte) error {
 }
 
 // ToJSON converts a ConbeeConfig to a JSON string
-func (r *ConbeeConfig) ToJSON() (string, error) {
+func (r *ConbeeConfig) ToJSON() ([]byte, error) {
 	data, err := json.Marshal(r)
 	if err == nil {
-		return string(data), nil
+		return data, nil
 	}
-	return "", err
+	return nil, err
 }
 
-// ConbeeConfig contains information to connect to a Conbee-II device
+// ConbeeConfig contains information to connect to a Conbee-II device, 'APIKey' is the
+// name of the secret that holds the API key of the deCONZ gateway.
+// Events of lights, switches and sensors are published as SensorState on the 'out'
+// channels, light commands are received on the 'in' channels. The state of the lights
+// and the battery and reachability of the sensors and switches are polled every
+// 'poll_interval_sec' seconds, the latter are published on 'health.out'.
 type ConbeeConfig struct {
-	Addr        string         `json:"Addr"`
-	APIKey      string         `json:"APIKey"`
-	LightsOut   string         `json:"lights.out"`
-	SwitchesOut string         `json:"switches.out"`
-	SensorsOut  string         `json:"sensors.out"`
-	LightsIn    []string       `json:"lights.in"`
-	Switches    []ConbeeDevice `json:"switches"`
-	Sensors     ConbeeSensors  `json:"sensors"`
-	Lights      []ConbeeLight  `json:"lights"`
+	Addr            string         `json:"Addr"`
+	APIKey          Secret         `json:"APIKey"`
+	LightsOut       string         `json:"lights.out"`
+	SwitchesOut     string         `json:"switches.out"`
+	SensorsOut      string         `json:"sensors.out"`
+	HealthOut       string         `json:"health.out"`
+	LightsIn        []string       `json:"lights.in"`
+	PollIntervalSec int            `json:"poll_interval_sec"`
+	Switches        []ConbeeDevice `json:"switches"`
+	Sensors         ConbeeSensors  `json:"sensors"`
+	Lights          []ConbeeLight  `json:"lights"`
 }
 
+// ConbeeLight is a named set of lights identified by their unique-id, when 'group' is set
+// commands are send to that deCONZ group instead of to every light.
 type ConbeeLight struct {
-	Name string   `json:"name"`
-	IDS  []string `json:"ids"`
+	Name  string   `json:"name"`
+	IDS   []string `json:"ids"`
+	Group string   `json:"group,omitempty"`
 }
 
 type ConbeeSensors struct {
@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"os"
 
 	"github.com/jurgen-kluft/go-home/config"
 	"github.com/jurgen-kluft/go-home/micro-service"
+	"github.com/jurgen-kluft/go-home/natsserver"
+	"github.com/jurgen-kluft/go-home/zeroconf"
+	"github.com/nats-io/nats-server/v2/server"
 )
 
 // Configs holds all the config objects that we can have
@@ -39,41 +44,12 @@ func configFromJSON(data []byte) (*configs, error) {
 }
 
 func (c *context) configFromJSON(configname string, jsondata []byte) (config.Config, error) {
-	var ci config.Config
-	var err error
 	c.service.Logger.LogInfo(c.service.Name, fmt.Sprintf("configuration %s, FromJSON", configname))
-	switch configname {
-	case "aqi":
-		ci, err = config.AqiConfigFromJSON(jsondata)
-	case "automation":
-		ci, err = config.AutomationConfigFromJSON(jsondata)
-	case "bravia.tv":
-		ci, err = config.BraviaTVConfigFromJSON(jsondata)
-	case "calendar":
-		ci, err = config.CalendarConfigFromJSON(jsondata)
-	case "flux":
-		ci, err = config.FluxConfigFromJSON(jsondata)
-	case "hue":
-		ci, err = config.HueConfigFromJSON(jsondata)
-	case "huebridge":
-		ci, err = config.HueBridgeConfigFromJSON(jsondata)
-	case "presence":
-		ci, err = config.PresenceConfigFromJSON(jsondata)
-	case "samsung.tv":
-		ci, err = config.SamsungTVConfigFromJSON(jsondata)
-	case "shout":
-		ci, err = config.ShoutConfigFromJSON(jsondata)
-	case "suncalc":
-		ci, err = config.SuncalcConfigFromJSON(jsondata)
-	case "weather":
-		ci, err = config.WeatherConfigFromJSON(jsondata)
-	case "wemo":
-		ci, err = config.WemoConfigFromJSON(jsondata)
-	case "xiaomi":
-		ci, err = config.XiaomiConfigFromJSON(jsondata)
-	case "yee":
-		ci, err = config.YeeConfigFromJSON(jsondata)
+	ci := config.New(configname)
+	if ci == nil {
+		return nil, fmt.Errorf("configuration %s is unknown", configname)
 	}
+	err := ci.FromJSON(jsondata)
 	return ci, err
 }
 
@@ -142,6 +118,9 @@ func (c *context) sendConfigOnChannel(configtype string) (err error) {
 				v, err := c.configFromJSON(configtype, configJSONData)
 				if err == nil {
 					jsondata, err := v.ToJSON()
+					if err == nil {
+						jsondata, err = config.WithServiceOptions(jsondata, configJSONData)
+					}
 					if err == nil {
 						c.service.Logger.LogInfo(c.service.Name, fmt.Sprintf("Publish %s on channel %s", string(jsondata), configuration.ChannelName))
 						err = c.service.Pubsub.Publish(configuration.ChannelName, jsondata)
@@ -159,6 +138,29 @@ func (c *context) sendConfigOnChannel(configtype string) (err error) {
 	return
 }
 
+func startNatsServer(filename string) (*server.Server, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.NatsdConfigFromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return natsserver.Start(cfg)
+}
+
+// pubsubConfigOf returns 'cfg' for the NATS server at 'url'
+func pubsubConfigOf(cfg map[string]string, url string) map[string]string {
+	local := map[string]string{}
+	for key, value := range cfg {
+		local[key] = value
+	}
+	local["transport"] = "nats"
+	local["host"] = url
+	return local
+}
+
 func main() {
 	register := []string{"config/config/", "config/request/"}
 	subscribe := []string{"config/config/", "config/request/"}
@@ -166,6 +168,31 @@ func main() {
 	m := microservice.New("config")
 	m.RegisterAndSubscribe(register, subscribe)
 
+	// GO_HOME_NATSD names a natsd configuration, the config service then runs the embedded
+	// NATS server itself so that a development setup needs no separate NATS server.
+	if natsdconfig := os.Getenv("GO_HOME_NATSD"); natsdconfig != "" {
+		s, err := startNatsServer(natsdconfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.Shutdown()
+		m.PubsubConfig = pubsubConfigOf(m.PubsubConfig, s.ClientURL())
+	}
+
+	// The config service is the one that announces the message bus and InfluxDB on the LAN
+	// for the other services, it uses the bus it is configured with.
+	m.Discover = false
+	if bus, err := zeroconf.BusEntry(m.PubsubConfig); err == nil {
+		m.Announcements = append(m.Announcements, bus)
+	} else {
+		m.Logger.LogError(m.Name, err.Error())
+	}
+	if influx, err := zeroconf.InfluxEntry(config.InfluxSecrets); err == nil {
+		m.Announcements = append(m.Announcements, influx)
+	} else {
+		m.Logger.LogError(m.Name, err.Error())
+	}
+
 	ctx := newContext()
 	ctx.service = m
 
@@ -4,23 +4,40 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql/driver"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// CryptString is a string that is encrypted in JSON, it is formatted as Redacted so that
+// it does not end up in a log.
 type CryptString struct {
 	String string
 }
 
-var cryptKeeperKey []byte
+var (
+	cryptKeeperKey []byte            // the key that encrypts
+	cryptKeys      map[string][]byte // the keys that decrypt, by key ID
+	cfbKey         []byte            // the key of the AES-CFB ciphertexts, see SetCFBKey
+)
+
+// Format implements fmt.Formatter, the value is never formatted
+func (cs CryptString) Format(f fmt.State, verb rune) {
+	io.WriteString(f, Redacted)
+}
 
-// MarshalJSON encrypts and marshals nested String
-func (cs *CryptString) MarshalJSON() ([]byte, error) {
+// MarshalJSON encrypts and marshals nested String, also when the CryptString is not addressable
+func (cs CryptString) MarshalJSON() ([]byte, error) {
 	encString, err := Encrypt(cs.String)
 	if err != nil {
 		return nil, err
@@ -66,61 +83,185 @@ func (cs CryptString) Value() (value driver.Value, err error) {
 	return Encrypt(cs.String)
 }
 
-// SetCryptKey with user input
-func SetCryptKey(secretKey []byte) error {
+// ErrNoCryptKey is returned when GO_HOME_KEY is not set
+var ErrNoCryptKey = errors.New("the environment variable GO_HOME_KEY is not set")
+
+// KeyID returns the ID of a key, it is stored with the ciphertext so that the key that
+// decrypts it can be found
+func KeyID(secretKey []byte) string {
+	sum := sha256.Sum256(secretKey)
+	return hex.EncodeToString(sum[:4])
+}
+
+func checkCryptKey(secretKey []byte) error {
 	keyLen := len(secretKey)
 	if keyLen != 16 && keyLen != 24 && keyLen != 32 {
 		return fmt.Errorf("Invalid KEY to set for CRYPT_KEEPER_KEY; must be 16, 24, or 32 bytes (got %d)", keyLen)
 	}
+	return nil
+}
+
+// SetCryptKey sets the key that encrypts, it also decrypts
+func SetCryptKey(secretKey []byte) error {
+	if err := checkCryptKey(secretKey); err != nil {
+		return err
+	}
 	cryptKeeperKey = secretKey
+	if cryptKeys == nil {
+		cryptKeys = map[string][]byte{}
+	}
+	cryptKeys[KeyID(secretKey)] = secretKey
 	return nil
 }
 
-// CryptKey returns a valid Crypt key
-func CryptKey() []byte {
+// AddCryptKey adds a key that only decrypts, e.g. the previous key while the values are
+// re-encrypted with a new one
+func AddCryptKey(secretKey []byte) error {
+	if err := checkCryptKey(secretKey); err != nil {
+		return err
+	}
+	if cryptKeys == nil {
+		cryptKeys = map[string][]byte{}
+	}
+	cryptKeys[KeyID(secretKey)] = secretKey
+	return nil
+}
+
+// SetCFBKey sets the key that the AES-CFB ciphertexts of the first version are encrypted
+// with. Without it they are decrypted with the key that encrypts, which is refused once
+// old keys are added since the key was most likely rotated.
+func SetCFBKey(secretKey []byte) error {
+	if err := checkCryptKey(secretKey); err != nil {
+		return err
+	}
+	cfbKey = secretKey
+	return nil
+}
+
+// CryptKey returns the key that encrypts, GO_HOME_KEY, the keys in GO_HOME_OLD_KEYS
+// (separated by commas) only decrypt and GO_HOME_CFB_KEY is the key of SetCFBKey.
+func CryptKey() ([]byte, error) {
 	if cryptKeeperKey == nil {
 		key := os.Getenv("GO_HOME_KEY")
 		if key == "" {
-			fmt.Println("Error, you did not set the environment variable GO_HOME_KEY")
-		} else {
-			SetCryptKey([]byte(key))
+			return nil, ErrNoCryptKey
+		}
+		if err := SetCryptKey([]byte(key)); err != nil {
+			return nil, err
+		}
+		for _, old := range strings.Split(os.Getenv("GO_HOME_OLD_KEYS"), ",") {
+			if old = strings.TrimSpace(old); old != "" {
+				if err := AddCryptKey([]byte(old)); err != nil {
+					return nil, fmt.Errorf("GO_HOME_OLD_KEYS: %v", err)
+				}
+			}
+		}
+		if key := os.Getenv("GO_HOME_CFB_KEY"); key != "" && cfbKey == nil {
+			if err := SetCFBKey([]byte(key)); err != nil {
+				return nil, fmt.Errorf("GO_HOME_CFB_KEY: %v", err)
+			}
 		}
 	}
-	//fmt.Println("CryptKey:", string(cryptKeeperKey))
-	return cryptKeeperKey
+	return cryptKeeperKey, nil
 }
 
-// Encrypt AES-encrypt string and then base64-encode
-func Encrypt(text string) (string, error) {
-	plaintext := []byte(text)
+// cipherVersion is the prefix of the ciphertexts that are encrypted with AES-GCM, they
+// are "v2:<key ID>:<base64 of nonce and sealed text>". Ciphertexts without it are
+// encrypted with AES-CFB (the first version), see SetCFBKey.
+const cipherVersion = "v2:"
 
-	block, err := aes.NewCipher(CryptKey())
+// Encrypt AES-GCM encrypts a string and returns it in the versioned format
+func Encrypt(text string) (string, error) {
+	key, err := CryptKey()
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	id := KeyID(key)
+	sealed := aead.Seal(nonce, nonce, []byte(text), []byte(id))
+	return cipherVersion + id + ":" + base64.URLEncoding.EncodeToString(sealed), nil
+}
 
-	// The IV needs to be unique, but not secure. Therefore it's common to
-	// include it at the beginning of the ciphertext.
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+// Decrypt decrypts a string that Encrypt returned, or that the first version encrypted
+func Decrypt(cryptoText string) (string, error) {
+	if !strings.HasPrefix(cryptoText, cipherVersion) {
+		return decryptCFB(cryptoText)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(cryptoText, cipherVersion), ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid ciphertext, the key ID is missing")
+	}
+	if _, err := CryptKey(); err != nil {
+		return "", err
+	}
+	id := parts[0]
+	key, known := cryptKeys[id]
+	if !known {
+		return "", fmt.Errorf("the key %s is not known, add it to GO_HOME_OLD_KEYS", id)
+	}
+	sealed, err := base64.URLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
 		return "", err
 	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid cipher size %d", len(sealed))
+	}
+	nonce := sealed[:aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt with key %s: %v", id, err)
+	}
+	return string(plaintext), nil
+}
 
-	cipher.NewCFBEncrypter(block, iv).XORKeyStream(ciphertext[aes.BlockSize:], plaintext)
+// NeedsRotation returns true when a ciphertext is not encrypted with AES-GCM and the key
+// that encrypts
+func NeedsRotation(cryptoText string) bool {
+	key, err := CryptKey()
+	if err != nil {
+		return false
+	}
+	return !strings.HasPrefix(cryptoText, cipherVersion+KeyID(key)+":")
+}
 
-	// convert to base64
-	return base64.URLEncoding.EncodeToString(ciphertext), nil
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
-// Decrypt base64-decode and then AES decrypt string
-func Decrypt(cryptoText string) (string, error) {
+// decryptCFB base64-decode and then AES-CFB decrypt string. CFB does not detect a wrong
+// key, so the key has to be the one of SetCFBKey when there are old keys and the result
+// has to be text.
+func decryptCFB(cryptoText string) (string, error) {
 	ciphertext, err := base64.URLEncoding.DecodeString(cryptoText)
 	if err != nil {
 		return "", err
 	}
 
-	block, err := aes.NewCipher(CryptKey())
+	key, err := CryptKey()
+	if err != nil {
+		return "", err
+	}
+	if cfbKey != nil {
+		key = cfbKey
+	} else if len(cryptKeys) > 1 {
+		return "", fmt.Errorf("a value of the first version is not decrypted while there are old keys, set its key with GO_HOME_CFB_KEY")
+	}
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
@@ -137,5 +278,22 @@ func Decrypt(cryptoText string) (string, error) {
 	// XORKeyStream can work in-place if the two arguments are the same.
 	cipher.NewCFBDecrypter(block, iv).XORKeyStream(ciphertext, ciphertext)
 
+	if !isText(ciphertext) {
+		return "", fmt.Errorf("a value of the first version does not decrypt to text with key %s, set its key with GO_HOME_CFB_KEY", KeyID(key))
+	}
 	return string(ciphertext), nil
 }
+
+// isText tells if 'b' is printable UTF-8, which a value that is decrypted with the wrong
+// key hardly ever is
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+)
+
+func resetCryptKeys() {
+	cryptKeeperKey = nil
+	cryptKeys = nil
+	cfbKey = nil
+}
+
+// encryptCFB encrypts like the first version of Encrypt did
+func encryptCFB(t *testing.T, key []byte, text string) string {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := make([]byte, aes.BlockSize+len(text))
+	cipher.NewCFBEncrypter(block, ciphertext[:aes.BlockSize]).XORKeyStream(ciphertext[aes.BlockSize:], []byte(text))
+	return base64.URLEncoding.EncodeToString(ciphertext)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	defer resetCryptKeys()
+	resetCryptKeys()
+	os.Unsetenv("GO_HOME_KEY")
+	if _, err := Encrypt("token"); err != ErrNoCryptKey {
+		t.Errorf("Encrypt() without a key = %v", err)
+	}
+
+	oldkey, newkey := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	SetCryptKey(oldkey)
+	old, err := Encrypt("token")
+	if err != nil || !strings.HasPrefix(old, "v2:"+KeyID(oldkey)+":") {
+		t.Fatalf("Encrypt() = %s, %v", old, err)
+	}
+	if text, err := Decrypt(old); err != nil || text != "token" {
+		t.Errorf("Decrypt() = %s, %v", text, err)
+	}
+	if text, err := Decrypt(encryptCFB(t, oldkey, "cfb token")); err != nil || text != "cfb token" {
+		t.Errorf("Decrypt() of a CFB ciphertext = %s, %v", text, err)
+	}
+
+	// a tampered ciphertext is detected
+	sealed, _ := base64.URLEncoding.DecodeString(strings.SplitN(old, ":", 3)[2])
+	sealed[len(sealed)-1] ^= 1
+	if _, err := Decrypt("v2:" + KeyID(oldkey) + ":" + base64.URLEncoding.EncodeToString(sealed)); err == nil {
+		t.Errorf("Decrypt() of a tampered ciphertext succeeded")
+	}
+
+	// a new key encrypts, the old key still decrypts once it is added
+	resetCryptKeys()
+	SetCryptKey(newkey)
+	if _, err := Decrypt(old); err == nil || !strings.Contains(err.Error(), KeyID(oldkey)) {
+		t.Errorf("Decrypt() with an unknown key = %v", err)
+	}
+	AddCryptKey(oldkey)
+	if text, err := Decrypt(old); err != nil || text != "token" {
+		t.Errorf("Decrypt() with the old key = %s, %v", text, err)
+	}
+	if !NeedsRotation(old) {
+		t.Errorf("NeedsRotation() of a ciphertext of the old key is false")
+	}
+	if current, _ := Encrypt("token"); NeedsRotation(current) || !strings.HasPrefix(current, "v2:"+KeyID(newkey)+":") {
+		t.Errorf("Encrypt() = %s does not use the new key", current)
+	}
+}
+
+func TestCryptKeysFromEnv(t *testing.T) {
+	defer resetCryptKeys()
+	defer os.Unsetenv("GO_HOME_KEY")
+	defer os.Unsetenv("GO_HOME_OLD_KEYS")
+	resetCryptKeys()
+	os.Setenv("GO_HOME_KEY", "fedcba9876543210")
+	os.Setenv("GO_HOME_OLD_KEYS", "0123456789abcdef, 0123456789abcdef01234567")
+	key, err := CryptKey()
+	if err != nil || string(key) != "fedcba9876543210" || len(cryptKeys) != 3 {
+		t.Errorf("CryptKey() = %s, %v with %d keys", key, err, len(cryptKeys))
+	}
+	resetCryptKeys()
+	os.Setenv("GO_HOME_OLD_KEYS", "short")
+	if _, err := CryptKey(); err == nil {
+		t.Errorf("CryptKey() with an invalid old key succeeded")
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	defer resetCryptKeys()
+	resetCryptKeys()
+	oldkey, newkey := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	SetCryptKey(oldkey)
+	gcm, _ := Encrypt("xoxb-gcm")
+
+	// CFB values are decrypted with the key that encrypts, a GCM value with the key of its ID
+	tests := []struct {
+		ciphertext string
+		oldkeys    [][]byte
+	}{
+		{encryptCFB(t, newkey, "xoxb-cfb"), nil},
+		{gcm, [][]byte{oldkey}},
+	}
+	for _, tt := range tests {
+		resetCryptKeys()
+		SetCryptKey(newkey)
+		for _, key := range tt.oldkeys {
+			AddCryptKey(key)
+		}
+		original := "{\n    \"key\":   \"" + tt.ciphertext + "\",\n    \"channel\": \"#go-home\"\n}\n"
+		data, n, err := Reencrypt("shout", []byte(original))
+		if err != nil || n != 1 {
+			t.Fatalf("Reencrypt() = %d, %v", n, err)
+		}
+		cfg, err := ShoutConfigFromJSON(data)
+		if err != nil || !strings.HasPrefix(cfg.Key.String, "xoxb-") || cfg.Channel != "#go-home" {
+			t.Errorf("Reencrypt() = %s, %v", data, err)
+		}
+		if !strings.HasPrefix(string(data), "{\n    \"key\":   \"v2:"+KeyID(newkey)+":") || !strings.HasSuffix(string(data), "\"channel\": \"#go-home\"\n}\n") {
+			t.Errorf("Reencrypt() changed the layout: %s", data)
+		}
+		if _, n, _ := Reencrypt("shout", data); n != 0 {
+			t.Errorf("Reencrypt() encrypted %d values again", n)
+		}
+	}
+
+	// after a rotation a CFB value is only decrypted with the key that is set for it
+	resetCryptKeys()
+	SetCryptKey(newkey)
+	AddCryptKey(oldkey)
+	cfb := "{\"key\": \"" + encryptCFB(t, oldkey, "xoxb-cfb") + "\"}"
+	if data, n, err := Reencrypt("shout", []byte(cfb)); err == nil {
+		t.Errorf("Reencrypt() of a CFB value with old keys = %s, %d", data, n)
+	}
+	SetCFBKey(oldkey)
+	data, n, err := Reencrypt("shout", []byte(cfb))
+	if cfg, _ := ShoutConfigFromJSON(data); err != nil || n != 1 || cfg.Key.String != "xoxb-cfb" {
+		t.Errorf("Reencrypt() with the CFB key = %s, %d, %v", data, n, err)
+	}
+
+	// a CFB value that is decrypted with the wrong key is not text
+	resetCryptKeys()
+	SetCryptKey(newkey)
+	if text, err := Decrypt(encryptCFB(t, oldkey, "xoxb-cfb")); err == nil {
+		t.Errorf("Decrypt() of a CFB value with the wrong key = %q", text)
+	}
+}
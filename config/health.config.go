@@ -0,0 +1,49 @@
+package config
+
+import "encoding/json"
+
+// HealthConfigFromJSON parser the incoming JSON string and returns an Config instance for Health
+func HealthConfigFromJSON(data []byte) (*HealthConfig, error) {
+	r := &HealthConfig{}
+	err := json.Unmarshal(data, r)
+	return r, err
+}
+
+// FromJSON converts a json string to a HealthConfig instance
+func (r *HealthConfig) FromJSON(data []byte) error {
+	c := HealthConfig{}
+	err := json.Unmarshal(data, &c)
+	*r = c
+	return err
+}
+
+// ToJSON converts a HealthConfig to a JSON string
+func (r *HealthConfig) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err == nil {
+		return data, nil
+	}
+	return nil, err
+}
+
+// HealthConfig holds the configuration for the health service. The conbee and xiaomi
+// services publish a SensorState of type "health" for every configured device, the
+// health service publishes the health of every device on 'channel' and sends a message
+// to 'shout' when a battery is below 'battery_low' percent or when a device has not been
+// seen for longer than the interval of its type (e.g. "motion": "2h").
+type HealthConfig struct {
+	SubChannels []string          `json:"subscribing_channels"`
+	Channel     string            `json:"channel"`
+	Shout       string            `json:"shout"`
+	BatteryLow  float64           `json:"battery_low"`
+	Intervals   map[string]string `json:"intervals"`
+	Batteries   []HealthBattery   `json:"batteries"`
+}
+
+// HealthBattery is the voltage range of a battery type, it is used to convert a
+// reported voltage (in mV) into a percentage.
+type HealthBattery struct {
+	Type  string  `json:"type"`
+	Empty float64 `json:"empty"`
+	Full  float64 `json:"full"`
+}
@@ -0,0 +1,7 @@
+package config
+
+// InfluxSecrets is the InfluxDB that metrics uses when it does not find one on the LAN
+var InfluxSecrets = map[string]string{
+	"host":     "http://localhost:8086",
+	"database": "gohome",
+}
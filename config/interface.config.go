@@ -4,3 +4,54 @@ type Config interface {
 	FromJSON(json []byte) error
 	ToJSON() ([]byte, error)
 }
+
+// New returns an empty configuration of the service 'name', nil when there is none
+func New(name string) Config {
+	switch name {
+	case "aqi":
+		return &AqiConfig{}
+	case "automation":
+		return &AutomationConfig{}
+	case "bravia.tv":
+		return &BraviaTVConfig{}
+	case "calendar":
+		return &CalendarConfig{}
+	case "conbee":
+		return &ConbeeConfig{}
+	case "flux":
+		return &FluxConfig{}
+	case "health":
+		return &HealthConfig{}
+	case "hue":
+		return &HueConfig{}
+	case "huebridge":
+		return &HueBridgeConfig{}
+	case "natsd":
+		return &NatsdConfig{}
+	case "occupancy":
+		return &OccupancyConfig{}
+	case "presence":
+		return &PresenceConfig{}
+	case "recorder":
+		return &RecorderConfig{}
+	case "registry":
+		return &RegistryConfig{}
+	case "samsung.tv":
+		return &SamsungTVConfig{}
+	case "shout":
+		return &ShoutConfig{}
+	case "statecache":
+		return &StateCacheConfig{}
+	case "suncalc":
+		return &SuncalcConfig{}
+	case "weather":
+		return &WeatherConfig{}
+	case "wemo":
+		return &WemoConfig{}
+	case "xiaomi":
+		return &XiaomiConfig{}
+	case "yee":
+		return &YeeConfig{}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import "encoding/json"
+
+// NatsdConfigFromJSON parser the incoming JSON string and returns an Config instance for Natsd
+func NatsdConfigFromJSON(data []byte) (*NatsdConfig, error) {
+	r := &NatsdConfig{}
+	err := json.Unmarshal(data, r)
+	return r, err
+}
+
+// FromJSON converts a json string to a NatsdConfig instance
+func (r *NatsdConfig) FromJSON(data []byte) error {
+	c := NatsdConfig{}
+	err := json.Unmarshal(data, &c)
+	*r = c
+	return err
+}
+
+// ToJSON converts a NatsdConfig to a JSON string
+func (r *NatsdConfig) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err == nil {
+		return data, nil
+	}
+	return nil, err
+}
+
+// NatsdConfig holds the configuration of the embedded NATS server, it listens on 'listen'
+// (e.g. "0.0.0.0:4222") and the clients authenticate with 'token', with one of the
+// 'nkeys' (public user keys) or with the identities of the 'authorization' file that
+// busauth generated. The server uses TLS when 'tls_cert' and 'tls_key' are set and
+// JetStream is enabled when 'jetstream_dir' is set. Unlike the other configurations it
+// is read from a file since the server has to run before the configuration can be sent.
+type NatsdConfig struct {
+	Listen        string       `json:"listen"`
+	Token         *CryptString `json:"token,omitempty"`
+	NKeys         []string     `json:"nkeys,omitempty"`
+	Authorization string       `json:"authorization,omitempty"`
+	TLSCert       string       `json:"tls_cert,omitempty"`
+	TLSKey        string       `json:"tls_key,omitempty"`
+	JetStreamDir  string       `json:"jetstream_dir,omitempty"`
+}
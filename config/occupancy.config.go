@@ -0,0 +1,65 @@
+package config
+
+import "encoding/json"
+
+// OccupancyConfigFromJSON parser the incoming JSON string and returns an Config instance for Occupancy
+func OccupancyConfigFromJSON(data []byte) (*OccupancyConfig, error) {
+	r := &OccupancyConfig{}
+	err := json.Unmarshal(data, r)
+	return r, err
+}
+
+// FromJSON converts a json string to a OccupancyConfig instance
+func (r *OccupancyConfig) FromJSON(data []byte) error {
+	c := OccupancyConfig{}
+	err := json.Unmarshal(data, &c)
+	*r = c
+	return err
+}
+
+// ToJSON converts a OccupancyConfig to a JSON string
+func (r *OccupancyConfig) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err == nil {
+		return data, nil
+	}
+	return nil, err
+}
+
+// OccupancyConfig holds the configuration for the occupancy service, every area is a
+// bayesian sensor that is published on 'channel' as a SensorState of type "occupancy"
+// with a float attribute "probability" and a bool attribute "occupied".
+type OccupancyConfig struct {
+	SubChannels []string        `json:"subscribing_channels"`
+	Channel     string          `json:"channel"`
+	Home        OccupancyArea   `json:"home"`
+	Rooms       []OccupancyArea `json:"rooms"`
+}
+
+// OccupancyArea is a room (or the whole home) where 'prior' is the probability that it
+// is occupied when nothing is observed and 'threshold' the probability at which it is
+// considered occupied.
+type OccupancyArea struct {
+	Name         string                 `json:"name"`
+	Prior        float64                `json:"prior"`
+	Threshold    float64                `json:"threshold"`
+	Observations []OccupancyObservation `json:"observations"`
+}
+
+// OccupancyObservation is true when a SensorState received on 'topic' (optional) with
+// 'name' has attribute 'attribute' equal to 'value' (bool attributes compare to "true"
+// or "false"). Events like motion only report a moment, 'hold' (e.g. "10m") keeps the
+// observation true for that long after the last time it matched, 'half_life' (e.g. "5m")
+// makes it count for less the longer ago that was.
+// 'prob_given_true' is the probability of the observation when the area is occupied and
+// 'prob_given_false' the probability of the observation when it is not.
+type OccupancyObservation struct {
+	Topic          string  `json:"topic,omitempty"`
+	Name           string  `json:"name"`
+	Attribute      string  `json:"attribute"`
+	Value          string  `json:"value"`
+	Hold           string  `json:"hold,omitempty"`
+	HalfLife       string  `json:"half_life,omitempty"`
+	ProbGivenTrue  float64 `json:"prob_given_true"`
+	ProbGivenFalse float64 `json:"prob_given_false"`
+}
@@ -0,0 +1,44 @@
+package config
+
+import "encoding/json"
+
+// PermissionsConfigFromJSON parser the incoming JSON string and returns an Config instance for Permissions
+func PermissionsConfigFromJSON(data []byte) (*PermissionsConfig, error) {
+	r := &PermissionsConfig{}
+	err := json.Unmarshal(data, r)
+	return r, err
+}
+
+// FromJSON converts a json string to a PermissionsConfig instance
+func (r *PermissionsConfig) FromJSON(data []byte) error {
+	c := PermissionsConfig{}
+	err := json.Unmarshal(data, &c)
+	*r = c
+	return err
+}
+
+// ToJSON converts a PermissionsConfig to a JSON string
+func (r *PermissionsConfig) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err == nil {
+		return data, nil
+	}
+	return nil, err
+}
+
+// PermissionsConfig lists the channels that every service may publish and subscribe to,
+// busauth generates the identities of the services and the authorization of the NATS
+// server from it. 'common' holds the channels of every service, a service may always
+// subscribe to its own configuration channel and answer requests.
+type PermissionsConfig struct {
+	Common   ServicePermissions   `json:"common"`
+	Services []ServicePermissions `json:"services"`
+}
+
+// ServicePermissions holds the channels of a service, they can hold wildcards
+// (e.g. "state/*/conbee/" or "state/>")
+type ServicePermissions struct {
+	Name      string   `json:"name"`
+	Publish   []string `json:"publish"`
+	Subscribe []string `json:"subscribe"`
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// The "transport" of a PubSub configuration is "nats", "mqtt" or "memory" (in-process)
+
+// PubSubLocal is a NATS server on this machine, e.g. the embedded server of natsd
+var PubSubLocal = map[string]string{
+	"transport": "nats",
+	"host":      "nats://127.0.0.1:4222",
+}
+
+// PubSubCfg is the message bus that the services use when they do not find one on the LAN
+var PubSubCfg = PubSubLocal
+
+func init() {
+	PubSubCfg = PubSubFromEnv(PubSubCfg)
+}
+
+// PubSubFromEnv returns the PubSub configuration that is selected by the environment
+// variable GO_HOME_PUBSUB, "local", "memory" or the URL of a server, and 'defaultcfg' when
+// it is not set. The transport follows from the scheme of the URL (mqtt:// is MQTT, any
+// other scheme like nats:// or tcp:// is NATS) unless GO_HOME_PUBSUB_TRANSPORT sets it.
+// GO_HOME_PUBSUB_CREDENTIALS (the directory with the identities of the services),
+// GO_HOME_PUBSUB_NKEY (a file with an NKey seed) or GO_HOME_PUBSUB_SECRET (a token)
+// replace the credentials and GO_HOME_PUBSUB_CA sets the certificate of the CA of the
+// server.
+func PubSubFromEnv(defaultcfg map[string]string) map[string]string {
+	cfg := defaultcfg
+	switch pubsub := os.Getenv("GO_HOME_PUBSUB"); {
+	case pubsub == "local":
+		cfg = PubSubLocal
+	case pubsub == "memory":
+		cfg = map[string]string{"transport": "memory"}
+	case strings.Contains(pubsub, "://"):
+		cfg = map[string]string{"transport": transportOf(pubsub), "host": pubsub}
+	}
+
+	credentials, nkey, secret := os.Getenv("GO_HOME_PUBSUB_CREDENTIALS"), os.Getenv("GO_HOME_PUBSUB_NKEY"), os.Getenv("GO_HOME_PUBSUB_SECRET")
+	ca, transport := os.Getenv("GO_HOME_PUBSUB_CA"), os.Getenv("GO_HOME_PUBSUB_TRANSPORT")
+	if credentials == "" && nkey == "" && secret == "" && ca == "" && transport == "" {
+		return cfg
+	}
+	withenv := map[string]string{}
+	for key, value := range cfg {
+		withenv[key] = value
+	}
+	if transport != "" {
+		withenv["transport"] = transport
+	}
+	if credentials != "" || nkey != "" || secret != "" {
+		for _, key := range []string{"credentials", "nkey", "user", "password", "secret"} {
+			delete(withenv, key)
+		}
+	}
+	if credentials != "" {
+		withenv["credentials"] = credentials
+	} else if nkey != "" {
+		withenv["nkey"] = nkey
+	} else if secret != "" {
+		withenv["secret"] = secret
+	}
+	if ca != "" {
+		withenv["tls_ca"] = ca
+	}
+	return withenv
+}
+
+// transportOf returns the transport of the URL of a server
+func transportOf(url string) string {
+	if strings.HasPrefix(url, "mqtt://") {
+		return "mqtt"
+	}
+	return "nats"
+}
+
+// PubSubWithSecret returns 'cfg' with the "pubsub" token of the secrets store when 'cfg'
+// has no credentials of its own
+func PubSubWithSecret(cfg map[string]string) map[string]string {
+	for _, key := range []string{"credentials", "nkey", "user", "secret"} {
+		if cfg[key] != "" {
+			return cfg
+		}
+	}
+	token, err := LookupSecret("pubsub")
+	if err != nil {
+		return cfg
+	}
+	withsecret := map[string]string{"secret": token}
+	for key, value := range cfg {
+		if key != "secret" {
+			withsecret[key] = value
+		}
+	}
+	return withsecret
+}
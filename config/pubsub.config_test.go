@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPubSubFromEnv(t *testing.T) {
+	defer os.Unsetenv("GO_HOME_PUBSUB")
+	defer os.Unsetenv("GO_HOME_PUBSUB_SECRET")
+	defer os.Unsetenv("GO_HOME_PUBSUB_NKEY")
+	defer os.Unsetenv("GO_HOME_PUBSUB_CREDENTIALS")
+	defer os.Unsetenv("GO_HOME_PUBSUB_CA")
+	defer os.Unsetenv("GO_HOME_PUBSUB_TRANSPORT")
+
+	home := map[string]string{"transport": "nats", "host": "nats://10.0.0.22:4222", "secret": "token"}
+	tests := []struct {
+		pubsub string
+		secret string
+		nkey   string
+		host   string
+	}{
+		{"", "", "", home["host"]},
+		{"local", "", "", "nats://127.0.0.1:4222"},
+		{"nats://10.0.0.5:4222", "s3cr3t", "", "nats://10.0.0.5:4222"},
+		{"local", "", "/etc/go-home/automation.nk", "nats://127.0.0.1:4222"},
+	}
+	for _, tt := range tests {
+		os.Setenv("GO_HOME_PUBSUB", tt.pubsub)
+		os.Setenv("GO_HOME_PUBSUB_SECRET", tt.secret)
+		os.Setenv("GO_HOME_PUBSUB_NKEY", tt.nkey)
+		cfg := PubSubFromEnv(home)
+		if cfg["host"] != tt.host || cfg["nkey"] != tt.nkey {
+			t.Errorf("PubSubFromEnv() with %s = %v", tt.pubsub, cfg)
+		}
+		if tt.secret != "" && cfg["secret"] != tt.secret || tt.nkey != "" && cfg["secret"] != "" {
+			t.Errorf("PubSubFromEnv() with %s has secret %s", tt.pubsub, cfg["secret"])
+		}
+	}
+	if PubSubLocal["nkey"] != "" || PubSubLocal["secret"] != "" {
+		t.Errorf("PubSubFromEnv() changed PubSubLocal")
+	}
+
+	os.Setenv("GO_HOME_PUBSUB", "")
+	os.Setenv("GO_HOME_PUBSUB_NKEY", "")
+	os.Setenv("GO_HOME_PUBSUB_CREDENTIALS", "/etc/go-home/credentials")
+	os.Setenv("GO_HOME_PUBSUB_CA", "/etc/go-home/ca.pem")
+	cfg := PubSubFromEnv(home)
+	if cfg["host"] != home["host"] || cfg["credentials"] != "/etc/go-home/credentials" || cfg["secret"] != "" || cfg["tls_ca"] != "/etc/go-home/ca.pem" {
+		t.Errorf("PubSubFromEnv() with credentials = %v", cfg)
+	}
+	os.Unsetenv("GO_HOME_PUBSUB_CREDENTIALS")
+	os.Unsetenv("GO_HOME_PUBSUB_CA")
+
+	transports := []struct {
+		pubsub    string
+		transport string
+		want      string
+	}{
+		{"mqtt://10.0.0.22:1883", "", "mqtt"},
+		{"nats://10.0.0.22:4222", "", "nats"},
+		{"tcp://10.0.0.22:4222", "", "nats"},
+		{"memory", "", "memory"},
+		{"tcp://10.0.0.22:1883", "mqtt", "mqtt"},
+		{"", "memory", "memory"},
+	}
+	for _, tt := range transports {
+		os.Setenv("GO_HOME_PUBSUB", tt.pubsub)
+		os.Setenv("GO_HOME_PUBSUB_TRANSPORT", tt.transport)
+		if cfg := PubSubFromEnv(home); cfg["transport"] != tt.want {
+			t.Errorf("PubSubFromEnv() with %s and transport %s = %v", tt.pubsub, tt.transport, cfg)
+		}
+	}
+	if home["transport"] != "nats" {
+		t.Errorf("PubSubFromEnv() changed the default configuration")
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import "encoding/json"
+
+// RecorderConfigFromJSON parser the incoming JSON string and returns an Config instance for Recorder
+func RecorderConfigFromJSON(data []byte) (*RecorderConfig, error) {
+	r := &RecorderConfig{}
+	err := json.Unmarshal(data, r)
+	return r, err
+}
+
+// FromJSON converts a json string to a RecorderConfig instance
+func (r *RecorderConfig) FromJSON(data []byte) error {
+	c := RecorderConfig{}
+	err := json.Unmarshal(data, &c)
+	*r = c
+	return err
+}
+
+// ToJSON converts a RecorderConfig to a JSON string
+func (r *RecorderConfig) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err == nil {
+		return data, nil
+	}
+	return nil, err
+}
+
+// RecorderConfig holds the configuration for the recorder service. It writes the messages
+// of the subscribed channels to compressed files in 'dir', a new file is started every
+// 'rotate_minutes' and only the last 'keep_files' files are kept.
+type RecorderConfig struct {
+	SubChannels   []string `json:"subscribing_channels"`
+	Dir           string   `json:"dir"`
+	RotateMinutes int      `json:"rotate_minutes"`
+	KeepFiles     int      `json:"keep_files"`
+}
@@ -0,0 +1,37 @@
+package config
+
+import "encoding/json"
+
+// RegistryConfigFromJSON parser the incoming JSON string and returns an Config instance for Registry
+func RegistryConfigFromJSON(data []byte) (*RegistryConfig, error) {
+	r := &RegistryConfig{}
+	err := json.Unmarshal(data, r)
+	return r, err
+}
+
+// FromJSON converts a json string to a RegistryConfig instance
+func (r *RegistryConfig) FromJSON(data []byte) error {
+	c := RegistryConfig{}
+	err := json.Unmarshal(data, &c)
+	*r = c
+	return err
+}
+
+// ToJSON converts a RegistryConfig to a JSON string
+func (r *RegistryConfig) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err == nil {
+		return data, nil
+	}
+	return nil, err
+}
+
+// RegistryConfig holds the configuration for the registry service. It answers the
+// status requests on 'channel', publishes the up/down transitions of the services on
+// 'transition_channel' and regards a service as down when it did not send a heartbeat
+// for 'timeout' seconds.
+type RegistryConfig struct {
+	Channel           string `json:"channel"`
+	TransitionChannel string `json:"transition_channel"`
+	Timeout           int    `json:"timeout"`
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// SecretsFile returns the file of the secrets store, GO_HOME_SECRETS or "secrets.json"
+func SecretsFile() string {
+	if filename := os.Getenv("GO_HOME_SECRETS"); filename != "" {
+		return filename
+	}
+	return "secrets.json"
+}
+
+// SecretsStore holds the secrets (tokens, passwords, API keys) by name, in the file they
+// are encrypted with GO_HOME_KEY.
+type SecretsStore struct {
+	mutex   sync.RWMutex
+	entries map[string]*CryptString
+}
+
+// NewSecretsStore returns an empty store
+func NewSecretsStore() *SecretsStore {
+	return &SecretsStore{entries: map[string]*CryptString{}}
+}
+
+// ReadSecrets reads a secrets store, a file that does not exist is an empty store
+func ReadSecrets(filename string) (*SecretsStore, error) {
+	s := NewSecretsStore()
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("secrets store %s: %v", filename, err)
+	}
+	return s, nil
+}
+
+// Write writes the store with every secret encrypted, only the owner can read the file
+func (s *SecretsStore) Write(filename string) error {
+	s.mutex.RLock()
+	data, err := json.MarshalIndent(s.entries, "", "    ")
+	s.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// Get returns the value of a secret
+func (s *SecretsStore) Get(name string) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if cs, exists := s.entries[name]; exists {
+		return cs.String, true
+	}
+	return "", false
+}
+
+// Set adds or replaces a secret
+func (s *SecretsStore) Set(name string, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[name] = &CryptString{String: value}
+}
+
+// Names returns the sorted names of the secrets
+func (s *SecretsStore) Names() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var secrets = NewSecretsStore()
+
+// LoadSecrets loads the secrets store that LookupSecret and Secret use, services do this
+// at start-up
+func LoadSecrets(filename string) error {
+	s, err := ReadSecrets(filename)
+	if err != nil {
+		return err
+	}
+	secrets = s
+	return nil
+}
+
+// LookupSecret returns the value of a secret of the loaded secrets store
+func LookupSecret(name string) (string, error) {
+	if value, exists := secrets.Get(name); exists {
+		return value, nil
+	}
+	return "", fmt.Errorf("secret %s is not in the secrets store", name)
+}
+
+// Secret is a value of the secrets store, in JSON it is the name of the secret so that the
+// value never ends up in a configuration file, the output of ToJSON or a log.
+type Secret struct {
+	Name string
+}
+
+// Value returns the value of the secret
+func (s Secret) Value() (string, error) {
+	return LookupSecret(s.Name)
+}
+
+// String returns the name of the secret, never its value
+func (s Secret) String() string {
+	return "secret:" + s.Name
+}
+
+// MarshalJSON marshals the name of the secret
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Name)
+}
+
+// UnmarshalJSON unmarshals the name of the secret
+func (s *Secret) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, &s.Name)
+}
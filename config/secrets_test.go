@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretsStore(t *testing.T) {
+	if err := SetCryptKey([]byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "secrets.json")
+	s, err := ReadSecrets(filename)
+	if err != nil || len(s.Names()) != 0 {
+		t.Fatalf("ReadSecrets() of a missing file = %v, %v", s.Names(), err)
+	}
+	s.Set("deconz.apikey", "0123456789")
+	s.Set("pubsub", "s3cr3t")
+	if err = s.Write(filename); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(filename)
+	if strings.Contains(string(data), "0123456789") || strings.Contains(string(data), "s3cr3t") {
+		t.Errorf("the secrets store is not encrypted: %s", data)
+	}
+
+	if err = LoadSecrets(filename); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { secrets = NewSecretsStore() }()
+	if names := fmt.Sprint(secrets.Names()); names != "[deconz.apikey pubsub]" {
+		t.Errorf("Names() = %s", names)
+	}
+	if value, err := LookupSecret("deconz.apikey"); err != nil || value != "0123456789" {
+		t.Errorf("LookupSecret() = %s, %v", value, err)
+	}
+	if _, err := LookupSecret("weather"); err == nil {
+		t.Errorf("LookupSecret() of a missing secret succeeded")
+	}
+
+	cfg := &ConbeeConfig{}
+	if err = cfg.FromJSON([]byte(`{"APIKey": "deconz.apikey"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := cfg.APIKey.Value(); err != nil || value != "0123456789" {
+		t.Errorf("APIKey.Value() = %s, %v", value, err)
+	}
+	data, _ = cfg.ToJSON()
+	if !strings.Contains(string(data), `"APIKey":"deconz.apikey"`) || strings.Contains(fmt.Sprintf("%v", cfg), "0123456789") {
+		t.Errorf("the secret shows up in %s or %v", data, cfg)
+	}
+
+	withsecret := PubSubWithSecret(PubSubLocal)
+	if withsecret["secret"] != "s3cr3t" || PubSubLocal["secret"] != "" {
+		t.Errorf("PubSubWithSecret() = %v", withsecret)
+	}
+	if own := PubSubWithSecret(map[string]string{"nkey": "automation.nk"}); own["secret"] != "" {
+		t.Errorf("PubSubWithSecret() replaced the credentials %v", own)
+	}
+}
+
+func TestCryptStringIsNotFormatted(t *testing.T) {
+	if err := SetCryptKey([]byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	cfg := ShoutConfig{Key: CryptString{String: "xoxb-token"}}
+	for _, s := range []string{fmt.Sprintf("%v", cfg), fmt.Sprintf("%+v", &cfg), fmt.Sprint(cfg.Key)} {
+		if strings.Contains(s, "xoxb-token") {
+			t.Errorf("the CryptString shows up in %s", s)
+		}
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil || strings.Contains(string(data), "xoxb-token") {
+		t.Errorf("json.Marshal() of a ShoutConfig value = %s, %v", data, err)
+	}
+}
@@ -219,7 +219,7 @@ func (s *SensorState) AddBoolAttr(name string, value bool) {
 }
 
 // AddIntAttr adds an IntAttr to SensorState
-func (s SensorState) AddIntAttr(name string, value int64) {
+func (s *SensorState) AddIntAttr(name string, value int64) {
 	if s.IntAttrs == nil {
 		s.IntAttrs = []IntAttr{{Name: name, Value: value}}
 	} else {
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ServiceOptions are the options in the configuration of any service that are handled by
+// the micro-service itself, 'debug' is the address of the debug HTTP server (e.g.
+// "127.0.0.1:6061"), the server is not started when it is empty.
+type ServiceOptions struct {
+	Debug string `json:"debug,omitempty"`
+}
+
+// ServiceOptionsFromJSON returns the service options of a configuration
+func ServiceOptionsFromJSON(data []byte) (*ServiceOptions, error) {
+	r := &ServiceOptions{}
+	err := json.Unmarshal(data, r)
+	return r, err
+}
+
+// WithServiceOptions adds the service options of the configuration file 'original' to
+// 'jsondata', the JSON of the configuration as it is send to the service.
+func WithServiceOptions(jsondata []byte, original []byte) ([]byte, error) {
+	options, err := ServiceOptionsFromJSON(original)
+	if err != nil || *options == (ServiceOptions{}) {
+		return jsondata, err
+	}
+	merged := map[string]json.RawMessage{}
+	if err = json.Unmarshal(jsondata, &merged); err != nil {
+		return nil, err
+	}
+	merged["debug"], _ = json.Marshal(options.Debug)
+	return json.Marshal(merged)
+}
+
+// Redacted is the value of a CryptString in a redacted configuration
+const Redacted = "<redacted>"
+
+// Redact returns the JSON of the configuration of service 'name' with the values of the
+// CryptString fields replaced by Redacted, the values are not decrypted.
+func Redact(name string, jsondata []byte) ([]byte, error) {
+	c := New(name)
+	if c == nil {
+		return nil, fmt.Errorf("configuration %s is unknown", name)
+	}
+	var value interface{}
+	if err := json.Unmarshal(jsondata, &value); err != nil {
+		return nil, err
+	}
+	value, _ = mapCryptStrings(reflect.TypeOf(c), value, func(interface{}) (interface{}, error) {
+		return Redacted, nil
+	})
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	err := encoder.Encode(value)
+	return buffer.Bytes(), err
+}
+
+// Reencrypt returns the JSON of the configuration of service 'name' with the values of the
+// CryptString fields encrypted again with the key that encrypts (see CryptKey), and the
+// number of values that were encrypted again. The rest of the JSON is kept as it is.
+func Reencrypt(name string, jsondata []byte) ([]byte, int, error) {
+	c := New(name)
+	if c == nil {
+		return nil, 0, fmt.Errorf("configuration %s is unknown", name)
+	}
+	var value interface{}
+	if err := json.Unmarshal(jsondata, &value); err != nil {
+		return nil, 0, err
+	}
+	replacements := map[string]string{}
+	_, err := mapCryptStrings(reflect.TypeOf(c), value, func(v interface{}) (interface{}, error) {
+		ciphertext, ok := v.(string)
+		if !ok || !NeedsRotation(ciphertext) {
+			return v, nil
+		}
+		plaintext, err := Decrypt(ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		if replacements[ciphertext], err = Encrypt(plaintext); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	// the ciphertexts are unique, replacing them keeps the layout of the file
+	for ciphertext, reencrypted := range replacements {
+		old, _ := json.Marshal(ciphertext)
+		rotated, _ := json.Marshal(reencrypted)
+		jsondata = bytes.Replace(jsondata, old, rotated, -1)
+	}
+	return jsondata, len(replacements), nil
+}
+
+var cryptStringType = reflect.TypeOf(CryptString{})
+
+// mapCryptStrings walks the decoded JSON 'value' together with the type 't' that it decodes
+// to and replaces the values of the CryptString fields by what 'fn' returns for them
+func mapCryptStrings(t reflect.Type, value interface{}, fn func(interface{}) (interface{}, error)) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == cryptStringType {
+		if value == nil {
+			return nil, nil
+		}
+		return fn(value)
+	}
+	var err error
+	switch t.Kind() {
+	case reflect.Struct:
+		if object, ok := value.(map[string]interface{}); ok {
+			err = mapCryptStringFields(t, object, fn)
+		}
+	case reflect.Slice, reflect.Array:
+		if array, ok := value.([]interface{}); ok {
+			for i := range array {
+				if array[i], err = mapCryptStrings(t.Elem(), array[i], fn); err != nil {
+					break
+				}
+			}
+		}
+	case reflect.Map:
+		if object, ok := value.(map[string]interface{}); ok {
+			for key := range object {
+				if object[key], err = mapCryptStrings(t.Elem(), object[key], fn); err != nil {
+					break
+				}
+			}
+		}
+	}
+	return value, err
+}
+
+func mapCryptStringFields(t reflect.Type, object map[string]interface{}, fn func(interface{}) (interface{}, error)) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != cryptStringType {
+				if err := mapCryptStringFields(ft, object, fn); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		for key := range object {
+			if strings.EqualFold(key, name) {
+				var err error
+				if object[key], err = mapCryptStrings(field.Type, object[key], fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
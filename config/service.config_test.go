@@ -0,0 +1,52 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	shout := `{"key": "9t_SOf9iDVGbY9Ey4mmNtLjGqMppBfqQ3Ha8vO60Mhl=", "channel": "#go-home"}`
+	data, err := Redact("shout", []byte(shout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "9t_SOf9") || !strings.Contains(string(data), `"key": "<redacted>"`) || !strings.Contains(string(data), "#go-home") {
+		t.Errorf("Redact(shout) = %s", data)
+	}
+
+	calendar := `{"calendars": [{"name": "Home", "url": "aHR0cHM6Ly9jYWxlbmRhcg=="}]}`
+	data, err = Redact("calendar", []byte(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "aHR0cHM6") || !strings.Contains(string(data), `"url": "<redacted>"`) {
+		t.Errorf("Redact(calendar) = %s", data)
+	}
+
+	if _, err = Redact("unknown", []byte(shout)); err == nil {
+		t.Errorf("Redact() of an unknown configuration should fail")
+	}
+}
+
+func TestWithServiceOptions(t *testing.T) {
+	data, err := WithServiceOptions([]byte(`{"channel":"#go-home"}`), []byte(`{"channel": "#go-home", "debug": "127.0.0.1:6061"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, err := ServiceOptionsFromJSON(data)
+	if err != nil || options.Debug != "127.0.0.1:6061" {
+		t.Errorf("WithServiceOptions() = %s, %v", data, err)
+	}
+	merged := map[string]string{}
+	json.Unmarshal(data, &merged)
+	if merged["channel"] != "#go-home" {
+		t.Errorf("WithServiceOptions() = %s, lost the configuration", data)
+	}
+
+	data, err = WithServiceOptions([]byte(`{"channel":"#go-home"}`), []byte(`{"channel": "#go-home"}`))
+	if err != nil || string(data) != `{"channel":"#go-home"}` {
+		t.Errorf("WithServiceOptions() without options = %s, %v", data, err)
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import "encoding/json"
+
+// StateCacheConfigFromJSON parser the incoming JSON string and returns an Config instance for StateCache
+func StateCacheConfigFromJSON(data []byte) (*StateCacheConfig, error) {
+	r := &StateCacheConfig{}
+	err := json.Unmarshal(data, r)
+	return r, err
+}
+
+// FromJSON converts a json string to a StateCacheConfig instance
+func (r *StateCacheConfig) FromJSON(data []byte) error {
+	c := StateCacheConfig{}
+	err := json.Unmarshal(data, &c)
+	*r = c
+	return err
+}
+
+// ToJSON converts a StateCacheConfig to a JSON string
+func (r *StateCacheConfig) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err == nil {
+		return data, nil
+	}
+	return nil, err
+}
+
+// StateCacheConfig holds the configuration for the statecache service. It retains the last
+// SensorState per topic and name of the subscribed channels, except for the channels in
+// 'exclude_channels' (e.g. device commands that should not be replayed), and answers the
+// requests on 'channel' with the retained states of a channel.
+type StateCacheConfig struct {
+	SubChannels     []string `json:"subscribing_channels"`
+	ExcludeChannels []string `json:"exclude_channels"`
+	Channel         string   `json:"channel"`
+}
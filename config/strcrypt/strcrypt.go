@@ -1,18 +1,130 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/jurgen-kluft/go-home/config"
 	"github.com/urfave/cli"
 )
 
+// secretValue returns the value argument, or the line read from stdin so that the secret
+// does not end up in the history of the shell
+func secretValue(c *cli.Context) (string, error) {
+	if c.NArg() > 1 {
+		return c.Args().Get(1), nil
+	}
+	fmt.Fprintf(os.Stderr, "value of %s: ", c.Args().First())
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// setSecret adds a secret to the store or replaces it, 'exists' tells which one is expected
+func setSecret(c *cli.Context, exists bool) error {
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("the name of the secret is missing")
+	}
+	filename := c.GlobalString("secrets")
+	store, err := config.ReadSecrets(filename)
+	if err != nil {
+		return err
+	}
+	if _, found := store.Get(name); found != exists {
+		if found {
+			return fmt.Errorf("secret %s already exists, use rotate to replace it", name)
+		}
+		return fmt.Errorf("secret %s does not exist, use add to add it", name)
+	}
+	value, err := secretValue(c)
+	if err != nil {
+		return err
+	}
+	store.Set(name, value)
+	return store.Write(filename)
+}
+
+// rotateAll encrypts every CryptString of the configuration files and every secret again
+// with GO_HOME_KEY, GO_HOME_OLD_KEYS holds the keys that they may be encrypted with now and
+// --cfb-key the key of the values of the first version
+func rotateAll(c *cli.Context) error {
+	if key := c.String("cfb-key"); key != "" {
+		if err := config.SetCFBKey([]byte(key)); err != nil {
+			return fmt.Errorf("--cfb-key: %v", err)
+		}
+	}
+	filenames, err := filepath.Glob(filepath.Join(c.String("configs"), "*.config.json"))
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		name := strings.TrimSuffix(filepath.Base(filename), ".config.json")
+		if config.New(name) == nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		data, n, err := config.Reencrypt(name, data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		if n == 0 {
+			continue
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(filename, data, info.Mode()); err != nil {
+			return err
+		}
+		fmt.Printf("%s: encrypted %d values again\n", filename, n)
+	}
+
+	filename := c.GlobalString("secrets")
+	store, err := config.ReadSecrets(filename)
+	if err != nil {
+		return err
+	}
+	if len(store.Names()) > 0 {
+		if err = store.Write(filename); err != nil {
+			return err
+		}
+		fmt.Printf("%s: encrypted %d secrets again\n", filename, len(store.Names()))
+	}
+	return nil
+}
+
+func listSecrets(c *cli.Context) error {
+	store, err := config.ReadSecrets(c.GlobalString("secrets"))
+	if err != nil {
+		return err
+	}
+	for _, name := range store.Names() {
+		fmt.Println(name)
+	}
+	return nil
+}
+
 func main() {
 	app := cli.NewApp()
-	app.Name = "Encrypt or decrypt a string"
+	app.Name = "Encrypt or decrypt a string, or manage the secrets store"
 	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "secrets",
+			Value: config.SecretsFile(),
+			Usage: "The secrets store",
+		},
 		&cli.BoolFlag{
 			Name:  "encrypt, e",
 			Usage: "The flag to indicate if we have to encrypt",
@@ -27,6 +139,43 @@ func main() {
 		},
 	}
 
+	app.Commands = []cli.Command{
+		{
+			Name:      "add",
+			Usage:     "Add a secret to the store, the value is read from stdin when it is not given",
+			ArgsUsage: "<name> [value]",
+			Action:    func(c *cli.Context) error { return setSecret(c, false) },
+		},
+		{
+			Name:      "rotate",
+			Usage:     "Replace the value of a secret, or without a name encrypt every secret and every value in the configuration files again with GO_HOME_KEY",
+			ArgsUsage: "[<name> [value]]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "configs",
+					Value: ".",
+					Usage: "The directory with the *.config.json files",
+				},
+				&cli.StringFlag{
+					Name:   "cfb-key",
+					EnvVar: "GO_HOME_CFB_KEY",
+					Usage:  "The key of the values of the first version (AES-CFB), required when GO_HOME_OLD_KEYS is set",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return rotateAll(c)
+				}
+				return setSecret(c, true)
+			},
+		},
+		{
+			Name:   "list",
+			Usage:  "List the names of the secrets, never their values",
+			Action: listSecrets,
+		},
+	}
+
 	app.Action = func(c *cli.Context) error {
 		str := ""
 		var err error
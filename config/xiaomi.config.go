@@ -23,12 +23,20 @@ const (
 	WirelessSwitchLongRelease = "long release"
 )
 
+// XiaomiConfig contains information to connect to a Xiaomi Aqara gateway, the reports
+// of motion and magnet sensors and plugs are published as SensorState on 'sensors.out',
+// the clicks of switches on 'switches.out' and the battery of the devices on 'health.out'.
+// Commands for the gateway light, plugs and wired switches are received on 'commands.in'.
 type XiaomiConfig struct {
-	Name   string      `json:"name"`
-	IP     string      `json:"ip"`
-	MAC    string      `json:"mac"`
-	Key    CryptString `json:"key"`
-	Motion []struct {
+	Name        string      `json:"name"`
+	IP          string      `json:"ip"`
+	MAC         string      `json:"mac"`
+	Key         CryptString `json:"key"`
+	SensorsOut  string      `json:"sensors.out"`
+	SwitchesOut string      `json:"switches.out"`
+	HealthOut   string      `json:"health.out"`
+	CommandsIn  string      `json:"commands.in"`
+	Motion      []struct {
 		Name  string `json:"name"`
 		ID    string `json:"id"`
 		BType string `json:"battery_type"`
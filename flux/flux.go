@@ -249,6 +249,7 @@ func main() {
 	subscribe := []string{"config/flux/", "state/sensor/weather/", "state/sensor/sun/", "state/sensor/season/"}
 
 	m := microservice.New("flux")
+	m.FetchRetained = true // start with the current sun, weather and season
 	m.RegisterAndSubscribe(register, subscribe)
 
 	c := new()
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+// formatMessage returns a message as one line, a SensorState is shown with its name, type
+// and attributes and any other payload as it is.
+func formatMessage(msg *pubsub.Msg, raw bool) string {
+	line := time.Now().Format("15:04:05") + " " + msg.Subject + " "
+	if !raw {
+		if state, err := config.SensorStateFromJSON(msg.Data); err == nil && state.Name != "" {
+			return line + formatState(state)
+		}
+	}
+	return line + string(msg.Data)
+}
+
+func formatState(state *config.SensorState) string {
+	attrs := []string{}
+	for _, a := range state.StringAttrs {
+		attrs = append(attrs, fmt.Sprintf("%s=%s", a.Name, a.Value))
+	}
+	for _, a := range state.BoolAttrs {
+		attrs = append(attrs, fmt.Sprintf("%s=%v", a.Name, a.Value))
+	}
+	for _, a := range state.IntAttrs {
+		attrs = append(attrs, fmt.Sprintf("%s=%d", a.Name, a.Value))
+	}
+	for _, a := range state.FloatAttrs {
+		attrs = append(attrs, fmt.Sprintf("%s=%.2f", a.Name, a.Value))
+	}
+	for _, a := range state.TimeWndAttrs {
+		attrs = append(attrs, fmt.Sprintf("%s=%s-%s", a.Name, a.Begin.Local().Format("15:04"), a.End.Local().Format("15:04")))
+	}
+	name := state.Name
+	if state.Type != "" {
+		name += " (" + state.Type + ")"
+	}
+	if len(attrs) == 0 {
+		return name
+	}
+	return name + " " + strings.Join(attrs, " ")
+}
+
+// formatText returns the TXT record of a zeroconf entry as key=value pairs
+func formatText(text map[string]string) string {
+	pairs := []string{}
+	for key, value := range text {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// diffJSON returns the differences between two decoded JSON values, one line per value
+// that was removed (-), added (+) or changed (~).
+func diffJSON(path string, a interface{}, b interface{}) []string {
+	ao, aobject := a.(map[string]interface{})
+	bo, bobject := b.(map[string]interface{})
+	if aobject && bobject {
+		keys := map[string]bool{}
+		for key := range ao {
+			keys[key] = true
+		}
+		for key := range bo {
+			keys[key] = true
+		}
+		sorted := []string{}
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		diff := []string{}
+		for _, key := range sorted {
+			av, ainside := ao[key]
+			bv, binside := bo[key]
+			switch {
+			case !binside:
+				diff = append(diff, fmt.Sprintf("- %s: %s", path+"."+key, compact(av)))
+			case !ainside:
+				diff = append(diff, fmt.Sprintf("+ %s: %s", path+"."+key, compact(bv)))
+			default:
+				diff = append(diff, diffJSON(path+"."+key, av, bv)...)
+			}
+		}
+		return diff
+	}
+
+	aa, aarray := a.([]interface{})
+	ba, barray := b.([]interface{})
+	if aarray && barray {
+		diff := []string{}
+		for i := 0; i < len(aa) || i < len(ba); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(ba):
+				diff = append(diff, fmt.Sprintf("- %s: %s", p, compact(aa[i])))
+			case i >= len(aa):
+				diff = append(diff, fmt.Sprintf("+ %s: %s", p, compact(ba[i])))
+			default:
+				diff = append(diff, diffJSON(p, aa[i], ba[i])...)
+			}
+		}
+		return diff
+	}
+
+	if compact(a) != compact(b) {
+		return []string{fmt.Sprintf("~ %s: %s -> %s", path, compact(a), compact(b))}
+	}
+	return nil
+}
+
+func compact(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+func TestFormatMessage(t *testing.T) {
+	msg := &pubsub.Msg{Subject: "state.light.conbee", Data: []byte(`{"name": "Kitchen", "type": "light", "stringattrs": [{"name": "power", "value": "on"}], "floatattrs": [{"name": "brightness", "value": 0.5}]}`)}
+	if line := formatMessage(msg, false); !strings.HasSuffix(line, " state.light.conbee Kitchen (light) power=on brightness=0.50") {
+		t.Errorf("formatMessage() = %s", line)
+	}
+	if line := formatMessage(msg, true); !strings.HasSuffix(line, " state.light.conbee "+string(msg.Data)) {
+		t.Errorf("formatMessage(raw) = %s", line)
+	}
+	msg = &pubsub.Msg{Subject: "shout.message", Data: []byte("Front door is open")}
+	if line := formatMessage(msg, false); !strings.HasSuffix(line, " shout.message Front door is open") {
+		t.Errorf("formatMessage() = %s", line)
+	}
+}
+
+func TestFormatText(t *testing.T) {
+	if text := formatText(map[string]string{"transport": "nats", "scheme": "nats"}); text != "scheme=nats transport=nats" {
+		t.Errorf("formatText() = %s", text)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	var a, b interface{}
+	json.Unmarshal([]byte(`{"channel": "#go-home", "key": "<redacted>", "rooms": ["kitchen", "hall"], "timeout": 35}`), &a)
+	json.Unmarshal([]byte(`{"channel": "#home", "key": "<redacted>", "rooms": ["kitchen"], "debug": "127.0.0.1:6061"}`), &b)
+	want := []string{
+		`~ .channel: "#go-home" -> "#home"`,
+		`+ .debug: "127.0.0.1:6061"`,
+		`- .rooms[1]: "hall"`,
+		`- .timeout: 35`,
+	}
+	if diff := diffJSON("", a, b); !reflect.DeepEqual(diff, want) {
+		t.Errorf("diffJSON() = %q, want %q", diff, want)
+	}
+	if diff := diffJSON("", a, a); len(diff) != 0 {
+		t.Errorf("diffJSON() of the same value = %q", diff)
+	}
+}
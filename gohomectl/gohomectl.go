@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+	"github.com/jurgen-kluft/go-home/recording"
+	"github.com/jurgen-kluft/go-home/zeroconf"
+	"github.com/urfave/cli"
+)
+
+// pubsubConfig returns the configuration of the message bus that is announced on the LAN
+// when zeroconf.Enabled, or the configured one.
+func pubsubConfig() map[string]string {
+	if err := config.LoadSecrets(config.SecretsFile()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	cfg := config.PubSubWithSecret(config.PubSubCfg)
+	if !zeroconf.Enabled() {
+		return cfg
+	}
+	cfg, _ = zeroconf.DiscoverBus(context.Background(), microservice.DiscoveryTimeout, cfg)
+	return cfg
+}
+
+// gohomectl talks to the message bus directly, it is not a service so it does not show
+// up in the registry.
+func connect(register []string, subscribe []string) (*pubsub.Context, error) {
+	ctx := pubsub.New(pubsubConfig())
+	if err := ctx.Connect("gohomectl", register, subscribe); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// received returns true for the messages that were published by the services
+func received(ctx *pubsub.Context, msg *pubsub.Msg) bool {
+	return msg != ctx.Tick && !strings.HasPrefix(msg.Subject, "client/")
+}
+
+func filters(c *cli.Context) []string {
+	if c.NArg() == 0 {
+		return []string{">"}
+	}
+	return c.Args()
+}
+
+func tail(c *cli.Context) error {
+	ctx, err := connect(nil, filters(c))
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	for {
+		select {
+		case msg := <-ctx.InMsgs:
+			if received(ctx, msg) {
+				fmt.Println(formatMessage(msg, c.Bool("raw")))
+			}
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func record(c *cli.Context) error {
+	file, err := os.Create(c.String("file"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	ctx, err := connect(nil, filters(c))
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	encoder := json.NewEncoder(file)
+	count := 0
+	fmt.Printf("recording to %s, press Ctrl-C to stop\n", c.String("file"))
+	for {
+		select {
+		case msg := <-ctx.InMsgs:
+			if !received(ctx, msg) {
+				break
+			}
+			if err := encoder.Encode(recording.NewRecord(msg, time.Now())); err != nil {
+				return err
+			}
+			count++
+		case <-stop:
+			fmt.Printf("recorded %d messages\n", count)
+			return nil
+		}
+	}
+}
+
+// parseTime parses the time of the --from and --to flags, RFC 3339 or local time
+func parseTime(value string, defaultvalue time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultvalue, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+}
+
+func replay(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("usage: gohomectl replay <file or directory>")
+	}
+	from, err := parseTime(c.String("from"), time.Time{})
+	if err != nil {
+		return err
+	}
+	to, err := parseTime(c.String("to"), time.Now())
+	if err != nil {
+		return err
+	}
+	records, err := recording.Read(c.Args().First(), from, to)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no messages were recorded between %s and %s", from, to)
+	}
+
+	transport, err := pubsub.NewTransport(pubsubConfig())
+	if err != nil {
+		return err
+	}
+	inmsgs := make(chan *pubsub.Msg, 128)
+	if err = transport.Connect("gohomectl", inmsgs); err != nil {
+		return err
+	}
+	defer transport.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	go func() {
+		<-stop
+		cancel()
+	}()
+	fmt.Printf("replaying %d messages from %s to %s\n", len(records), records[0].Time.Local().Format("2006-01-02 15:04:05"), records[len(records)-1].Time.Local().Format("2006-01-02 15:04:05"))
+	replayer := &recording.Replayer{Transport: transport, Prefix: c.String("prefix"), Speed: c.Float64("speed")}
+	return replayer.Replay(ctx, records)
+}
+
+func publish(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("usage: gohomectl publish <channel> <payload>")
+	}
+	channel := c.Args().Get(0)
+	ctx, err := connect([]string{channel}, nil)
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+	return ctx.PublishTTLStr(channel, c.Args().Get(1), c.Int("ttl"))
+}
+
+// request sends a request and returns the payload of the reply
+func request(channel string, payload []byte, timeout time.Duration) ([]byte, error) {
+	ctx, err := connect([]string{channel}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer ctx.Close()
+	data, err := ctx.Request(channel, payload, timeout)
+	if err == pubsub.ErrNoResponders {
+		return nil, fmt.Errorf("no service answers on %s", channel)
+	} else if err != nil {
+		return nil, err
+	}
+	reply := &microservice.Reply{}
+	if err = json.Unmarshal(data, reply); err != nil {
+		return nil, err
+	}
+	if !reply.Success {
+		return nil, fmt.Errorf("%s", reply.Error)
+	}
+	return reply.Payload, nil
+}
+
+func device(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("usage: gohomectl %s <name> <on|off|toggle>", c.Command.Name)
+	}
+	name, power := c.Args().Get(0), c.Args().Get(1)
+	state := config.NewSensorState(name, c.Command.Name)
+	state.AddStringAttr("power", power)
+	payload, err := state.ToJSON()
+	if err != nil {
+		return err
+	}
+	if _, err = request(c.String("channel"), payload, c.Duration("timeout")); err != nil {
+		return err
+	}
+	fmt.Printf("%s '%s' is %s\n", c.Command.Name, name, power)
+	return nil
+}
+
+// requestConfig asks the config service to send the configuration of service 'name'
+func requestConfig(name string, timeout time.Duration) ([]byte, error) {
+	ctx, err := connect([]string{"config/request/"}, []string{"config/" + name + "/"})
+	if err != nil {
+		return nil, err
+	}
+	defer ctx.Close()
+	if err = ctx.PublishStr("config/request/", name); err != nil {
+		return nil, err
+	}
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-ctx.InMsgs:
+			if received(ctx, msg) {
+				return msg.Data, nil
+			}
+		case <-deadline:
+			return nil, fmt.Errorf("the configuration of %s was not received", name)
+		}
+	}
+}
+
+func getConfig(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("usage: gohomectl config get <name>")
+	}
+	data, err := requestConfig(c.Args().First(), c.Duration("timeout"))
+	if err != nil {
+		return err
+	}
+	if !c.Bool("raw") {
+		if data, err = config.Redact(c.Args().First(), data); err != nil {
+			return err
+		}
+	}
+	fmt.Println(strings.TrimSpace(string(data)))
+	return nil
+}
+
+func diffConfig(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("usage: gohomectl config diff <name> <file>")
+	}
+	name := c.Args().Get(0)
+	filedata, err := ioutil.ReadFile(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	busdata, err := requestConfig(name, c.Duration("timeout"))
+	if err != nil {
+		return err
+	}
+
+	// encrypted values differ every time they are encrypted, so they are not compared
+	values := []interface{}{nil, nil}
+	for i, data := range [][]byte{busdata, filedata} {
+		if data, err = config.Redact(name, data); err != nil {
+			return err
+		}
+		if err = json.Unmarshal(data, &values[i]); err != nil {
+			return err
+		}
+	}
+	diff := diffJSON("", values[0], values[1])
+	if len(diff) == 0 {
+		fmt.Printf("the configuration of %s is the same as %s\n", name, c.Args().Get(1))
+	}
+	for _, line := range diff {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func services(c *cli.Context) error {
+	data, err := request(microservice.RegistryChannel, []byte(c.Args().First()), c.Duration("timeout"))
+	if err != nil {
+		return err
+	}
+	entries := []microservice.ServiceEntry{}
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tSINCE\tVERSION\tUPTIME\tCONFIG\tLAST ERROR")
+	for _, e := range entries {
+		status := "down"
+		if e.Up {
+			status = "up"
+		}
+		h := e.Heartbeat
+		if h == nil {
+			h = &microservice.Heartbeat{}
+		}
+		uptime := time.Duration(h.Uptime) * time.Second
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Name, status, e.Since.Local().Format("2006-01-02 15:04:05"), h.Version, uptime, h.ConfigRevision, h.LastError)
+	}
+	return w.Flush()
+}
+
+func discover(c *cli.Context) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tADDRESS\tTEXT")
+	for _, typ := range []string{zeroconf.BusType, zeroconf.InfluxType, zeroconf.ServiceType} {
+		for _, e := range zeroconf.Lookup(context.Background(), typ, c.Duration("timeout"), nil) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Name, e.Type, e.Address(), formatText(e.Text))
+		}
+	}
+	return w.Flush()
+}
+
+func main() {
+	timeout := cli.DurationFlag{
+		Name:  "timeout",
+		Value: 5 * time.Second,
+		Usage: "The time to wait for a reply",
+	}
+
+	app := cli.NewApp()
+	app.Name = "gohomectl"
+	app.Usage = "Inspect and drive the go-home message bus"
+	app.Commands = []cli.Command{
+		{
+			Name:      "tail",
+			Usage:     "Show the messages on the channels, e.g. 'state/light/>' or 'state/*/conbee/' (default all)",
+			ArgsUsage: "[channel...]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "raw", Usage: "Show the payload as it is"},
+			},
+			Action: tail,
+		},
+		{
+			Name:      "record",
+			Usage:     "Record the messages on the channels to a file, one JSON object per line",
+			ArgsUsage: "[channel...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "file, f", Value: "session.jsonl", Usage: "The file to record to"},
+			},
+			Action: record,
+		},
+		{
+			Name:      "replay",
+			Usage:     "Publish the recorded messages again, from the directory of the recorder or a recorded session",
+			ArgsUsage: "<file or directory>",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "from", Usage: "The time of the first message, e.g. '2019-03-04 02:55'"},
+				cli.StringFlag{Name: "to", Usage: "The time of the last message"},
+				cli.Float64Flag{Name: "speed", Value: 1, Usage: "The speed of the replay, 0 is as fast as possible"},
+				cli.StringFlag{Name: "prefix", Value: "replay.", Usage: "Put in front of the subjects, empty replays on the real subjects"},
+			},
+			Action: replay,
+		},
+		{
+			Name:      "publish",
+			Usage:     "Publish a message on a channel",
+			ArgsUsage: "<channel> <payload>",
+			Flags: []cli.Flag{
+				cli.IntFlag{Name: "ttl", Usage: "The number of seconds after which the message expires (0 is never)"},
+			},
+			Action: publish,
+		},
+		{
+			Name:      "light",
+			Usage:     "Turn a light on or off, e.g. gohomectl light \"Kitchen\" on",
+			ArgsUsage: "<name> <on|off|toggle>",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "channel", Value: "state/light/automation/", Usage: "The channel of the light commands"},
+				timeout,
+			},
+			Action: device,
+		},
+		{
+			Name:      "switch",
+			Usage:     "Turn a switch on or off",
+			ArgsUsage: "<name> <on|off|toggle>",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "channel", Value: "state/switch/automation/", Usage: "The channel of the switch commands"},
+				timeout,
+			},
+			Action: device,
+		},
+		{
+			Name:  "config",
+			Usage: "Request the configuration of a service",
+			Subcommands: []cli.Command{
+				{
+					Name:      "get",
+					Usage:     "Show the configuration that a service receives",
+					ArgsUsage: "<name>",
+					Flags: []cli.Flag{
+						cli.BoolFlag{Name: "raw", Usage: "Show the encrypted values instead of redacting them"},
+						timeout,
+					},
+					Action: getConfig,
+				},
+				{
+					Name:      "diff",
+					Usage:     "Compare the configuration that a service receives with a configuration file",
+					ArgsUsage: "<name> <file>",
+					Flags:     []cli.Flag{timeout},
+					Action:    diffConfig,
+				},
+			},
+		},
+		{
+			Name:      "services",
+			Usage:     "List the services that the registry knows",
+			ArgsUsage: "[name]",
+			Flags:     []cli.Flag{timeout},
+			Action:    services,
+		},
+		{
+			Name:   "discover",
+			Usage:  "List the message bus, InfluxDB and the services that are announced on the LAN",
+			Flags:  []cli.Flag{cli.DurationFlag{Name: "timeout", Value: 3 * time.Second, Usage: "The time to look for each type"}},
+			Action: discover,
+		},
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
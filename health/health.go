@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+// health publishes the battery level, reachability and last-seen of the sensors and
+// switches of conbee and xiaomi and shouts when a battery is low or a device is silent.
+type health struct {
+	config  *config.HealthConfig
+	monitor *monitor
+}
+
+func new() *health {
+	return &health{}
+}
+
+func (h *health) initialize(jsondata []byte) error {
+	cfg, err := config.HealthConfigFromJSON(jsondata)
+	if err != nil {
+		return err
+	}
+	monitor, err := newMonitor(cfg)
+	if err != nil {
+		return err
+	}
+	// keep what we know about the devices when the configuration is changed
+	if h.monitor != nil {
+		monitor.devices = h.monitor.devices
+	}
+	h.config = cfg
+	h.monitor = monitor
+	return nil
+}
+
+// update evaluates the health of the devices, shouts the alerts and publishes the health
+// of the devices that changed (or of all of them when 'all' is true).
+func (h *health) update(m *microservice.Service, now time.Time, all bool) {
+	for _, alert := range h.monitor.evaluate(now) {
+		m.Logger.LogInfo(m.Name, alert)
+		if h.config.Shout != "" {
+			m.Pubsub.PublishStr(h.config.Shout, alert)
+		}
+	}
+	for _, state := range h.monitor.states(all) {
+		jsonbytes, err := state.ToJSON()
+		if err == nil {
+			err = m.Pubsub.Publish(h.config.Channel, jsonbytes)
+		}
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		}
+	}
+}
+
+func main() {
+	health := new()
+
+	register := []string{"config/request/"}
+	subscribe := []string{"config/health/"}
+
+	m := microservice.New("health")
+	m.RegisterAndSubscribe(register, subscribe)
+
+	m.RegisterHandler("config/health/", func(m *microservice.Service, topic string, msg []byte) bool {
+		m.Logger.LogInfo(m.Name, "received configuration")
+		if err := health.initialize(msg); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		for _, channel := range []string{health.config.Channel, health.config.Shout} {
+			if err := m.Register(channel); err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+			}
+		}
+		for _, ss := range health.config.SubChannels {
+			if err := m.Subscribe(ss); err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+			}
+		}
+		return true
+	})
+
+	m.RegisterHandler("*", func(m *microservice.Service, topic string, msg []byte) bool {
+		if strings.HasPrefix(topic, "state") && health.config != nil {
+			state, err := config.SensorStateFromJSON(msg)
+			if err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+				return true
+			}
+			health.monitor.observe(topic, state, time.Now())
+		}
+		return true
+	})
+
+	tickCount := 0
+	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
+		if health.config == nil {
+			if tickCount%5 == 0 { // every 10 seconds
+				m.Pubsub.PublishStr("config/request/", m.Name)
+			}
+		} else if tickCount%30 == 0 {
+			// Every minute publish the devices that changed, every 10 minutes all of them
+			health.update(m, time.Now(), tickCount%300 == 0)
+		}
+		tickCount++
+		return true
+	})
+
+	m.Loop()
+}
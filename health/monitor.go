@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+)
+
+// batteryRecovered is how many percent a low battery has to rise above the low level
+// before it is no longer low, this prevents alerts when the reported level wobbles.
+const batteryRecovered = 5.0
+
+// device is the health of a sensor or switch as it is reported by conbee or xiaomi
+type device struct {
+	name        string
+	source      string // conbee or xiaomi
+	kind        string // motion, contact, switch
+	batteryType string
+	battery     float64 // percentage, -1 when not known
+	reachable   bool
+	lqi         int64 // -1 when not known
+	lastSeen    time.Time
+	batteryLow  bool
+	silent      bool
+	changed     bool
+}
+
+// monitor tracks the health of every device that is reported on the health channels
+// and also registers the last time a device was seen on any of the state channels.
+type monitor struct {
+	config    *config.HealthConfig
+	intervals map[string]time.Duration
+	batteries map[string]config.HealthBattery
+	devices   map[string]*device // source/name -> device
+}
+
+func newMonitor(cfg *config.HealthConfig) (*monitor, error) {
+	h := &monitor{config: cfg}
+	h.intervals = map[string]time.Duration{"default": 3 * time.Hour}
+	for kind, interval := range cfg.Intervals {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("interval of '%s' is invalid: %s", kind, err)
+		}
+		h.intervals[kind] = d
+	}
+	h.batteries = map[string]config.HealthBattery{}
+	for _, b := range cfg.Batteries {
+		if b.Full <= b.Empty {
+			return nil, fmt.Errorf("battery '%s' should have full > empty", b.Type)
+		}
+		h.batteries[strings.ToLower(b.Type)] = b
+	}
+	h.devices = map[string]*device{}
+	return h, nil
+}
+
+// interval returns how long a device of this kind can be silent
+func (h *monitor) interval(kind string) time.Duration {
+	if d, exists := h.intervals[kind]; exists {
+		return d
+	}
+	return h.intervals["default"]
+}
+
+// batteryPercentage returns the battery level as a percentage, values above 100 are a
+// voltage in mV (Aqara) and are converted using the voltage range of the battery type.
+func (h *monitor) batteryPercentage(batteryType string, value float64) float64 {
+	if value <= 100 {
+		return value
+	}
+	b, exists := h.batteries[strings.ToLower(batteryType)]
+	if !exists {
+		return -1
+	}
+	p := 100 * (value - b.Empty) / (b.Full - b.Empty)
+	if p < 0 {
+		return 0
+	} else if p > 100 {
+		return 100
+	}
+	return p
+}
+
+// sourceOfTopic returns the service that published on 'topic', e.g. "state/sensor/xiaomi/" -> "xiaomi"
+func sourceOfTopic(topic string) string {
+	parts := strings.Split(strings.Trim(topic, "/"), "/")
+	return parts[len(parts)-1]
+}
+
+// observe handles a sensor state, a state of type "health" creates or updates a device
+// and any other state of a known device only updates when it was last seen.
+func (h *monitor) observe(topic string, state *config.SensorState, now time.Time) {
+	source := state.GetValueAttr("source", sourceOfTopic(topic))
+	key := source + "/" + state.Name
+	d, exists := h.devices[key]
+	if state.Type != "health" {
+		if exists && now.After(d.lastSeen) {
+			d.lastSeen = now
+		}
+		return
+	}
+
+	if !exists {
+		d = &device{name: state.Name, source: source, battery: -1, lqi: -1, changed: true}
+		h.devices[key] = d
+	}
+	before := *d
+	d.kind = state.GetValueAttr("device", d.kind)
+	d.batteryType = state.GetValueAttr("battery_type", d.batteryType)
+	state.ExecFloatAttr("battery", func(value float64) {
+		d.battery = h.batteryPercentage(d.batteryType, value)
+	})
+	d.reachable = state.GetBoolAttr("reachable", true)
+	d.lqi = state.GetIntAttr("lqi", d.lqi)
+	if state.Time.After(d.lastSeen) {
+		d.lastSeen = state.Time
+	}
+	d.changed = d.changed || before.battery != d.battery || before.reachable != d.reachable || before.lqi != d.lqi
+}
+
+// evaluate updates the battery-low and silent status of all devices and returns the
+// alerts for the devices of which the status changed.
+func (h *monitor) evaluate(now time.Time) []string {
+	alerts := []string{}
+	for _, d := range h.sorted() {
+		if d.battery >= 0 {
+			low := d.batteryLow
+			if d.battery < h.config.BatteryLow {
+				low = true
+			} else if d.battery >= h.config.BatteryLow+batteryRecovered {
+				low = false
+			}
+			if low != d.batteryLow {
+				d.batteryLow = low
+				d.changed = true
+				if low {
+					alerts = append(alerts, fmt.Sprintf("battery of %s (%s, %s) is low: %.0f%%", d.name, d.source, d.batteryType, d.battery))
+				}
+			}
+		}
+
+		silence := now.Sub(d.lastSeen)
+		silent := silence > h.interval(d.kind)
+		if silent != d.silent {
+			d.silent = silent
+			d.changed = true
+			if silent {
+				alerts = append(alerts, fmt.Sprintf("%s (%s) has not been seen for %s", d.name, d.source, silence.Round(time.Minute)))
+			} else {
+				alerts = append(alerts, fmt.Sprintf("%s (%s) is seen again", d.name, d.source))
+			}
+		}
+	}
+	return alerts
+}
+
+// states returns the health of the devices, all of them when 'all' is true and otherwise
+// only the ones that changed.
+func (h *monitor) states(all bool) []*config.SensorState {
+	states := []*config.SensorState{}
+	for _, d := range h.sorted() {
+		if d.changed || all {
+			states = append(states, d.sensorState())
+			d.changed = false
+		}
+	}
+	return states
+}
+
+func (h *monitor) sorted() []*device {
+	devices := make([]*device, 0, len(h.devices))
+	for _, d := range h.devices {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].source != devices[j].source {
+			return devices[i].source < devices[j].source
+		}
+		return devices[i].name < devices[j].name
+	})
+	return devices
+}
+
+func (d *device) sensorState() *config.SensorState {
+	sensor := config.NewSensorState(d.name, "health")
+	sensor.Time = d.lastSeen
+	sensor.AddStringAttr("source", d.source)
+	sensor.AddStringAttr("device", d.kind)
+	if d.batteryType != "" {
+		sensor.AddStringAttr("battery_type", d.batteryType)
+	}
+	if d.battery >= 0 {
+		sensor.AddFloatAttr("battery", d.battery)
+	}
+	if d.lqi >= 0 {
+		sensor.AddIntAttr("lqi", d.lqi)
+	}
+	sensor.AddBoolAttr("reachable", d.reachable)
+	sensor.AddBoolAttr("battery_low", d.batteryLow)
+	sensor.AddBoolAttr("silent", d.silent)
+	return sensor
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+)
+
+const testConfig = `{
+	"channel": "state/health/devices/",
+	"shout": "shout/message/",
+	"battery_low": 15,
+	"intervals": {"motion": "2h", "default": "3h"},
+	"batteries": [{"type": "cr2450", "empty": 2800, "full": 3100}]
+}`
+
+func healthState(name string, source string, kind string, battery float64, seen time.Time) *config.SensorState {
+	state := config.NewSensorState(name, "health")
+	state.Time = seen
+	state.AddStringAttr("source", source)
+	state.AddStringAttr("device", kind)
+	state.AddStringAttr("battery_type", "cr2450")
+	state.AddFloatAttr("battery", battery)
+	state.AddBoolAttr("reachable", true)
+	return state
+}
+
+func TestMonitor(t *testing.T) {
+	h := new()
+	if err := h.initialize([]byte(testConfig)); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+
+	h.monitor.observe("state/health/conbee/", healthState("Kitchen Motion", "conbee", "motion", 80, now), now)
+	h.monitor.observe("state/health/xiaomi/", healthState("Kitchen Motion", "xiaomi", "motion", 2830, now.Add(-time.Hour)), now)
+	h.monitor.observe("state/health/conbee/", healthState("Bedroom Switch", "conbee", "switch", 12, now), now)
+
+	alerts := h.monitor.evaluate(now)
+	if len(alerts) != 2 || !strings.Contains(alerts[0], "Bedroom Switch") || !strings.Contains(alerts[1], "Kitchen Motion (xiaomi, cr2450) is low: 10%") {
+		t.Fatalf("evaluate() = %v", alerts)
+	}
+	if states := h.monitor.states(false); len(states) != 3 {
+		t.Fatalf("states() = %d states, want 3", len(states))
+	}
+	if states := h.monitor.states(false); len(states) != 0 {
+		t.Errorf("states() = %d states, want 0 when nothing changed", len(states))
+	}
+
+	// The xiaomi motion sensor was last seen an hour ago, a motion state keeps the conbee
+	// one alive but after 2h the xiaomi one is silent.
+	later := now.Add(90 * time.Minute)
+	motion := config.NewSensorState("Kitchen Motion", "motion")
+	h.monitor.observe("state/sensor/conbee/", motion, later)
+	alerts = h.monitor.evaluate(later)
+	if len(alerts) != 1 || alerts[0] != "Kitchen Motion (xiaomi) has not been seen for 2h30m0s" {
+		t.Fatalf("evaluate() = %v", alerts)
+	}
+	states := h.monitor.states(false)
+	if len(states) != 1 || states[0].GetValueAttr("source", "") != "xiaomi" || !states[0].GetBoolAttr("silent", false) {
+		t.Fatalf("states() = %v", states)
+	}
+
+	// A low battery is not reported again until it has recovered
+	h.monitor.observe("state/health/conbee/", healthState("Bedroom Switch", "conbee", "switch", 16, later), later)
+	h.monitor.observe("state/health/xiaomi/", healthState("Kitchen Motion", "xiaomi", "motion", 3100, later), later)
+	alerts = h.monitor.evaluate(later)
+	if len(alerts) != 1 || alerts[0] != "Kitchen Motion (xiaomi) is seen again" {
+		t.Fatalf("evaluate() = %v", alerts)
+	}
+	for _, state := range h.monitor.states(true) {
+		low := state.GetBoolAttr("battery_low", false)
+		if want := state.Name == "Bedroom Switch"; low != want {
+			t.Errorf("%s (%s) battery_low = %v, want %v", state.Name, state.GetValueAttr("source", ""), low, want)
+		}
+	}
+}
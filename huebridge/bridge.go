@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jurgen-kluft/go-home/config"
+)
+
+// light is an emulated device as it is presented by the bridge
+type light struct {
+	device config.HueBridgeEmulatedDevice
+	on     bool
+	bri    int
+}
+
+// bridge serves the part of the Hue v1 REST api that voice assistants use, every
+// emulated device is a dimmable light and switching it calls 'switched'.
+type bridge struct {
+	mutex    sync.Mutex
+	ipport   string
+	serial   string // 12 hex digits, used as bridge id and in the UPnP uuid
+	lights   []*light
+	switched func(device config.HueBridgeEmulatedDevice, on bool)
+}
+
+func newBridge(cfg *config.HueBridgeConfig, switched func(device config.HueBridgeEmulatedDevice, on bool)) *bridge {
+	h := fnv.New64a()
+	h.Write([]byte(cfg.IPPort))
+	b := &bridge{ipport: cfg.IPPort, serial: fmt.Sprintf("%012x", h.Sum64()&0xffffffffffff), switched: switched}
+	for _, d := range cfg.EmulatedDevices {
+		b.lights = append(b.lights, &light{device: d, bri: 254})
+	}
+	return b
+}
+
+// uuid is the UPnP uuid of the bridge, the prefix is the one that Hue bridges use
+func (b *bridge) uuid() string {
+	return "2f402f80-da50-11e1-9b23-" + b.serial
+}
+
+// bridgeID is the id of the bridge as it is reported in SSDP and the config
+func (b *bridge) bridgeID() string {
+	return strings.ToUpper(b.serial[:6] + "fffe" + b.serial[6:])
+}
+
+const descriptionXML = `<?xml version="1.0" encoding="UTF-8" ?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<URLBase>http://%s/</URLBase>
+<device>
+<deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>
+<friendlyName>go-home (%s)</friendlyName>
+<manufacturer>Royal Philips Electronics</manufacturer>
+<manufacturerURL>http://www.philips.com</manufacturerURL>
+<modelDescription>Philips hue Personal Wireless Lighting</modelDescription>
+<modelName>Philips hue bridge 2012</modelName>
+<modelNumber>929000226503</modelNumber>
+<modelURL>http://www.meethue.com</modelURL>
+<serialNumber>%s</serialNumber>
+<UDN>uuid:%s</UDN>
+<presentationURL>index.html</presentationURL>
+</device>
+</root>
+`
+
+// lightJSON returns a light as the Hue api presents it
+func (b *bridge) lightJSON(id int, l *light) map[string]interface{} {
+	return map[string]interface{}{
+		"state": map[string]interface{}{
+			"on":        l.on,
+			"bri":       l.bri,
+			"alert":     "none",
+			"mode":      "homeautomation",
+			"reachable": true,
+		},
+		"type":             "Dimmable light",
+		"name":             l.device.Name,
+		"modelid":          "LWB014",
+		"manufacturername": "Philips",
+		"productname":      "Hue white lamp",
+		"uniqueid":         fmt.Sprintf("00:17:88:01:%02x:%02x:%02x:%02x-0b", (id>>24)&0xff, (id>>16)&0xff, (id>>8)&0xff, id&0xff),
+		"swversion":        "1.46.13_r26312",
+	}
+}
+
+func (b *bridge) lightsJSON() map[string]interface{} {
+	lights := map[string]interface{}{}
+	for i, l := range b.lights {
+		lights[strconv.Itoa(i+1)] = b.lightJSON(i+1, l)
+	}
+	return lights
+}
+
+func (b *bridge) configJSON() map[string]interface{} {
+	host := b.ipport
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	return map[string]interface{}{
+		"name":             "go-home",
+		"bridgeid":         b.bridgeID(),
+		"mac":              strings.Join([]string{b.serial[0:2], b.serial[2:4], b.serial[4:6], b.serial[6:8], b.serial[8:10], b.serial[10:12]}, ":"),
+		"ipaddress":        host,
+		"modelid":          "BSB002",
+		"swversion":        "1935144040",
+		"apiversion":       "1.35.0",
+		"linkbutton":       true,
+		"portalservices":   false,
+		"factorynew":       false,
+		"replacesbridgeid": nil,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func hueError(kind int, address string, description string) []interface{} {
+	return []interface{}{map[string]interface{}{"error": map[string]interface{}{"type": kind, "address": address, "description": description}}}
+}
+
+func hueSuccess(key string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{"success": map[string]interface{}{key: value}}
+}
+
+func (b *bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "description.xml" {
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, descriptionXML, b.ipport, b.ipport, b.serial, b.uuid())
+		return
+	}
+	parts := strings.Split(path, "/")
+	if parts[0] != "api" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+
+	// Pairing, every user is accepted
+	if len(parts) == 1 {
+		if r.Method != "POST" {
+			writeJSON(w, http.StatusOK, hueError(4, "/", "method, "+r.Method+", not available for resource, /"))
+			return
+		}
+		writeJSON(w, http.StatusOK, []interface{}{hueSuccess("username", "go-home-"+b.serial)})
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	resource := "/" + strings.Join(parts[2:], "/")
+	switch {
+	case len(parts) == 2 && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"lights": b.lightsJSON(), "groups": map[string]interface{}{}, "config": b.configJSON()})
+	case len(parts) == 3 && parts[2] == "config" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, b.configJSON())
+	case len(parts) == 3 && parts[2] == "lights" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, b.lightsJSON())
+	case len(parts) == 3 && parts[2] == "groups" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	case len(parts) >= 4 && parts[2] == "lights":
+		id, err := strconv.Atoi(parts[3])
+		if err != nil || id < 1 || id > len(b.lights) {
+			writeJSON(w, http.StatusOK, hueError(3, resource, "resource, "+resource+", not available"))
+			return
+		}
+		l := b.lights[id-1]
+		if len(parts) == 4 && r.Method == "GET" {
+			writeJSON(w, http.StatusOK, b.lightJSON(id, l))
+		} else if len(parts) == 5 && parts[4] == "state" && r.Method == "PUT" {
+			b.setState(w, id, l, body)
+		} else {
+			writeJSON(w, http.StatusOK, hueError(4, resource, "method, "+r.Method+", not available for resource, "+resource))
+		}
+	default:
+		writeJSON(w, http.StatusOK, hueError(3, resource, "resource, "+resource+", not available"))
+	}
+}
+
+// setState handles a PUT of the state of a light, 'on' switches the device and 'bri'
+// is remembered (a bri of 0 switches the device off)
+func (b *bridge) setState(w http.ResponseWriter, id int, l *light, body []byte) {
+	var state struct {
+		On  *bool `json:"on"`
+		Bri *int  `json:"bri"`
+	}
+	if err := json.Unmarshal(body, &state); err != nil {
+		writeJSON(w, http.StatusOK, hueError(2, fmt.Sprintf("/lights/%d/state", id), "body contains invalid json"))
+		return
+	}
+	on := l.on
+	result := []interface{}{}
+	if state.Bri != nil {
+		l.bri = *state.Bri
+		if l.bri > 254 {
+			l.bri = 254
+		} else if l.bri < 0 {
+			l.bri = 0
+		}
+		on = l.bri > 0
+		result = append(result, hueSuccess(fmt.Sprintf("/lights/%d/state/bri", id), l.bri))
+	}
+	if state.On != nil {
+		on = *state.On
+		result = append(result, hueSuccess(fmt.Sprintf("/lights/%d/state/on", id), on))
+	}
+	l.on = on
+	if (state.On != nil || state.Bri != nil) && b.switched != nil {
+		b.switched(l.device, on)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
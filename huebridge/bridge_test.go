@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+)
+
+const testConfig = `{
+	"ip_port": "127.0.0.1:5000",
+	"emulated-devices": [
+		{"name": "Frontdoor light", "channel": "state/vars/", "on": "frontdoor on", "off": "frontdoor off"},
+		{"name": "Story Time", "channel": "state/vars/", "on": "story on", "off": "story off"}
+	]
+}`
+
+func newTestBridge(t *testing.T) (*bridge, *[]command) {
+	cfg, err := config.HueBridgeConfigFromJSON([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := &[]command{}
+	b := newBridge(cfg, func(device config.HueBridgeEmulatedDevice, on bool) {
+		*commands = append(*commands, commandOf(device, on))
+	})
+	return b, commands
+}
+
+func request(t *testing.T, method string, url string, body string, v interface{}) {
+	r, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if err = json.Unmarshal(data, v); err != nil {
+		t.Fatalf("%s %s: %s (%s)", method, url, err, data)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	b, commands := newTestBridge(t)
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	var paired []map[string]map[string]string
+	request(t, "POST", server.URL+"/api", `{"devicetype": "Echo"}`, &paired)
+	user := paired[0]["success"]["username"]
+	if user == "" {
+		t.Fatalf("POST /api = %v", paired)
+	}
+
+	var lights map[string]struct {
+		Name  string
+		State struct{ On bool }
+	}
+	request(t, "GET", server.URL+"/api/"+user+"/lights", "", &lights)
+	if len(lights) != 2 || lights["1"].Name != "Frontdoor light" || lights["2"].Name != "Story Time" {
+		t.Fatalf("GET lights = %v", lights)
+	}
+
+	var result []map[string]map[string]interface{}
+	request(t, "PUT", server.URL+"/api/"+user+"/lights/2/state", `{"on": true}`, &result)
+	if len(result) != 1 || result[0]["success"]["/lights/2/state/on"] != true {
+		t.Fatalf("PUT state = %v", result)
+	}
+	request(t, "PUT", server.URL+"/api/"+user+"/lights/1/state", `{"bri": 0}`, &result)
+	if len(*commands) != 2 || (*commands)[0] != (command{"state/vars/", "story on"}) || (*commands)[1] != (command{"state/vars/", "frontdoor off"}) {
+		t.Fatalf("commands = %v", *commands)
+	}
+
+	request(t, "GET", server.URL+"/api/"+user+"/lights", "", &lights)
+	if !lights["2"].State.On || lights["1"].State.On {
+		t.Errorf("GET lights after PUT = %v", lights)
+	}
+
+	var errors []map[string]map[string]interface{}
+	request(t, "PUT", server.URL+"/api/"+user+"/lights/9/state", `{"on": true}`, &errors)
+	if errors[0]["error"]["type"] != 3.0 {
+		t.Errorf("PUT unknown light = %v", errors)
+	}
+
+	resp, err := http.Get(server.URL + "/description.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	description, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(description), "<UDN>uuid:"+b.uuid()+"</UDN>") {
+		t.Errorf("description.xml = %s", description)
+	}
+}
+
+func TestSSDP(t *testing.T) {
+	b, _ := newTestBridge(t)
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go (&ssdpResponder{bridge: b, failed: func(err error) { t.Error(err) }}).serve(conn)
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	search := "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\nST: urn:schemas-upnp-org:device:basic:1\r\n\r\n"
+	if _, err = client.WriteTo([]byte(search), conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 2048)
+	n, _, err := client.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := string(buffer[:n])
+	if !strings.HasPrefix(reply, "HTTP/1.1 200 OK") || !strings.Contains(reply, "LOCATION: http://127.0.0.1:5000/description.xml") || !strings.Contains(reply, "IpBridge") {
+		t.Errorf("M-SEARCH reply = %s", reply)
+	}
+
+	// Other searches are not answered
+	other := strings.Replace(search, "urn:schemas-upnp-org:device:basic:1", "urn:dial-multiscreen-org:service:dial:1", 1)
+	if r := (&ssdpResponder{bridge: b}).response([]byte(other)); r != nil {
+		t.Errorf("M-SEARCH for dial was answered with %s", r)
+	}
+}
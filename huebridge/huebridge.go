@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+// huebridge emulates a Philips Hue bridge so that voice assistants (e.g. an Echo) on the
+// LAN can switch go-home devices, switching an emulated light publishes the on or off
+// payload of the device on its channel.
+type huebridge struct {
+	config *config.HueBridgeConfig
+	bridge *bridge
+	server *http.Server
+	ssdp   net.PacketConn
+}
+
+// command is the publish of a payload on a channel, it is passed from the HTTP handlers
+// onto the process messages channel of the micro-service.
+type command struct {
+	Channel string `json:"channel"`
+	Payload string `json:"payload"`
+}
+
+func commandOf(device config.HueBridgeEmulatedDevice, on bool) command {
+	if on {
+		return command{Channel: device.Channel, Payload: device.On}
+	}
+	return command{Channel: device.Channel, Payload: device.Off}
+}
+
+// start serves the Hue api on 'ip_port' and answers SSDP discovery, note that newer
+// Echo devices only find bridges that listen on port 80. When one of them cannot be
+// started the other one is stopped again.
+func (h *huebridge) start(m *microservice.Service) error {
+	h.bridge = newBridge(h.config, func(device config.HueBridgeEmulatedDevice, on bool) {
+		jsondata, err := json.Marshal(commandOf(device, on))
+		if err == nil {
+			m.ProcessMessages <- &microservice.Message{Topic: "huebridge/command/", Payload: jsondata}
+		}
+	})
+
+	listener, err := net.Listen("tcp", h.config.IPPort)
+	if err != nil {
+		return err
+	}
+	h.server = &http.Server{Handler: h.bridge}
+	go h.server.Serve(listener)
+
+	h.ssdp, err = listenSSDP()
+	if err != nil {
+		h.stop()
+		return fmt.Errorf("SSDP discovery: %v", err)
+	}
+	failed := func(err error) {
+		m.Logger.LogError(m.Name, "SSDP discovery: "+err.Error())
+	}
+	responder := &ssdpResponder{bridge: h.bridge, failed: failed}
+	go func(conn net.PacketConn) {
+		if err := responder.serve(conn); !errors.Is(err, net.ErrClosed) {
+			failed(err)
+		}
+	}(h.ssdp)
+	return nil
+}
+
+func (h *huebridge) stop() {
+	if h.server != nil {
+		h.server.Close()
+		h.server = nil
+	}
+	if h.ssdp != nil {
+		h.ssdp.Close()
+		h.ssdp = nil
+	}
+}
+
+func main() {
+	huebridge := &huebridge{}
+
+	register := []string{"config/request/"}
+	subscribe := []string{"config/huebridge/"}
+
+	m := microservice.New("huebridge")
+	m.RegisterAndSubscribe(register, subscribe)
+
+	m.RegisterHandler("config/huebridge/", func(m *microservice.Service, topic string, msg []byte) bool {
+		m.Logger.LogInfo(m.Name, "received configuration")
+		cfg, err := config.HueBridgeConfigFromJSON(msg)
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		for _, channel := range cfg.RegisterChannels {
+			m.Register(channel)
+		}
+		for _, device := range cfg.EmulatedDevices {
+			m.Register(device.Channel)
+		}
+
+		huebridge.stop()
+		huebridge.config = cfg
+		if err = huebridge.start(m); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		} else {
+			m.Logger.LogInfo(m.Name, fmt.Sprintf("emulating a Hue bridge with %d lights on %s", len(cfg.EmulatedDevices), cfg.IPPort))
+		}
+		return true
+	})
+
+	m.RegisterHandler("huebridge/command/", func(m *microservice.Service, topic string, msg []byte) bool {
+		cmd := command{}
+		if err := json.Unmarshal(msg, &cmd); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		if err := m.Pubsub.PublishStr(cmd.Channel, cmd.Payload); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		}
+		return true
+	})
+
+	tickCount := 0
+	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
+		if tickCount%5 == 0 {
+			if huebridge.config == nil {
+				m.Pubsub.PublishStr("config/request/", m.Name)
+			}
+		}
+		tickCount++
+		return true
+	})
+
+	m.Loop()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ssdpAddr is the multicast address on which UPnP devices are discovered
+const ssdpAddr = "239.255.255.250:1900"
+
+// ssdpResponder answers the SSDP M-SEARCH requests of voice assistants with the
+// location of the description of the bridge, a reply that cannot be send is passed
+// to 'failed'.
+type ssdpResponder struct {
+	bridge *bridge
+	failed func(err error)
+}
+
+// response returns the reply on an SSDP packet or nil when it is not an M-SEARCH that
+// the bridge should answer.
+func (s *ssdpResponder) response(packet []byte) []byte {
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(packet)))
+	if err != nil || request.Method != "M-SEARCH" || request.Header.Get("Man") != `"ssdp:discover"` {
+		return nil
+	}
+	st := request.Header.Get("St")
+	switch {
+	case st == "ssdp:all", st == "upnp:rootdevice":
+		st = "upnp:rootdevice"
+	case strings.EqualFold(st, "urn:schemas-upnp-org:device:basic:1"), st == "uuid:"+s.bridge.uuid():
+	default:
+		return nil
+	}
+	usn := "uuid:" + s.bridge.uuid()
+	if st != usn {
+		usn += "::" + st
+	}
+	return []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+		"CACHE-CONTROL: max-age=100\r\n"+
+		"EXT:\r\n"+
+		"LOCATION: http://%s/description.xml\r\n"+
+		"SERVER: Linux/3.14.0 UPnP/1.0 IpBridge/1.35.0\r\n"+
+		"hue-bridgeid: %s\r\n"+
+		"ST: %s\r\n"+
+		"USN: %s\r\n"+
+		"\r\n", s.bridge.ipport, s.bridge.bridgeID(), st, usn))
+}
+
+// serve answers the requests received on 'conn' until it is closed
+func (s *ssdpResponder) serve(conn net.PacketConn) error {
+	buffer := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+		if reply := s.response(buffer[:n]); reply != nil {
+			// the reply is send by unicast to the address of the requester
+			reply := reply
+			go func() {
+				if _, err := conn.WriteTo(reply, addr); err != nil && s.failed != nil {
+					s.failed(err)
+				}
+			}()
+		}
+	}
+}
+
+// listenSSDP joins the SSDP multicast group on all interfaces
+func listenSSDP() (net.PacketConn, error) {
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenMulticastUDP("udp4", nil, addr)
+}
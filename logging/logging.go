@@ -1,13 +1,17 @@
 package logging
 
 import (
+	"sync"
+
 	"github.com/sirupsen/logrus"
 )
 
 type Logger struct {
-	log     *logrus.Logger
-	process string
-	context map[string]*logrus.Entry
+	log       *logrus.Logger
+	process   string
+	context   map[string]*logrus.Entry
+	mutex     sync.Mutex
+	lastError string
 }
 
 func New(process string) *Logger {
@@ -33,4 +37,14 @@ func (log *Logger) LogInfo(context string, line string) {
 func (log *Logger) LogError(context string, line string) {
 	entry := log.context[context]
 	entry.Error(line)
+	log.mutex.Lock()
+	log.lastError = line
+	log.mutex.Unlock()
+}
+
+// LastError returns the last line that was logged as an error
+func (log *Logger) LastError() string {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	return log.lastError
 }
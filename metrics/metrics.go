@@ -1,11 +1,13 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/influxdata/influxdb1-client/v2"
 	"github.com/jurgen-kluft/go-home/config"
+	"github.com/jurgen-kluft/go-home/zeroconf"
 )
 
 // Metrics library, mainly for:
@@ -20,6 +22,7 @@ import (
 
 type Metrics struct {
 	client  client.Client
+	secrets map[string]string
 	metrics map[string]*Metric
 }
 
@@ -30,14 +33,23 @@ type Metric struct {
 	bp     client.BatchPoints
 }
 
+// discoveryTimeout is how long New looks for InfluxDB on the LAN
+const discoveryTimeout = 3 * time.Second
+
+// New connects to the InfluxDB of config.InfluxSecrets, or to the one that is announced on the
+// LAN when zeroconf.Enabled
 func New() (*Metrics, error) {
 	var err error
 	metrics := &Metrics{}
 	metrics.metrics = map[string]*Metric{}
+	metrics.secrets = config.InfluxSecrets
+	if zeroconf.Enabled() {
+		metrics.secrets, _ = zeroconf.DiscoverInflux(context.Background(), discoveryTimeout, config.InfluxSecrets)
+	}
 
 	// Create a new HTTPClient
 	metrics.client, err = client.NewHTTPClient(client.HTTPConfig{
-		Addr: config.InfluxSecrets["host"],
+		Addr: metrics.secrets["host"],
 		//Username: config.InfluxSecrets["username"],
 		//Password: config.InfluxSecrets["password"],
 	})
@@ -67,7 +79,7 @@ func (m *Metrics) Begin(name string) (metric *Metric, err error) {
 
 	// Create a new point batch
 	metric.bp, err = client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  config.InfluxSecrets["database"],
+		Database:  m.secrets["database"],
 		Precision: "s",
 	})
 	return metric, err
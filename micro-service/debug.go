@@ -0,0 +1,249 @@
+package microservice
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+// debugMessages is the number of received and sent messages that are kept per topic
+const debugMessages = 20
+
+type debugMessage struct {
+	Time    time.Time `json:"time"`
+	Payload string    `json:"payload"`
+}
+
+type debugTopic struct {
+	Received []debugMessage `json:"received,omitempty"`
+	Sent     []debugMessage `json:"sent,omitempty"`
+}
+
+// debugServer is the HTTP server that shows what a service is doing, it is started when
+// the configuration of the service has a 'debug' address.
+type debugServer struct {
+	enabled    atomic.Bool
+	mutex      sync.Mutex
+	topics     map[string]*debugTopic
+	configName string
+	config     []byte
+	address    string
+	server     *http.Server
+}
+
+func newDebugServer() *debugServer {
+	return &debugServer{topics: map[string]*debugTopic{}}
+}
+
+func appendMessage(messages []debugMessage, payload []byte) []debugMessage {
+	messages = append(messages, debugMessage{Time: time.Now(), Payload: string(payload)})
+	if len(messages) > debugMessages {
+		messages = messages[len(messages)-debugMessages:]
+	}
+	return messages
+}
+
+func (d *debugServer) topic(subject string) *debugTopic {
+	t, exists := d.topics[subject]
+	if !exists {
+		t = &debugTopic{}
+		d.topics[subject] = t
+	}
+	return t
+}
+
+func (d *debugServer) received(msg *pubsub.Msg) {
+	if d.enabled.Load() {
+		d.mutex.Lock()
+		t := d.topic(msg.Subject)
+		t.Received = appendMessage(t.Received, msg.Data)
+		d.mutex.Unlock()
+	}
+}
+
+func (d *debugServer) sent(msg *pubsub.Msg) {
+	if d.enabled.Load() {
+		d.mutex.Lock()
+		t := d.topic(msg.Subject)
+		t.Sent = appendMessage(t.Sent, msg.Data)
+		d.mutex.Unlock()
+	}
+}
+
+// configure keeps the configuration of the service and starts, moves or stops the
+// server according to its 'debug' address.
+func (m *Service) configureDebug(topic string, payload []byte) {
+	options, err := config.ServiceOptionsFromJSON(payload)
+	if err != nil {
+		return
+	}
+	d := m.debug
+	d.mutex.Lock()
+	d.configName = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(topic, "config/"), "config."), "/")
+	d.config = payload
+	address := d.address
+	d.mutex.Unlock()
+	if options.Debug == address {
+		return
+	}
+
+	d.stop()
+	if options.Debug == "" {
+		m.Logger.LogInfo(m.Name, "debug server stopped")
+		return
+	}
+	listener, err := net.Listen("tcp", options.Debug)
+	if err != nil {
+		m.Logger.LogError(m.Name, "debug server not started, "+err.Error())
+		return
+	}
+	d.mutex.Lock()
+	d.address = options.Debug
+	d.server = &http.Server{Handler: m.debugHandler()}
+	server := d.server
+	d.mutex.Unlock()
+	d.enabled.Store(true)
+	go server.Serve(listener)
+	m.Logger.LogInfo(m.Name, "debug server listening on "+listener.Addr().String())
+}
+
+func (d *debugServer) stop() {
+	d.enabled.Store(false)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.server != nil {
+		d.server.Close()
+		d.server = nil
+	}
+	d.address = ""
+	d.topics = map[string]*debugTopic{}
+}
+
+// inspectLoop calls 'f' on the goroutine of the loop so that it can read the handlers
+// and subscriptions, it returns false when the loop did not get to it in time.
+func (m *Service) inspectLoop(f func()) bool {
+	done := make(chan struct{})
+	select {
+	case m.inspect <- func() { f(); close(done) }:
+	case <-time.After(time.Second):
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+// topics returns the topics of a handler map without the NATS subjects that are
+// registered next to them.
+func topics(handlers map[string]bool) []string {
+	list := []string{}
+	for topic := range handlers {
+		if strings.Contains(topic, "/") || topic == "*" {
+			list = append(list, topic)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	jsondata, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsondata)
+}
+
+func (m *Service) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(m.Name + "\n\n/handlers\n/subscriptions\n/messages\n/config\n/debug/pprof/\n"))
+	})
+
+	mux.HandleFunc("/handlers", func(w http.ResponseWriter, r *http.Request) {
+		list := map[string][]string{}
+		if !m.inspectLoop(func() {
+			handlers, replyhandlers, concurrent := map[string]bool{}, map[string]bool{}, map[string]bool{}
+			for topic := range m.Handlers {
+				handlers[topic] = true
+			}
+			for topic := range m.ReplyHandlers {
+				replyhandlers[topic] = true
+			}
+			for topic := range m.Concurrent {
+				concurrent[topic] = true
+			}
+			list["handlers"] = topics(handlers)
+			list["reply_handlers"] = topics(replyhandlers)
+			list["concurrent"] = topics(concurrent)
+		}) {
+			http.Error(w, "the service is busy", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, list)
+	})
+
+	mux.HandleFunc("/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		list := map[string][]string{}
+		if !m.inspectLoop(func() {
+			list["registered"] = append([]string{}, m.PubsubRegister...)
+			list["subscribed"] = append([]string{}, m.PubsubSubscribe...)
+			if m.Pubsub != nil {
+				list["subjects"] = append([]string{}, m.Pubsub.SubChannels...)
+			}
+		}) {
+			http.Error(w, "the service is busy", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, list)
+	})
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		m.debug.mutex.Lock()
+		topics := map[string]debugTopic{}
+		for subject, t := range m.debug.topics {
+			topics[subject] = debugTopic{Received: append([]debugMessage{}, t.Received...), Sent: append([]debugMessage{}, t.Sent...)}
+		}
+		m.debug.mutex.Unlock()
+		writeJSON(w, topics)
+	})
+
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		m.debug.mutex.Lock()
+		name, payload := m.debug.configName, m.debug.config
+		m.debug.mutex.Unlock()
+		redacted, err := config.Redact(name, payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(redacted)
+	})
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
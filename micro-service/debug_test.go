@@ -0,0 +1,62 @@
+package microservice
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+func TestDebugServer(t *testing.T) {
+	memory := map[string]string{"transport": "memory"}
+	m := New("shout")
+	m.PubsubConfig = memory
+	m.RegisterAndSubscribe([]string{"shout/sent/"}, []string{"config/shout/"})
+	m.RegisterHandler("config/shout/", func(m *Service, topic string, message []byte) bool {
+		m.Pubsub.PublishStr("shout/sent/", "service connected")
+		return true
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	client := pubsub.New(memory)
+	if err := client.Connect("client", []string{"config/shout/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; !m.debug.enabled.Load() && i < 100; i++ {
+		client.PublishStr("config/shout/", `{"key": "9t_SOf9iDVGbY9Ey4mmNtLjGqMpp", "channel": "#go-home", "debug": "127.0.0.1:0"}`)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !m.debug.enabled.Load() {
+		t.Fatal("the debug server was not started")
+	}
+	client.PublishStr("config/shout/", `{"key": "9t_SOf9iDVGbY9Ey4mmNtLjGqMpp", "channel": "#go-home", "debug": "127.0.0.1:0"}`)
+	time.Sleep(10 * time.Millisecond)
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		m.debugHandler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != 200 {
+			t.Errorf("GET %s = %d, %s", path, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+	if body := get("/handlers"); !strings.Contains(body, `"config/shout/"`) || strings.Contains(body, `"config.shout"`) {
+		t.Errorf("/handlers = %s", body)
+	}
+	if body := get("/subscriptions"); !strings.Contains(body, `"config.shout"`) || !strings.Contains(body, `"shout/sent/"`) {
+		t.Errorf("/subscriptions = %s", body)
+	}
+	if body := get("/messages"); !strings.Contains(body, `"service connected"`) || !strings.Contains(body, "#go-home") {
+		t.Errorf("/messages = %s", body)
+	}
+	if body := get("/config"); strings.Contains(body, "9t_SOf9") || !strings.Contains(body, "<redacted>") {
+		t.Errorf("/config = %s", body)
+	}
+	get("/debug/pprof/")
+}
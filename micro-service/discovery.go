@@ -0,0 +1,55 @@
+package microservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/zeroconf"
+)
+
+// DiscoveryTimeout is how long a service looks for the message bus on the LAN before it
+// connects to the bus of its PubsubConfig
+const DiscoveryTimeout = 3 * time.Second
+
+// onLAN tells if the message bus of 'cfg' is reachable on the LAN, an in-process bus is not
+func onLAN(cfg map[string]string) bool {
+	return cfg["transport"] != "memory"
+}
+
+// discoverBus returns the PubSub configuration of the message bus that was announced on the
+// LAN, with the credentials of PubsubConfig, or PubsubConfig when none was found.
+func (m *Service) discoverBus(ctx context.Context) map[string]string {
+	if !m.Discover || !onLAN(m.PubsubConfig) {
+		return m.PubsubConfig
+	}
+	if !zeroconf.CanDiscoverBus(m.PubsubConfig) {
+		m.Logger.LogInfo("pubsub", "not looking for a message bus on the LAN, its credentials need a tls_ca, using "+m.PubsubConfig["host"])
+		return m.PubsubConfig
+	}
+	cfg, found := zeroconf.DiscoverBus(ctx, DiscoveryTimeout, m.PubsubConfig)
+	if found {
+		m.Logger.LogInfo("pubsub", "found the message bus on "+cfg["host"])
+	} else {
+		m.Logger.LogInfo("pubsub", "did not find a message bus on the LAN, using "+cfg["host"])
+	}
+	return cfg
+}
+
+// announce announces the service and its Announcements on the LAN until the context is done
+func (m *Service) announce(ctx context.Context) {
+	if !m.Announce || !onLAN(m.PubsubConfig) {
+		return
+	}
+	self := zeroconf.Entry{
+		Name: m.Name,
+		Type: zeroconf.ServiceType,
+		Port: zeroconf.NoPort,
+		Text: map[string]string{"version": m.Version},
+	}
+	entries := append([]zeroconf.Entry{self}, m.Announcements...)
+	go func() {
+		if err := zeroconf.Announce(ctx, entries...); err != nil {
+			m.Logger.LogError(m.Name, "announce: "+err.Error())
+		}
+	}()
+}
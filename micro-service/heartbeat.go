@@ -0,0 +1,110 @@
+package microservice
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"runtime/debug"
+	"time"
+)
+
+// HeartbeatChannel is the channel on which every service publishes its Heartbeat
+const HeartbeatChannel = "service/heartbeat/"
+
+// HeartbeatInterval is the time between the heartbeats of a service
+const HeartbeatInterval = 10 * time.Second
+
+// Heartbeat tells that a service is alive, Uptime is in seconds and ConfigRevision
+// identifies the configuration that the service received last.
+type Heartbeat struct {
+	Name           string    `json:"name"`
+	Version        string    `json:"version"`
+	Started        time.Time `json:"started"`
+	Uptime         int64     `json:"uptime"`
+	ConfigRevision string    `json:"config_revision,omitempty"`
+	Subscriptions  []string  `json:"subscriptions"`
+	LastError      string    `json:"last_error,omitempty"`
+	Time           time.Time `json:"time"`
+}
+
+// RegistryChannel is the channel on which the registry service answers the requests
+// for the status of the services, a request with a name only returns that service.
+const RegistryChannel = "registry/status/"
+
+// TransitionChannel is the channel on which the registry service publishes a Status
+// when a service comes "up" or goes "down".
+const TransitionChannel = "registry/transition/"
+
+// ServiceEntry is a service as it is known by the registry service, Since is the time
+// of the last transition.
+type ServiceEntry struct {
+	Name      string     `json:"name"`
+	Up        bool       `json:"up"`
+	Since     time.Time  `json:"since"`
+	LastSeen  time.Time  `json:"last_seen"`
+	Heartbeat *Heartbeat `json:"heartbeat,omitempty"`
+}
+
+// buildVersion returns the VCS revision that the service was build from
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		if info.Main.Version != "" {
+			return info.Main.Version
+		}
+		return "dev"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// isConfig returns true when 'topic' is the configuration channel of the service, e.g.
+// 'config/shout/' or 'config.shout'.
+func (m *Service) isConfig(topic string) bool {
+	return topic == "config/"+m.Name+"/" || topic == "config."+m.Name
+}
+
+// revisionOf returns a short hash of a configuration
+func revisionOf(config []byte) string {
+	hash := sha1.Sum(config)
+	return hex.EncodeToString(hash[:4])
+}
+
+func (m *Service) publishHeartbeat() {
+	now := time.Now()
+	m.lastHeartbeat = now
+	heartbeat := &Heartbeat{
+		Name:           m.Name,
+		Version:        m.Version,
+		Started:        m.Started,
+		Uptime:         int64(now.Sub(m.Started) / time.Second),
+		ConfigRevision: m.ConfigRevision,
+		Subscriptions:  m.PubsubSubscribe,
+		LastError:      m.Logger.LastError(),
+		Time:           now,
+	}
+	jsondata, err := json.Marshal(heartbeat)
+	if err == nil {
+		err = m.Pubsub.Publish(HeartbeatChannel, jsondata)
+	}
+	if err != nil {
+		m.Logger.LogError("pubsub", err.Error())
+	}
+}
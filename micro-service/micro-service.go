@@ -1,32 +1,98 @@
 package microservice
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jurgen-kluft/go-home/config"
 	logpkg "github.com/jurgen-kluft/go-home/logging"
 	pubsub "github.com/jurgen-kluft/go-home/nats"
+	"github.com/jurgen-kluft/go-home/zeroconf"
 )
 
 // Delegate is a handler that the user can register on a certain received topic
 type Delegate func(m *Service, topic string, message []byte) bool
 
+// ReplyDelegate is a handler that answers the requests received on a certain topic,
+// it is also called for messages on that topic that are published without a request.
+type ReplyDelegate func(m *Service, topic string, request []byte) *Reply
+
 type Message struct {
 	Topic   string
 	Payload []byte
 }
 
+// Reply is the structured result that is send back on a request
+type Reply struct {
+	Success bool            `json:"success"`
+	Error   string          `json:"error,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Succeeded returns a successful reply with an optional payload
+func Succeeded(payload []byte) *Reply {
+	return &Reply{Success: true, Payload: payload}
+}
+
+// Failed returns the reply of a request that could not be handled
+func Failed(err error) *Reply {
+	return &Reply{Success: false, Error: err.Error()}
+}
+
+// RetainedState is a state as it is retained by the statecache service, Topic is the
+// subject that it was published on and Expires is set when it was published with a TTL.
+type RetainedState struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+	Expires *time.Time      `json:"expires,omitempty"`
+}
+
+// StateCacheChannel is the channel on which the statecache service answers the requests
+// for the retained states of a channel.
+const StateCacheChannel = "statecache/get/"
+
+// Response is the payload of the message that RequestAsync puts onto the process
+// messages channel, Tag is passed unchanged so that the handler can identify the request.
+type Response struct {
+	Topic   string `json:"topic"`
+	Request []byte `json:"request"`
+	Tag     string `json:"tag"`
+	Reply   Reply  `json:"reply"`
+}
+
 // Service is a convenience setup to implement a micro-service
 type Service struct {
 	Name            string
 	Logger          *logpkg.Logger
 	PubsubRegister  []string
 	PubsubSubscribe []string
+	PubsubConfig    map[string]string // selects the transport, see pubsub.NewTransport
+	Discover        bool              // look for the message bus on the LAN first, see zeroconf
+	Announce        bool              // announce the service on the LAN
+	Announcements   []zeroconf.Entry  // announced together with the service
+	FetchRetained   bool              // fetch the retained states of subscribed 'state/' channels
 	Pubsub          *pubsub.Context
 	Handlers        map[string]Delegate
+	ReplyHandlers   map[string]ReplyDelegate
 	CatchHandler    Delegate
 	ProcessMessages chan *Message
+	MessageExpires  time.Time // the expiry of the received message that is handled, zero when it does not expire
+	Concurrent      map[string]HandlerOptions
+	Workers         int // the number of handlers that can run concurrently
+	Version         string
+	Started         time.Time
+	ConfigRevision  string // the hash of the last received configuration
+	pool            *workerPool
+	stop            chan bool
+	lastHeartbeat   time.Time
+	debug           *debugServer
+	inspect         chan func()
 }
 
 func New(name string) *Service {
@@ -39,13 +105,33 @@ func New(name string) *Service {
 
 	service.PubsubRegister = make([]string, 0, 10)
 	service.PubsubSubscribe = make([]string, 0, 10)
+	if err := config.LoadSecrets(config.SecretsFile()); err != nil {
+		service.Logger.LogError(name, err.Error())
+	}
+	service.PubsubConfig = config.PubSubWithSecret(config.PubSubCfg)
+	service.Discover = zeroconf.Enabled()
+	service.Announce = true
 	service.Handlers = make(map[string]Delegate)
+	service.ReplyHandlers = make(map[string]ReplyDelegate)
+	service.Concurrent = make(map[string]HandlerOptions)
+	service.Workers = 4
+	service.Version = buildVersion()
+	service.Started = time.Now()
 
 	service.ProcessMessages = make(chan *Message, 128)
+	service.stop = make(chan bool, 1)
+	service.debug = newDebugServer()
+	service.inspect = make(chan func())
 	return service
 }
 
+// Register registers a channel to publish on, registering a channel again does nothing
 func (m *Service) Register(r string) error {
+	for _, s := range m.PubsubRegister {
+		if s == r {
+			return nil
+		}
+	}
 	if m.Pubsub == nil {
 		// Not connected yet, just add it to the list
 		m.PubsubRegister = append(m.PubsubRegister, r)
@@ -57,18 +143,58 @@ func (m *Service) Register(r string) error {
 	return nil
 }
 
+// Subscribe subscribes to a channel, subscribing to a channel again does nothing
 func (m *Service) Subscribe(r string) error {
+	for _, s := range m.PubsubSubscribe {
+		if s == r {
+			return nil
+		}
+	}
 	if m.Pubsub == nil {
 		// Not connected yet, just add it to the list
 		m.PubsubSubscribe = append(m.PubsubSubscribe, r)
 	} else {
 		// We are connected, also call Subscribe on pubsub
 		m.PubsubSubscribe = append(m.PubsubSubscribe, r)
-		m.Pubsub.Subscribe(r)
+		if err := m.Pubsub.Subscribe(r); err != nil {
+			return err
+		}
+		m.fetchRetained(r)
 	}
 	return nil
 }
 
+// fetchRetained requests the retained states of a subscribed channel from the statecache
+// service, they are handled as if they were just published.
+func (m *Service) fetchRetained(channel string) {
+	if !m.FetchRetained || !strings.HasPrefix(channel, "state/") {
+		return
+	}
+	ctx := m.Pubsub
+	ctx.Register(StateCacheChannel)
+	go func() {
+		reply, err := request(ctx, StateCacheChannel, []byte(channel), 5*time.Second)
+		if err != nil {
+			if err != pubsub.ErrNoResponders {
+				m.Logger.LogError("pubsub", "fetching the retained states of "+channel+" failed, "+err.Error())
+			}
+			return
+		}
+		states := []RetainedState{}
+		if err = json.Unmarshal(reply.Payload, &states); err != nil {
+			m.Logger.LogError("pubsub", err.Error())
+			return
+		}
+		for _, state := range states {
+			msg := &pubsub.Msg{Subject: state.Topic, Data: state.Payload}
+			if state.Expires != nil {
+				msg.Expires = *state.Expires
+			}
+			ctx.InMsgs <- msg
+		}
+	}()
+}
+
 func (m *Service) RegisterAndSubscribe(register []string, subscribe []string) {
 	for _, r := range register {
 		m.Register(r)
@@ -78,11 +204,29 @@ func (m *Service) RegisterAndSubscribe(register []string, subscribe []string) {
 	}
 }
 
+// NormalizeTopic returns the NATS subject of a topic, it makes 'state/sensor/conbee/' and
+// 'state.sensor.conbee' comparable
+func NormalizeTopic(topic string) string {
+	topic = strings.Replace(topic, "/", ".", -1)
+	return strings.TrimSuffix(topic, ".")
+}
+
 func (m *Service) RegisterHandler(topic string, delegate Delegate) {
 	m.Handlers[topic] = delegate
-	natstopic := strings.Replace(topic, "/", ".", -1)
-	natstopic = strings.TrimSuffix(natstopic, ".")
-	m.Handlers[natstopic] = delegate
+	m.Handlers[NormalizeTopic(topic)] = delegate
+}
+
+// RegisterReplyHandler registers a handler that answers the requests on 'topic'
+func (m *Service) RegisterReplyHandler(topic string, delegate ReplyDelegate) {
+	m.ReplyHandlers[topic] = delegate
+	m.ReplyHandlers[NormalizeTopic(topic)] = delegate
+}
+
+// RunConcurrently runs the handlers of 'topic' on the worker pool, a slow handler then
+// only delays the messages with the same key. MessageExpires is not set for them.
+func (m *Service) RunConcurrently(topic string, options HandlerOptions) {
+	m.Concurrent[topic] = options
+	m.Concurrent[NormalizeTopic(topic)] = options
 }
 
 func matchTopic(etopic string, itopic string) bool {
@@ -103,7 +247,8 @@ func matchTopic(etopic string, itopic string) bool {
 				ii++
 			}
 			if ii == len(itopic) {
-				return false
+				// a NATS subject has no trailing separator, 'state.light.kitchen' matches 'state/light/*/'
+				return ei == len(etopic)-1 && (etopic[ei] == '/' || etopic[ei] == '.')
 			}
 			cs = false
 		} else if echar != ichar {
@@ -126,60 +271,385 @@ func (m *Service) FindHandler(itopic string) (delegate Delegate, exists bool) {
 	return nil, false
 }
 
+// FindReplyHandler returns the reply handler for a topic, an exact match is preferred
+// over a match with wildcards.
+func (m *Service) FindReplyHandler(itopic string) (delegate ReplyDelegate, exists bool) {
+	if delegate, exists = m.ReplyHandlers[itopic]; exists {
+		return delegate, true
+	}
+	for etopic, edelegate := range m.ReplyHandlers {
+		if matchTopic(etopic, itopic) {
+			return edelegate, true
+		}
+	}
+	return nil, false
+}
+
+// findOptions returns the options of a topic that runs concurrently
+func (m *Service) findOptions(itopic string) (options HandlerOptions, exists bool) {
+	if options, exists = m.Concurrent[itopic]; exists {
+		return options, true
+	}
+	for etopic, eoptions := range m.Concurrent {
+		if matchTopic(etopic, itopic) {
+			return eoptions, true
+		}
+	}
+	return HandlerOptions{}, false
+}
+
+// submit runs a handler on the worker pool, when the handler returns false the service
+// is stopped.
+func (m *Service) submit(topic string, message []byte, options HandlerOptions, handler func() bool) {
+	if m.pool == nil {
+		m.pool = newWorkerPool(m.Workers)
+	}
+	key := ""
+	if options.Key != nil {
+		key = options.Key(topic, message)
+	}
+	m.pool.submit(key, func(release func()) {
+		running := true
+		withTimeout(options.Timeout, func() { running = handler() }, func() {
+			m.Logger.LogError(m.Name, "handler of "+topic+" is still running after "+options.Timeout.String()+", its worker is given back")
+			release()
+		})
+		if !running {
+			select {
+			case m.stop <- true:
+			default:
+			}
+		}
+	})
+}
+
+// handleRequest calls the handler of a request and returns the reply as JSON, a request
+// on a topic that only has a normal handler is acknowledged when that handler returns.
+// A request that is only seen by the "*" handler (e.g. of the statecache or the recorder)
+// is not answered, the reply is nil, since it is meant for another service.
+// The returned bool is false when the handler wants the service to quit.
+func (m *Service) handleRequest(topic string, payload []byte) ([]byte, bool) {
+	var reply *Reply
+	running := true
+	if replydelegate, exists := m.FindReplyHandler(topic); exists {
+		reply = replydelegate(m, topic, payload)
+	} else {
+		delegate, exists := m.Handlers[topic]
+		if !exists {
+			delegate, exists = m.FindHandler(topic)
+		}
+		if !exists {
+			if delegate, exists = m.Handlers["*"]; exists {
+				return nil, delegate(m, topic, payload)
+			}
+		}
+		if exists {
+			running = delegate(m, topic, payload)
+			reply = Succeeded(nil)
+		} else {
+			reply = Failed(errors.New("no handler for " + topic + " in " + m.Name))
+		}
+	}
+	if reply == nil {
+		reply = Succeeded(nil)
+	}
+	jsondata, err := json.Marshal(reply)
+	if err != nil {
+		jsondata, _ = json.Marshal(Failed(err))
+	}
+	return jsondata, running
+}
+
+// Request sends a request on a registered topic and blocks until the reply is received
+// or 'timeout' has passed, a reply that is not a success is also returned as an error.
+// Handlers should use RequestAsync since the service does not process messages while
+// it is waiting.
+func (m *Service) Request(topic string, payload []byte, timeout time.Duration) (*Reply, error) {
+	return request(m.Pubsub, topic, payload, timeout)
+}
+
+// RequestAsync sends a request without waiting for the reply, the reply is put onto the
+// process messages channel as a Response on 'responseTopic'. A failure to deliver the
+// request (e.g. a timeout) is returned as a reply that is not a success.
+func (m *Service) RequestAsync(topic string, payload []byte, timeout time.Duration, responseTopic string, tag string) {
+	ctx := m.Pubsub
+	go func() {
+		response := &Response{Topic: topic, Request: payload, Tag: tag}
+		reply, err := request(ctx, topic, payload, timeout)
+		if reply != nil {
+			response.Reply = *reply
+		} else {
+			response.Reply = *Failed(err)
+		}
+		jsondata, err := json.Marshal(response)
+		if err == nil {
+			m.ProcessMessages <- &Message{Topic: responseTopic, Payload: jsondata}
+		}
+	}()
+}
+
+func request(ctx *pubsub.Context, topic string, payload []byte, timeout time.Duration) (*Reply, error) {
+	if ctx == nil || !ctx.Connected.IsTrue() {
+		return nil, errors.New("request on " + topic + " failed, not connected")
+	}
+	data, err := ctx.Request(topic, payload, timeout)
+	if err != nil {
+		return nil, err
+	}
+	reply := &Reply{}
+	if err = json.Unmarshal(data, reply); err != nil {
+		return nil, err
+	}
+	if !reply.Success {
+		return reply, errors.New(reply.Error)
+	}
+	return reply, nil
+}
+
+// StatusChannel is the channel on which every service publishes its Status, "online"
+// when it has connected and "offline" when it is going offline.
+const StatusChannel = "service/status/"
+
+// Status is the payload of the messages on StatusChannel
+type Status struct {
+	Name   string    `json:"name"`
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+const (
+	minReconnectWait = time.Second
+	maxReconnectWait = time.Minute
+	drainTimeout     = 5 * time.Second
+)
+
+// Loop runs the service until a handler returns false or the process receives an
+// interrupt or SIGTERM (e.g. from the overseer).
 func (m *Service) Loop() {
-	quit := false
-	for !quit {
-		m.Pubsub = pubsub.New(config.PubSubCfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			m.Logger.LogInfo(m.Name, "received "+sig.String()+", stopping")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	m.Run(ctx)
+}
+
+// Run connects and handles the messages until 'ctx' is cancelled or a handler returns
+// false. The pubsub transport reconnects by itself, only when the connection is closed
+// Run connects again, waiting longer after every failed attempt.
+func (m *Service) Run(ctx context.Context) {
+	defer m.debug.stop()
+	m.announce(ctx)
+	wait := minReconnectWait
+	for {
+		connected := time.Now()
+		m.Pubsub = pubsub.New(m.discoverBus(ctx))
+		m.Pubsub.OnPublish = m.debug.sent
 		err := m.Pubsub.Connect(m.Name, m.PubsubRegister, m.PubsubSubscribe)
 		if err == nil {
-			m.Logger.LogInfo("pubsub", "connected")
-			m.Pubsub.PublishStr("config/request/", m.Name)
-
-			connected := true
-			for connected {
-				select {
-				case msg := <-m.ProcessMessages:
-					topic := msg.Topic
-					delegate, exists := m.FindHandler(topic)
-					if exists {
-						if !delegate(m, topic, msg.Payload) {
-							connected = false
-							quit = true
-						}
-					}
-
-				case msg := <-m.Pubsub.InMsgs:
-					topic := m.Pubsub.Topic(msg)
-					delegate, exists := m.Handlers[topic]
-					if exists {
-						if !delegate(m, topic, m.Pubsub.Payload(msg)) {
-							connected = false
-							quit = true
-						}
-					} else {
-						delegate, exists := m.Handlers["*"]
-						if exists {
-							if !delegate(m, topic, m.Pubsub.Payload(msg)) {
-								connected = false
-								quit = true
-							}
-						}
-
-						if topic == "client/disconnected/" || topic == "client.disconnected" {
-							m.Logger.LogInfo("pubsub", "disconnected")
-							connected = false
-						}
-					}
+			if m.serve(ctx) {
+				return
+			}
+		} else {
+			m.Logger.LogError(m.Name, err.Error())
+		}
+
+		if time.Since(connected) > maxReconnectWait {
+			wait = minReconnectWait
+		}
+		m.Logger.LogInfo("pubsub", "Waiting "+wait.String()+" before re-connecting..")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > maxReconnectWait {
+			wait = maxReconnectWait
+		}
+	}
+}
+
+// serve handles the messages of a connected service, it returns true when the service
+// should quit and false when the connection was closed.
+func (m *Service) serve(ctx context.Context) (quit bool) {
+	m.Logger.LogInfo("pubsub", "connected")
+	m.Pubsub.Register(StatusChannel)
+	m.Pubsub.Register(HeartbeatChannel)
+	m.publishStatus("online")
+	m.publishHeartbeat()
+	m.Pubsub.PublishStr("config/request/", m.Name)
+	for _, channel := range m.PubsubSubscribe {
+		m.fetchRetained(channel)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.shutdown()
+			return true
+
+		case <-m.stop:
+			m.shutdown()
+			return true
+
+		case f := <-m.inspect:
+			f()
+
+		case msg := <-m.ProcessMessages:
+			if !m.process(msg) {
+				m.shutdown()
+				return true
+			}
+
+		case msg := <-m.Pubsub.InMsgs:
+			switch m.Pubsub.Topic(msg) {
+			case "client/connected/":
+				m.Logger.LogInfo("pubsub", "connected to "+string(msg.Data))
+			case "client/disconnected/":
+				m.Logger.LogInfo("pubsub", "disconnected")
+			case "client/reconnected/":
+				m.Logger.LogInfo("pubsub", "reconnected to "+string(msg.Data))
+			case "client/closed/":
+				m.Logger.LogInfo("pubsub", "connection closed")
+				if m.pool != nil {
+					m.pool.wait(drainTimeout)
 				}
+				m.Pubsub.Close()
+				return false
+			}
+			if msg == m.Pubsub.Tick && time.Since(m.lastHeartbeat) >= HeartbeatInterval {
+				m.publishHeartbeat()
+			}
+			if !m.receive(msg) {
+				m.shutdown()
+				return true
 			}
-			m.Pubsub.Close()
 		}
+	}
+}
 
-		if err != nil {
-			m.Logger.LogError(m.Name, err.Error())
+// shutdown publishes that the service is going offline and drains the connection, the
+// messages that were already received are still handled and the handlers that are
+// running on the worker pool can finish.
+func (m *Service) shutdown() {
+	m.publishStatus("offline")
+	deadline := time.Now().Add(drainTimeout)
+	defer func() {
+		if m.pool != nil && !m.pool.wait(time.Until(deadline)) {
+			m.Logger.LogError(m.Name, "timed out waiting for the running handlers")
+		}
+		m.Pubsub.Close()
+	}()
+	if err := m.Pubsub.Drain(); err != nil {
+		m.Logger.LogError("pubsub", err.Error())
+		return
+	}
+
+	timeout := time.After(drainTimeout)
+	for {
+		select {
+		case msg := <-m.ProcessMessages:
+			m.process(msg)
+		case msg := <-m.Pubsub.InMsgs:
+			topic := m.Pubsub.Topic(msg)
+			if topic == "client/closed/" {
+				return
+			}
+			if msg != m.Pubsub.Tick && !strings.HasPrefix(topic, "client/") {
+				m.receive(msg)
+			}
+		case <-timeout:
+			m.Logger.LogError("pubsub", "timed out draining the connection")
+			return
 		}
+	}
+}
 
-		m.Logger.LogInfo("pubsub", "Waiting 5 seconds before re-connecting..")
-		time.Sleep(5 * time.Second)
+func (m *Service) publishStatus(status string) {
+	jsondata, err := json.Marshal(&Status{Name: m.Name, Status: status, Time: time.Now()})
+	if err == nil {
+		err = m.Pubsub.Publish(StatusChannel, jsondata)
+	}
+	if err != nil {
+		m.Logger.LogError("pubsub", err.Error())
+	}
+}
+
+// process handles a message from ProcessMessages, it returns false when the service
+// should quit.
+func (m *Service) process(msg *Message) bool {
+	delegate, exists := m.FindHandler(msg.Topic)
+	if !exists {
+		return true
+	}
+	if options, concurrent := m.findOptions(msg.Topic); concurrent {
+		m.submit(msg.Topic, msg.Payload, options, func() bool {
+			return delegate(m, msg.Topic, msg.Payload)
+		})
+		return true
+	}
+	m.MessageExpires = time.Time{}
+	return delegate(m, msg.Topic, msg.Payload)
+}
+
+// receive handles a message from pubsub, it returns false when the service should quit.
+func (m *Service) receive(msg *pubsub.Msg) bool {
+	topic := m.Pubsub.Topic(msg)
+	if msg.Expired(time.Now()) {
+		m.Logger.LogInfo("pubsub", "dropped expired message on "+topic)
+		return true
+	}
+	if msg != m.Pubsub.Tick {
+		m.debug.received(msg)
+	}
+	if m.isConfig(topic) {
+		m.ConfigRevision = revisionOf(m.Pubsub.Payload(msg))
+		m.configureDebug(topic, m.Pubsub.Payload(msg))
+	}
+	if options, concurrent := m.findOptions(topic); concurrent {
+		m.submit(topic, m.Pubsub.Payload(msg), options, func() bool {
+			return m.handle(topic, msg)
+		})
+		return true
+	}
+	m.MessageExpires = msg.Expires
+	return m.handle(topic, msg)
+}
+
+// handle calls the handler of a message from pubsub, when the message is a request the
+// reply is send back.
+func (m *Service) handle(topic string, msg *pubsub.Msg) bool {
+	if msg.Reply != "" {
+		reply, running := m.handleRequest(topic, m.Pubsub.Payload(msg))
+		if reply != nil {
+			if err := m.Pubsub.Respond(msg, reply); err != nil {
+				m.Logger.LogError("pubsub", err.Error())
+			}
+		}
+		return running
+	}
+	if replydelegate, exists := m.FindReplyHandler(topic); exists {
+		if reply := replydelegate(m, topic, m.Pubsub.Payload(msg)); reply != nil && !reply.Success {
+			m.Logger.LogError(m.Name, reply.Error)
+		}
+		return true
+	}
+	if delegate, exists := m.Handlers[topic]; exists {
+		return delegate(m, topic, m.Pubsub.Payload(msg))
+	}
+	if delegate, exists := m.Handlers["*"]; exists {
+		return delegate(m, topic, m.Pubsub.Payload(msg))
 	}
+	return true
 }
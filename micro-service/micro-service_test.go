@@ -1,7 +1,13 @@
 package microservice
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"testing"
+	"time"
+
+	pubsub "github.com/jurgen-kluft/go-home/nats"
 )
 
 func TestMatchTopic(t *testing.T) {
@@ -12,6 +18,9 @@ func TestMatchTopic(t *testing.T) {
 	if matchTopic("state/light/*/", "state/light/automation/") == false {
 		t.Fail()
 	}
+	if matchTopic("state/light/*/", "state.light.automation") == false {
+		t.Fail()
+	}
 
 	// Force failures
 	if matchTopic("*/switch/*/", "state/light/automation/") == true {
@@ -23,4 +32,278 @@ func TestMatchTopic(t *testing.T) {
 	if matchTopic("state/light/automation", "state/light/automation/") == true {
 		t.Fail()
 	}
+	if matchTopic("*", "tick") == true {
+		t.Fail()
+	}
+}
+
+func TestHandleRequest(t *testing.T) {
+	m := New("test")
+	m.RegisterReplyHandler("state/light/*/", func(m *Service, topic string, request []byte) *Reply {
+		if string(request) == "on" {
+			return Succeeded([]byte(`{"power":"on"}`))
+		}
+		return Failed(errors.New("unknown command " + string(request)))
+	})
+	handled := ""
+	m.RegisterHandler("state/tv/bedroom/", func(m *Service, topic string, message []byte) bool {
+		handled = string(message)
+		return true
+	})
+
+	tests := []struct {
+		topic   string
+		request string
+		success bool
+		payload string
+		err     string
+	}{
+		{"state.light.kitchen", "on", true, `{"power":"on"}`, ""},
+		{"state.light.kitchen", "dim", false, "", "unknown command dim"},
+		{"state.tv.bedroom", "off", true, "", ""},
+		{"state.tv.livingroom", "off", false, "", "no handler for state.tv.livingroom in test"},
+	}
+	for _, tt := range tests {
+		data, running := m.handleRequest(tt.topic, []byte(tt.request))
+		reply := &Reply{}
+		if err := json.Unmarshal(data, reply); err != nil {
+			t.Fatal(err)
+		}
+		if !running || reply.Success != tt.success || string(reply.Payload) != tt.payload || reply.Error != tt.err {
+			t.Errorf("handleRequest(%s, %s) = %s", tt.topic, tt.request, data)
+		}
+	}
+	if handled != "off" {
+		t.Errorf("request on state.tv.bedroom was not handled by the normal handler")
+	}
+
+	// a request that only the catch all handler sees is meant for another service
+	m.RegisterHandler("*", func(m *Service, topic string, message []byte) bool {
+		handled = topic
+		return true
+	})
+	if data, running := m.handleRequest("state.tv.livingroom", []byte("off")); data != nil || !running || handled != "state.tv.livingroom" {
+		t.Errorf("handleRequest() with only a catch all handler = %s, handled %s", data, handled)
+	}
+
+	if _, err := m.Request("state/light/kitchen/", []byte("on"), 0); err == nil {
+		t.Errorf("Request() without a connection should fail")
+	}
+}
+
+func TestLoop(t *testing.T) {
+	memory := map[string]string{"transport": "memory"}
+	m := New("light")
+	m.PubsubConfig = memory
+	m.RegisterAndSubscribe(nil, []string{"state/light/test/", "test/quit/"})
+	m.RegisterReplyHandler("state/light/test/", func(m *Service, topic string, request []byte) *Reply {
+		if string(request) == "on" {
+			return Succeeded(nil)
+		}
+		return Failed(errors.New("unknown command " + string(request)))
+	})
+	m.RegisterHandler("test/quit/", func(m *Service, topic string, message []byte) bool {
+		return false
+	})
+	done := make(chan bool)
+	go func() {
+		m.Loop()
+		close(done)
+	}()
+
+	client := pubsub.New(memory)
+	if err := client.Connect("client", []string{"state/light/test/", "test/quit/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// wait until the service is connected
+	data, err := client.Request("state/light/test/", []byte("on"), time.Second)
+	for i := 0; err == pubsub.ErrNoResponders && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		data, err = client.Request("state/light/test/", []byte("on"), time.Second)
+	}
+	if err != nil || string(data) != `{"success":true}` {
+		t.Fatalf("Request(on) = %s, %v", data, err)
+	}
+	data, err = client.Request("state/light/test/", []byte("dim"), time.Second)
+	if err != nil || string(data) != `{"success":false,"error":"unknown command dim"}` {
+		t.Errorf("Request(dim) = %s, %v", data, err)
+	}
+
+	// An expired message is dropped
+	client.Transport.Publish(&pubsub.Msg{Subject: "test.quit", Expires: time.Now().Add(-time.Minute)})
+	select {
+	case <-done:
+		t.Fatalf("Loop() handled an expired message")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	client.PublishStr("test/quit/", "")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Loop() did not quit")
+	}
+}
+
+func TestFetchRetained(t *testing.T) {
+	memory := map[string]string{"transport": "memory"}
+	cache := New("statecache")
+	cache.PubsubConfig = memory
+	cache.RegisterAndSubscribe(nil, []string{StateCacheChannel})
+	cache.RegisterReplyHandler(StateCacheChannel, func(m *Service, topic string, request []byte) *Reply {
+		if string(request) != "state/sensor/sun/" {
+			return Failed(errors.New("unexpected request " + string(request)))
+		}
+		jsondata, _ := json.Marshal([]RetainedState{{Topic: "state.sensor.sun", Payload: json.RawMessage(`{"name":"sun"}`)}})
+		return Succeeded(jsondata)
+	})
+	go cache.Loop()
+
+	client := pubsub.New(memory)
+	if err := client.Connect("client", []string{StateCacheChannel}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	_, err := client.Request(StateCacheChannel, []byte("state/sensor/sun/"), time.Second)
+	for i := 0; err == pubsub.ErrNoResponders && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, err = client.Request(StateCacheChannel, []byte("state/sensor/sun/"), time.Second)
+	}
+
+	received := make(chan string, 1)
+	m := New("flux")
+	m.PubsubConfig = memory
+	m.FetchRetained = true
+	m.RegisterAndSubscribe(nil, []string{"state/sensor/sun/"})
+	m.RegisterHandler("state/sensor/sun/", func(m *Service, topic string, message []byte) bool {
+		received <- string(message)
+		return false
+	})
+	go m.Loop()
+
+	select {
+	case state := <-received:
+		if state != `{"name":"sun"}` {
+			t.Errorf("retained state = %s", state)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("the retained state was not fetched")
+	}
+}
+
+func TestRun(t *testing.T) {
+	memory := map[string]string{"transport": "memory"}
+	client := pubsub.New(memory)
+	if err := client.Connect("client", nil, []string{StatusChannel}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	status := func() Status {
+		for {
+			select {
+			case msg := <-client.InMsgs:
+				if msg == client.Tick {
+					continue
+				}
+				var s Status
+				if err := json.Unmarshal(msg.Data, &s); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			case <-time.After(time.Second):
+				t.Fatal("no status received")
+			}
+		}
+	}
+
+	m := New("light")
+	m.PubsubConfig = memory
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	if s := status(); s.Name != "light" || s.Status != "online" {
+		t.Errorf("status = %+v, want online", s)
+	}
+	cancel()
+	if s := status(); s.Name != "light" || s.Status != "offline" {
+		t.Errorf("status = %+v, want offline", s)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Run() did not return after the context was cancelled")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	memory := map[string]string{"transport": "memory"}
+	client := pubsub.New(memory)
+	if err := client.Connect("client", []string{"config/shout/"}, []string{HeartbeatChannel}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	heartbeat := func() Heartbeat {
+		for {
+			select {
+			case msg := <-client.InMsgs:
+				if msg == client.Tick {
+					continue
+				}
+				var h Heartbeat
+				if err := json.Unmarshal(msg.Data, &h); err != nil {
+					t.Fatal(err)
+				}
+				return h
+			case <-time.After(time.Second):
+				t.Fatal("no heartbeat received")
+			}
+		}
+	}
+
+	m := New("shout")
+	m.PubsubConfig = memory
+	m.RegisterAndSubscribe(nil, []string{"config/shout/"})
+	configured := make(chan bool, 1)
+	m.RegisterHandler("config/shout/", func(m *Service, topic string, message []byte) bool {
+		configured <- true
+		return true
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	if h := heartbeat(); h.Name != "shout" || h.Version == "" || h.ConfigRevision != "" || len(h.Subscriptions) != 1 {
+		t.Errorf("heartbeat = %+v", h)
+	}
+	client.PublishStr("config/shout/", `{"channel": "#home"}`)
+	<-configured
+	m.publishHeartbeat()
+	if h := heartbeat(); h.ConfigRevision != revisionOf([]byte(`{"channel": "#home"}`)) {
+		t.Errorf("heartbeat = %+v, want the revision of the configuration", h)
+	}
+}
+
+func TestSubscribeAgain(t *testing.T) {
+	memory := map[string]string{"transport": "memory"}
+	m := New("conbee")
+	m.PubsubConfig = memory
+	m.RegisterAndSubscribe([]string{"state/light/conbee/"}, []string{"config/conbee/"})
+	m.Subscribe("config/conbee/")
+	m.Register("state/light/conbee/")
+	if len(m.PubsubSubscribe) != 1 || len(m.PubsubRegister) != 1 {
+		t.Errorf("subscribing again added a channel, %v %v", m.PubsubSubscribe, m.PubsubRegister)
+	}
+
+	// A reconnect with a list that has the same channel twice still connects
+	client := pubsub.New(memory)
+	if err := client.Connect("conbee", nil, []string{"state/light/automation/", "state/light/automation/"}); err != nil {
+		t.Errorf("Connect() = %v", err)
+	}
+	client.Close()
 }
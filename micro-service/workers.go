@@ -0,0 +1,153 @@
+package microservice
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// KeyFunc returns the ordering key of a message, the messages with the same key are
+// handled one after the other in the order that they were received. Messages with an
+// empty key are handled in any order.
+type KeyFunc func(topic string, message []byte) string
+
+// ByTopic orders the messages per topic
+func ByTopic(topic string, message []byte) string {
+	return topic
+}
+
+// ByName orders the messages per device, the name of a SensorState (e.g. the TV or the
+// light), a message without a name is ordered by topic.
+func ByName(topic string, message []byte) string {
+	state := struct {
+		Name string `json:"name"`
+	}{}
+	if json.Unmarshal(message, &state) == nil && state.Name != "" {
+		return topic + "#" + state.Name
+	}
+	return topic
+}
+
+// Unordered handles the messages in any order
+func Unordered(topic string, message []byte) string {
+	return ""
+}
+
+// HandlerOptions makes the handlers of a topic run on the worker pool of the service
+// instead of in the loop, Timeout is the time after which a handler that is still
+// running gives its worker back (0 is never) so that a stuck device only delays its
+// own messages, the next message of the same key is still only handled when the
+// stuck handler returns.
+type HandlerOptions struct {
+	Key     KeyFunc
+	Timeout time.Duration
+}
+
+// job is run by the worker pool, it calls 'release' when it gives its worker back
+// before it returns.
+type job func(release func())
+
+type pendingJob struct {
+	key string
+	job job
+}
+
+// workerPool runs at most 'size' jobs at the same time, a goroutine is only started for
+// a job when a worker is free. The jobs with the same key are run in order, a job with
+// an empty key in any order.
+type workerPool struct {
+	size    int
+	mutex   sync.Mutex
+	running int              // the jobs that hold a worker
+	queues  map[string][]job // the jobs of a key, the first one is pending or running
+	pending []pendingJob     // the jobs that wait for a worker
+	busy    sync.WaitGroup
+}
+
+func newWorkerPool(size int) *workerPool {
+	if size < 1 {
+		size = 1
+	}
+	return &workerPool{size: size, queues: map[string][]job{}}
+}
+
+func (p *workerPool) submit(key string, j job) {
+	p.busy.Add(1)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key == "" {
+		p.pending = append(p.pending, pendingJob{job: j})
+	} else {
+		queue := p.queues[key]
+		p.queues[key] = append(queue, j)
+		if len(queue) == 0 {
+			p.pending = append(p.pending, pendingJob{key: key, job: j})
+		}
+	}
+	p.dispatch()
+}
+
+// dispatch starts the pending jobs while there are free workers, the mutex is locked
+func (p *workerPool) dispatch() {
+	for p.running < p.size && len(p.pending) > 0 {
+		pj := p.pending[0]
+		p.pending = p.pending[1:]
+		p.running++
+		go p.run(pj)
+	}
+}
+
+func (p *workerPool) run(pj pendingJob) {
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			p.mutex.Lock()
+			p.running--
+			p.dispatch()
+			p.mutex.Unlock()
+		})
+	}
+	pj.job(release)
+	release()
+
+	if pj.key != "" {
+		p.mutex.Lock()
+		queue := p.queues[pj.key][1:]
+		if len(queue) == 0 {
+			delete(p.queues, pj.key)
+		} else {
+			p.queues[pj.key] = queue
+			p.pending = append(p.pending, pendingJob{key: pj.key, job: queue[0]})
+			p.dispatch()
+		}
+		p.mutex.Unlock()
+	}
+	p.busy.Done()
+}
+
+// wait waits at most 'timeout' until all the submitted jobs are done, it returns false
+// when that took too long.
+func (p *workerPool) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		p.busy.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// withTimeout calls 'handler' and calls 'timedout' when the handler did not return
+// within 'timeout', the handler is never abandoned so withTimeout only returns when
+// the handler does.
+func withTimeout(timeout time.Duration, handler func(), timedout func()) {
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, timedout)
+		defer timer.Stop()
+	}
+	handler()
+}
@@ -0,0 +1,209 @@
+package microservice
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+func TestByName(t *testing.T) {
+	if key := ByName("state/samsung.tv/automation/", []byte(`{"name":"Bedroom TV"}`)); key != "state/samsung.tv/automation/#Bedroom TV" {
+		t.Errorf("ByName() = %s", key)
+	}
+	if key := ByName("shout/message/", []byte("hello")); key != "shout/message/" {
+		t.Errorf("ByName() without a name = %s", key)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	pool := newWorkerPool(2)
+	mutex := sync.Mutex{}
+	order := []int{}
+	stuck := make(chan struct{})
+	pool.submit("stuck", func(release func()) { <-stuck })
+	for i := 0; i < 10; i++ {
+		i := i
+		pool.submit("tv", func(release func()) {
+			time.Sleep(time.Millisecond)
+			mutex.Lock()
+			order = append(order, i)
+			mutex.Unlock()
+		})
+	}
+	if pool.wait(100 * time.Millisecond) {
+		t.Errorf("wait() returned true while a job is stuck")
+	}
+	mutex.Lock()
+	for i, n := range order {
+		if i != n {
+			t.Errorf("the jobs of a key ran out of order, %v", order)
+			break
+		}
+	}
+	if len(order) != 10 {
+		t.Errorf("a stuck key delayed another key, %d of 10 jobs ran", len(order))
+	}
+	mutex.Unlock()
+	close(stuck)
+	if !pool.wait(time.Second) {
+		t.Errorf("wait() returned false when all the jobs are done")
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	memory := map[string]string{"transport": "memory"}
+	m := New("samsung.tv")
+	m.PubsubConfig = memory
+	m.RegisterAndSubscribe(nil, []string{"state/samsung.tv/automation/"})
+	stuck := make(chan struct{})
+	handled := make(chan string, 1)
+	m.RegisterHandler("state/samsung.tv/automation/", func(m *Service, topic string, message []byte) bool {
+		if key := ByName(topic, message); key == "state.samsung.tv.automation#Bedroom TV" {
+			<-stuck
+		} else {
+			handled <- key
+		}
+		return true
+	})
+	m.RunConcurrently("state/samsung.tv/automation/", HandlerOptions{Key: ByName, Timeout: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	client := pubsub.New(memory)
+	if err := client.Connect("client", []string{"state/samsung.tv/automation/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	_, err := client.Request("state/samsung.tv/automation/", []byte(`{"name":"Bedroom TV"}`), 10*time.Millisecond)
+	for i := 0; err == pubsub.ErrNoResponders && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, err = client.Request("state/samsung.tv/automation/", []byte(`{"name":"Bedroom TV"}`), 10*time.Millisecond)
+	}
+
+	client.PublishStr("state/samsung.tv/automation/", `{"name":"Living TV"}`)
+	select {
+	case key := <-handled:
+		if key != "state.samsung.tv.automation#Living TV" {
+			t.Errorf("handled %s", key)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("a stuck device delayed another device")
+	}
+
+	close(stuck)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * drainTimeout):
+		t.Errorf("Run() did not return")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	timedout := make(chan struct{}, 2)
+	withTimeout(0, func() {}, func() { timedout <- struct{}{} })
+	withTimeout(20*time.Millisecond, func() {}, func() { timedout <- struct{}{} })
+	stuck := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(stuck) })
+	withTimeout(10*time.Millisecond, func() { <-stuck }, func() { timedout <- struct{}{} })
+	select {
+	case <-stuck:
+	default:
+		t.Errorf("withTimeout() returned before the handler")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if len(timedout) != 1 {
+		t.Errorf("timed out %d times, want 1", len(timedout))
+	}
+}
+
+func TestTimedOutJobKeepsItsKey(t *testing.T) {
+	pool := newWorkerPool(1)
+	mutex := sync.Mutex{}
+	running, overlaps := 0, 0
+	job := func() {
+		mutex.Lock()
+		running++
+		if running > 1 {
+			overlaps++
+		}
+		mutex.Unlock()
+		time.Sleep(30 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	}
+	for i := 0; i < 3; i++ {
+		pool.submit("tv", func(release func()) { withTimeout(time.Millisecond, job, release) })
+	}
+	if pool.wait(50 * time.Millisecond) {
+		t.Errorf("wait() returned while a timed out job is still running")
+	}
+	if !pool.wait(time.Second) {
+		t.Errorf("wait() returned false when all the jobs are done")
+	}
+	if overlaps > 0 {
+		t.Errorf("a timed out job overlapped with %d other jobs", overlaps)
+	}
+}
+
+func TestTimedOutJobGivesItsWorkerBack(t *testing.T) {
+	pool := newWorkerPool(1)
+	stuck := make(chan struct{})
+	ran := make(chan string, 3)
+	pool.submit("bedroom", func(release func()) {
+		withTimeout(10*time.Millisecond, func() { <-stuck }, release)
+		ran <- "bedroom 1"
+	})
+	pool.submit("bedroom", func(release func()) { ran <- "bedroom 2" })
+	pool.submit("living", func(release func()) { ran <- "living" })
+
+	select {
+	case name := <-ran:
+		if name != "living" {
+			t.Errorf("%s ran while the first job of its key is stuck", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("a stuck job that timed out kept its worker")
+	}
+	close(stuck)
+	if first, second := <-ran, <-ran; first != "bedroom 1" || second != "bedroom 2" {
+		t.Errorf("the jobs of a key ran as %s, %s", first, second)
+	}
+	if !pool.wait(time.Second) {
+		t.Errorf("wait() returned false when all the jobs are done")
+	}
+}
+
+func TestUnorderedJobsAreBounded(t *testing.T) {
+	pool := newWorkerPool(2)
+	mutex := sync.Mutex{}
+	running, most := 0, 0
+	for i := 0; i < 20; i++ {
+		pool.submit("", func(release func()) {
+			mutex.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			mutex.Unlock()
+			time.Sleep(2 * time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		})
+	}
+	if !pool.wait(time.Second) {
+		t.Fatalf("wait() returned false")
+	}
+	if most != 2 {
+		t.Errorf("%d unordered jobs ran at the same time, want 2", most)
+	}
+}
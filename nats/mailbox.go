@@ -0,0 +1,66 @@
+package pubsub
+
+import "sync"
+
+// mailbox forwards the messages of a transport to 'out' on its own goroutine, so that the
+// goroutine that receives them from the broker never waits for a service that is busy
+// (e.g. with a handler that subscribes and waits for the broker to acknowledge it). The
+// mailbox stops after it forwarded 'client/closed/' or when it is stopped.
+type mailbox struct {
+	out   chan *Msg
+	mutex sync.Mutex
+	queue []*Msg
+	wake  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newMailbox(out chan *Msg) *mailbox {
+	b := &mailbox{out: out, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go b.forward()
+	return b
+}
+
+// put queues a message, it never blocks
+func (b *mailbox) put(msg *Msg) {
+	b.mutex.Lock()
+	b.queue = append(b.queue, msg)
+	b.mutex.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop drops the messages that are not forwarded yet
+func (b *mailbox) stop() {
+	b.once.Do(func() { close(b.done) })
+}
+
+func (b *mailbox) forward() {
+	for {
+		b.mutex.Lock()
+		if len(b.queue) == 0 {
+			b.mutex.Unlock()
+			select {
+			case <-b.wake:
+				continue
+			case <-b.done:
+				return
+			}
+		}
+		msg := b.queue[0]
+		b.queue[0] = nil
+		b.queue = b.queue[1:]
+		b.mutex.Unlock()
+
+		select {
+		case b.out <- msg:
+		case <-b.done:
+			return
+		}
+		if msg.Subject == "client/closed/" {
+			return
+		}
+	}
+}
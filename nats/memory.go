@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBroker routes messages between the transports of services that run in the same
+// process, e.g. in unit and integration tests. Subjects support the '*' and '>' wildcards
+// of NATS.
+type MemoryBroker struct {
+	mutex         sync.Mutex
+	subscriptions []*memorySubscription
+	inbox         int
+}
+
+type memorySubscription struct {
+	subject string
+	deliver func(msg *Msg)
+}
+
+// DefaultBroker is the broker of the "memory" transport
+var DefaultBroker = NewMemoryBroker()
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+// NewTransport returns a transport that is connected to the broker
+func (b *MemoryBroker) NewTransport() *MemoryTransport {
+	return &MemoryTransport{broker: b}
+}
+
+func (b *MemoryBroker) subscribe(subject string, deliver func(msg *Msg)) *memorySubscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := &memorySubscription{subject: subject, deliver: deliver}
+	b.subscriptions = append(b.subscriptions, s)
+	return s
+}
+
+func (b *MemoryBroker) unsubscribe(subscriptions ...*memorySubscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	remaining := b.subscriptions[:0]
+	for _, s := range b.subscriptions {
+		removed := false
+		for _, u := range subscriptions {
+			removed = removed || s == u
+		}
+		if !removed {
+			remaining = append(remaining, s)
+		}
+	}
+	b.subscriptions = remaining
+}
+
+// publish delivers a message to all the matching subscriptions and returns the number
+// of subscriptions that received it.
+func (b *MemoryBroker) publish(msg *Msg) int {
+	b.mutex.Lock()
+	matching := []*memorySubscription{}
+	for _, s := range b.subscriptions {
+		if MatchSubject(s.subject, msg.Subject) {
+			matching = append(matching, s)
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, s := range matching {
+		s.deliver(&Msg{Subject: msg.Subject, Reply: msg.Reply, Data: msg.Data, Expires: msg.Expires})
+	}
+	return len(matching)
+}
+
+func (b *MemoryBroker) newInbox() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.inbox++
+	return fmt.Sprintf("_INBOX.%d", b.inbox)
+}
+
+// MatchSubject returns true when 'subject' matches the subscribed 'pattern'
+func MatchSubject(pattern string, subject string) bool {
+	ptokens := strings.Split(pattern, ".")
+	stokens := strings.Split(subject, ".")
+	for i, p := range ptokens {
+		if p == ">" {
+			return len(stokens) > i
+		}
+		if i >= len(stokens) || (p != "*" && p != stokens[i]) {
+			return false
+		}
+	}
+	return len(ptokens) == len(stokens)
+}
+
+// MemoryTransport is the Transport of a service on a MemoryBroker, the messages are
+// delivered through a mailbox so that a publisher never waits for a busy service (also
+// not when a handler publishes on its own channel).
+type MemoryTransport struct {
+	broker        *MemoryBroker
+	mailbox       *mailbox
+	subscriptions []*memorySubscription
+}
+
+func (t *MemoryTransport) Connect(name string, inmsgs chan *Msg) error {
+	t.mailbox = newMailbox(inmsgs)
+	return nil
+}
+
+func (t *MemoryTransport) Subscribe(subject string) error {
+	mailbox := t.mailbox
+	s := t.broker.subscribe(subject, mailbox.put)
+	t.subscriptions = append(t.subscriptions, s)
+	return nil
+}
+
+func (t *MemoryTransport) Publish(msg *Msg) error {
+	t.broker.publish(msg)
+	return nil
+}
+
+func (t *MemoryTransport) Request(msg *Msg, timeout time.Duration) ([]byte, error) {
+	inbox := t.broker.newInbox()
+	replies := make(chan []byte, 1)
+	s := t.broker.subscribe(inbox, func(msg *Msg) {
+		select {
+		case replies <- msg.Data:
+		default:
+		}
+	})
+	defer t.broker.unsubscribe(s)
+
+	if t.broker.publish(&Msg{Subject: msg.Subject, Reply: inbox, Data: msg.Data, Expires: msg.Expires}) == 0 {
+		return nil, ErrNoResponders
+	}
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("PubSub.Request on %s timed out", msg.Subject)
+	}
+}
+
+func (t *MemoryTransport) Drain() error {
+	t.broker.unsubscribe(t.subscriptions...)
+	t.subscriptions = nil
+	if t.mailbox != nil {
+		t.mailbox.put(&Msg{Subject: "client/closed/"})
+	}
+	return nil
+}
+
+func (t *MemoryTransport) Close() {
+	t.broker.unsubscribe(t.subscriptions...)
+	t.subscriptions = nil
+	if t.mailbox != nil {
+		t.mailbox.stop()
+	}
+}
@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchSubject(t *testing.T) {
+	tests := []struct {
+		pattern string
+		subject string
+		match   bool
+	}{
+		{"state.light.kitchen", "state.light.kitchen", true},
+		{"state.light.*", "state.light.kitchen", true},
+		{"state.>", "state.light.kitchen", true},
+		{"state.light", "state.light.kitchen", false},
+		{"state.*", "state.light.kitchen", false},
+		{"state.light.kitchen.>", "state.light.kitchen", false},
+	}
+	for _, tt := range tests {
+		if MatchSubject(tt.pattern, tt.subject) != tt.match {
+			t.Errorf("MatchSubject(%s, %s) != %v", tt.pattern, tt.subject, tt.match)
+		}
+	}
+}
+
+func receive(t *testing.T, ctx *Context) *Msg {
+	select {
+	case msg := <-ctx.InMsgs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+	return nil
+}
+
+func TestMemoryTransport(t *testing.T) {
+	broker := NewMemoryBroker()
+	publisher := New(map[string]string{})
+	publisher.Transport = broker.NewTransport()
+	if err := publisher.Connect("publisher", []string{"state/light/kitchen/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	subscriber := New(map[string]string{})
+	subscriber.Transport = broker.NewTransport()
+	if err := subscriber.Connect("subscriber", nil, []string{"state/light/kitchen/"}); err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+
+	if err := publisher.PublishStr("state/light/kitchen/", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, subscriber); msg.Subject != "state.light.kitchen" || string(msg.Data) != "on" || msg.Reply != "" {
+		t.Errorf("received %+v", msg)
+	}
+	if err := publisher.PublishTTLStr("state/light/kitchen/", "off", 60); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, subscriber); msg.Expired(time.Now()) || !msg.Expired(time.Now().Add(61*time.Second)) {
+		t.Errorf("received %+v, want an expiry in 60 seconds", msg)
+	}
+	if err := publisher.PublishStr("state/light/bedroom/", "on"); err == nil {
+		t.Errorf("publish on an unregistered channel should fail")
+	}
+
+	go func() {
+		msg := <-subscriber.InMsgs
+		subscriber.Respond(msg, append([]byte("ack "), msg.Data...))
+	}()
+	reply, err := publisher.Request("state/light/kitchen/", []byte("off"), time.Second)
+	if err != nil || string(reply) != "ack off" {
+		t.Errorf("Request() = %s, %v", reply, err)
+	}
+
+	subscriber.Close()
+	if _, err = publisher.Request("state/light/kitchen/", []byte("off"), time.Second); err != ErrNoResponders {
+		t.Errorf("Request() without subscribers = %v", err)
+	}
+}
+
+func TestMemoryTransportDoesNotBlock(t *testing.T) {
+	transport := NewMemoryBroker().NewTransport()
+	inmsgs := make(chan *Msg)
+	transport.Connect("automation", inmsgs)
+	transport.Subscribe("state.light.kitchen")
+
+	// Nobody reads 'inmsgs', e.g. a handler that publishes on its own channel
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 200; i++ {
+			transport.Publish(&Msg{Subject: "state.light.kitchen", Data: []byte{byte(i)}})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publish() waited for a busy service")
+	}
+	for i := 0; i < 200; i++ {
+		if msg := <-inmsgs; msg.Data[0] != byte(i) {
+			t.Fatalf("received message %d as %d", msg.Data[0], i)
+		}
+	}
+	transport.Drain()
+	if msg := <-inmsgs; msg.Subject != "client/closed/" {
+		t.Errorf("Drain() send %s", msg.Subject)
+	}
+}
@@ -0,0 +1,389 @@
+package pubsub
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MqttTransport is the Transport on top of an MQTT 3.1.1 broker (e.g. mosquitto), the
+// configuration holds the "host" (e.g. tcp://10.0.0.22:1883) and optionally a "username"
+// and the "secret" as password. Messages are published with QoS 0.
+//
+// MQTT has no request/reply, a request on 'state/light/kitchen' is published on the topic
+// '_request/{client}/{n}/state/light/kitchen' and the reply is expected on '_inbox/{client}/{n}'.
+// MQTT 3.1.1 also has no headers, a message that expires is published on the topic
+// '_expires/{unix time in ms}/state/light/kitchen'. The expiry of a request is not carried.
+// A subscription waits for the broker to acknowledge it.
+type MqttTransport struct {
+	Config    map[string]string
+	ClientID  string
+	conn      net.Conn
+	writer    sync.Mutex
+	mutex     sync.Mutex
+	packetID  uint16
+	request   int
+	requests  map[string]chan []byte
+	subacks   map[uint16]chan byte
+	mailbox   *mailbox
+	closed    bool
+	keepAlive time.Duration
+}
+
+func NewMqttTransport(config map[string]string) *MqttTransport {
+	return &MqttTransport{Config: config, requests: map[string]chan []byte{}, subacks: map[uint16]chan byte{}, keepAlive: 60 * time.Second}
+}
+
+// mqttAckTimeout is the time that the broker has to acknowledge a connection or a subscription
+const mqttAckTimeout = 5 * time.Second
+
+// topicOf converts a NATS subject to an MQTT topic, including the wildcards
+func topicOf(subject string) string {
+	return strings.NewReplacer(".", "/", "*", "+", ">", "#").Replace(subject)
+}
+
+func subjectOfTopic(topic string) string {
+	return strings.Replace(topic, "/", ".", -1)
+}
+
+func newClientID(name string) string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	name = strings.NewReplacer("/", "-", ".", "-", "+", "-", "#", "-").Replace(name)
+	return fmt.Sprintf("%s-%x", name, random)
+}
+
+func (t *MqttTransport) Connect(name string, inmsgs chan *Msg) error {
+	host := t.Config["host"]
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	conn, err := net.DialTimeout("tcp", host, mqttAckTimeout)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	t.ClientID = newClientID(name)
+
+	// CONNECT with a clean session
+	flags := byte(0x02)
+	payload := mqttString(t.ClientID)
+	if username := t.Config["username"]; username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(username)...)
+		if password := t.Config["secret"]; password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(password)...)
+		}
+	}
+	keepalive := int(t.keepAlive / time.Second)
+	variable := append(mqttString("MQTT"), 4, flags, byte(keepalive>>8), byte(keepalive))
+	if err = t.write(0x10, append(variable, payload...)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(mqttAckTimeout))
+	header, body, err := readMqttPacket(reader)
+	conn.SetReadDeadline(time.Time{})
+	if err == nil && (header>>4 != 2 || len(body) < 2) {
+		err = errors.New("PubSub.Connect failed, MQTT broker did not acknowledge the connection")
+	} else if err == nil && body[1] != 0 {
+		err = fmt.Errorf("PubSub.Connect failed, MQTT broker refused the connection (%d)", body[1])
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	t.mailbox = newMailbox(inmsgs)
+	go t.read(reader)
+	go t.ping()
+	return t.subscribe("_inbox/" + t.ClientID + "/+")
+}
+
+// Subscribe subscribes to the topic and to the topics of the messages that expire and
+// of the requests on it, a topic like '#' or '+/#' already matches those and is only
+// subscribed once so that the broker does not deliver them twice.
+func (t *MqttTransport) Subscribe(subject string) error {
+	topic := topicOf(subject)
+	if err := t.subscribe(topic); err != nil {
+		return err
+	}
+	if matchesAnyPrefix(topic) {
+		return nil
+	}
+	if err := t.subscribe("_expires/+/" + topic); err != nil {
+		return err
+	}
+	return t.subscribe("_request/+/+/" + topic)
+}
+
+// matchesAnyPrefix returns true when the topic filter only consists of '+' levels and
+// ends with '#', it then also matches the topic with any levels in front of it.
+func matchesAnyPrefix(topic string) bool {
+	levels := strings.Split(topic, "/")
+	for _, level := range levels[:len(levels)-1] {
+		if level != "+" {
+			return false
+		}
+	}
+	return levels[len(levels)-1] == "#"
+}
+
+// subscribe sends a SUBSCRIBE and waits for the SUBACK of the broker
+func (t *MqttTransport) subscribe(topic string) error {
+	t.mutex.Lock()
+	t.packetID++
+	if t.packetID == 0 {
+		t.packetID = 1
+	}
+	id := t.packetID
+	suback := make(chan byte, 1)
+	t.subacks[id] = suback
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		delete(t.subacks, id)
+		t.mutex.Unlock()
+	}()
+
+	body := []byte{byte(id >> 8), byte(id)}
+	body = append(body, mqttString(topic)...)
+	if err := t.write(0x82, append(body, 0)); err != nil {
+		return err
+	}
+	select {
+	case code := <-suback:
+		if code == 0x80 {
+			return fmt.Errorf("PubSub.Subscribe failed, MQTT broker rejected the subscription on %s", topic)
+		}
+		return nil
+	case <-time.After(mqttAckTimeout):
+		return fmt.Errorf("PubSub.Subscribe on %s timed out, MQTT broker did not acknowledge the subscription", topic)
+	}
+}
+
+func (t *MqttTransport) Publish(msg *Msg) error {
+	if strings.HasPrefix(msg.Subject, "_inbox.") {
+		// the reply on a request
+		return t.publish(strings.Replace(msg.Subject, ".", "/", 2), msg.Data)
+	}
+	if !msg.Expires.IsZero() {
+		expires := strconv.FormatInt(msg.Expires.UnixNano()/int64(time.Millisecond), 10)
+		return t.publish("_expires/"+expires+"/"+topicOf(msg.Subject), msg.Data)
+	}
+	return t.publish(topicOf(msg.Subject), msg.Data)
+}
+
+func (t *MqttTransport) publish(topic string, data []byte) error {
+	return t.write(0x30, append(mqttString(topic), data...))
+}
+
+func (t *MqttTransport) Request(msg *Msg, timeout time.Duration) ([]byte, error) {
+	t.mutex.Lock()
+	t.request++
+	id := strconv.Itoa(t.request)
+	replies := make(chan []byte, 1)
+	t.requests[id] = replies
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		delete(t.requests, id)
+		t.mutex.Unlock()
+	}()
+
+	if err := t.publish("_request/"+t.ClientID+"/"+id+"/"+topicOf(msg.Subject), msg.Data); err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("PubSub.Request on %s timed out", msg.Subject)
+	}
+}
+
+// Close disconnects and drops the messages that were not yet send to 'inmsgs'
+func (t *MqttTransport) Close() {
+	t.disconnect()
+	if t.mailbox != nil {
+		t.mailbox.stop()
+	}
+}
+
+func (t *MqttTransport) disconnect() {
+	t.mutex.Lock()
+	closed := t.closed
+	t.closed = true
+	t.mutex.Unlock()
+	if !closed && t.conn != nil {
+		t.write(0xE0, nil)
+		t.conn.Close()
+	}
+}
+
+// Drain closes the connection since an MQTT client does not receive anything after it
+// has send DISCONNECT, the messages that were received are still send to 'inmsgs'.
+func (t *MqttTransport) Drain() error {
+	t.disconnect()
+	if t.mailbox != nil {
+		t.mailbox.put(&Msg{Subject: "client/closed/"})
+	}
+	return nil
+}
+
+func (t *MqttTransport) isClosed() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.closed
+}
+
+// read receives the packets from the broker until the connection is closed, the messages
+// go through the mailbox so that a SUBACK is never stuck behind a busy service.
+func (t *MqttTransport) read(reader *bufio.Reader) {
+	for {
+		header, body, err := readMqttPacket(reader)
+		if err != nil {
+			if !t.isClosed() {
+				t.mailbox.put(&Msg{Subject: "client/closed/"})
+			}
+			return
+		}
+		if header>>4 == 9 && len(body) >= 3 {
+			t.acknowledge(binary.BigEndian.Uint16(body), body[2])
+			continue
+		}
+		if header>>4 != 3 || len(body) < 2 {
+			continue // only PUBLISH and SUBACK are of interest
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+n {
+			continue
+		}
+		topic := string(body[2 : 2+n])
+		payload := body[2+n:]
+		if qos := (header >> 1) & 3; qos > 0 && len(payload) >= 2 {
+			if qos == 1 {
+				t.write(0x40, payload[:2])
+			}
+			payload = payload[2:]
+		}
+		t.receive(topic, payload)
+	}
+}
+
+// acknowledge passes the return code of a SUBACK to the subscription that waits for it
+func (t *MqttTransport) acknowledge(id uint16, code byte) {
+	t.mutex.Lock()
+	suback, exists := t.subacks[id]
+	t.mutex.Unlock()
+	if exists {
+		select {
+		case suback <- code:
+		default:
+		}
+	}
+}
+
+func (t *MqttTransport) receive(topic string, payload []byte) {
+	if strings.HasPrefix(topic, "_inbox/") {
+		id := topic[strings.LastIndex(topic, "/")+1:]
+		t.mutex.Lock()
+		replies, exists := t.requests[id]
+		t.mutex.Unlock()
+		if exists {
+			select {
+			case replies <- payload:
+			default:
+			}
+		}
+		return
+	}
+	if strings.HasPrefix(topic, "_expires/") {
+		parts := strings.SplitN(topic, "/", 3)
+		if len(parts) == 3 {
+			msg := &Msg{Subject: subjectOfTopic(parts[2]), Data: payload}
+			if ms, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+				msg.Expires = time.Unix(0, ms*int64(time.Millisecond))
+			}
+			t.mailbox.put(msg)
+		}
+		return
+	}
+	if strings.HasPrefix(topic, "_request/") {
+		parts := strings.SplitN(topic, "/", 4)
+		if len(parts) == 4 {
+			t.mailbox.put(&Msg{Subject: subjectOfTopic(parts[3]), Reply: "_inbox." + parts[1] + "." + parts[2], Data: payload})
+		}
+		return
+	}
+	t.mailbox.put(&Msg{Subject: subjectOfTopic(topic), Data: payload})
+}
+
+// ping keeps the connection alive
+func (t *MqttTransport) ping() {
+	for !t.isClosed() {
+		time.Sleep(t.keepAlive / 2)
+		if !t.isClosed() {
+			t.write(0xC0, nil)
+		}
+	}
+}
+
+func (t *MqttTransport) write(header byte, body []byte) error {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	t.writer.Lock()
+	defer t.writer.Unlock()
+	_, err := t.conn.Write(append(packet, body...))
+	return err
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func readMqttPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := 0
+	for shift := uint(0); ; shift += 7 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift > 21 {
+			return 0, nil, errors.New("PubSub, malformed MQTT packet")
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	return header, body, err
+}
@@ -0,0 +1,203 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMqttBroker is a QoS 0 MQTT broker that supports the '+' and '#' wildcards, like
+// mosquitto it delivers a message once for every subscription that matches and it
+// rejects the subscriptions on 'forbidden'.
+type fakeMqttBroker struct {
+	listener      net.Listener
+	mutex         sync.Mutex
+	subscriptions map[net.Conn][]string
+}
+
+func newFakeMqttBroker(t *testing.T) *fakeMqttBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeMqttBroker{listener: listener, subscriptions: map[net.Conn][]string{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func matchTopic(filter string, topic string) bool {
+	ftokens := strings.Split(filter, "/")
+	ttokens := strings.Split(topic, "/")
+	for i, f := range ftokens {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ttokens) || (f != "+" && f != ttokens[i]) {
+			return false
+		}
+	}
+	return len(ftokens) == len(ttokens)
+}
+
+func (b *fakeMqttBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		header, body, err := readMqttPacket(reader)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 8: // SUBSCRIBE
+			n := int(binary.BigEndian.Uint16(body[2:]))
+			filter := string(body[4 : 4+n])
+			if strings.HasPrefix(filter, "forbidden") {
+				conn.Write([]byte{0x90, 3, body[0], body[1], 0x80})
+				continue
+			}
+			b.mutex.Lock()
+			b.subscriptions[conn] = append(b.subscriptions[conn], filter)
+			b.mutex.Unlock()
+			conn.Write([]byte{0x90, 3, body[0], body[1], 0})
+		case 3: // PUBLISH
+			n := int(binary.BigEndian.Uint16(body))
+			topic := string(body[2 : 2+n])
+			packet := append([]byte{0x30, byte(len(body))}, body...)
+			b.mutex.Lock()
+			for c, filters := range b.subscriptions {
+				for _, filter := range filters {
+					if matchTopic(filter, topic) {
+						c.Write(packet)
+					}
+				}
+			}
+			b.mutex.Unlock()
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func TestMqttTransport(t *testing.T) {
+	broker := newFakeMqttBroker(t)
+	defer broker.listener.Close()
+	config := map[string]string{"transport": "mqtt", "host": "tcp://" + broker.listener.Addr().String()}
+
+	publisher := New(config)
+	if err := publisher.Connect("publisher", []string{"state/samsung.tv/automation/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	subscriber := New(config)
+	if err := subscriber.Connect("subscriber", nil, []string{"state/samsung.tv/automation/"}); err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+	time.Sleep(100 * time.Millisecond) // let the broker handle the subscriptions
+
+	if err := publisher.PublishStr("state/samsung.tv/automation/", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, subscriber); msg.Subject != "state.samsung.tv.automation" || string(msg.Data) != "on" || msg.Reply != "" {
+		t.Errorf("received %+v", msg)
+	}
+
+	if err := publisher.PublishTTLStr("state/samsung.tv/automation/", "off", 60); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, subscriber); msg.Subject != "state.samsung.tv.automation" || msg.Expired(time.Now()) || !msg.Expired(time.Now().Add(61*time.Second)) {
+		t.Errorf("received %+v, want an expiry in 60 seconds", msg)
+	}
+
+	go func() {
+		msg := <-subscriber.InMsgs
+		subscriber.Respond(msg, append([]byte("ack "), msg.Data...))
+	}()
+	reply, err := publisher.Request("state/samsung.tv/automation/", []byte("off"), time.Second)
+	if err != nil || string(reply) != "ack off" {
+		t.Errorf("Request() = %s, %v", reply, err)
+	}
+
+	if err := subscriber.Subscribe("forbidden/"); err == nil {
+		t.Errorf("Subscribe() on a topic that the broker rejects succeeded")
+	}
+}
+
+func TestMqttTransportWildcard(t *testing.T) {
+	broker := newFakeMqttBroker(t)
+	defer broker.listener.Close()
+	config := map[string]string{"transport": "mqtt", "host": "tcp://" + broker.listener.Addr().String()}
+
+	publisher := New(config)
+	if err := publisher.Connect("publisher", []string{"state/samsung.tv/automation/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	recorder := New(config)
+	if err := recorder.Connect("recorder", nil, []string{">"}); err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+
+	if err := publisher.PublishTTLStr("state/samsung.tv/automation/", "off", 60); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, recorder); msg.Subject != "state.samsung.tv.automation" || msg.Expires.IsZero() {
+		t.Errorf("received %+v, want an expiring message", msg)
+	}
+	select {
+	case msg := <-recorder.InMsgs:
+		t.Errorf("received %+v twice", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for topic, want := range map[string]bool{"#": true, "+/#": true, "+/+/#": true, "state/#": false, "+/light/#": false, "+/light": false} {
+		if matchesAnyPrefix(topic) != want {
+			t.Errorf("matchesAnyPrefix(%s) = %v", topic, !want)
+		}
+	}
+}
+
+func TestMqttSubscribeWhileBusy(t *testing.T) {
+	broker := newFakeMqttBroker(t)
+	defer broker.listener.Close()
+	config := map[string]string{"transport": "mqtt", "host": "tcp://" + broker.listener.Addr().String()}
+
+	publisher := New(config)
+	if err := publisher.Connect("publisher", []string{"state/samsung.tv/automation/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	subscriber := NewMqttTransport(config)
+	inmsgs := make(chan *Msg) // a service that is busy in a handler
+	if err := subscriber.Connect("recorder", inmsgs); err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+	if err := subscriber.Subscribe("state.samsung.tv.automation"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		publisher.PublishStr("state/samsung.tv/automation/", "on")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := subscriber.Subscribe("state.light.automation"); err != nil || time.Since(start) > time.Second {
+		t.Errorf("Subscribe() while the service is busy = %v after %s", err, time.Since(start))
+	}
+}
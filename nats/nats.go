@@ -3,7 +3,7 @@ package pubsub
 import (
 	"fmt"
 	server "github.com/nats-io/nats.go"
-	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -25,150 +25,201 @@ func (b *AtomBool) IsTrue() bool {
 	return false
 }
 
-// Context contains the necessary information to run the Nats client
+// Context contains the necessary information to run the pubsub client, the messages go
+// over the Transport that is selected by the configuration.
 type Context struct {
-	Config        map[string]string
-	InMsgs        chan *server.Msg
-	SubToIndex    map[string]int
-	Subscriptions []*server.Subscription
-	SubChannels   []string
-	Client        *server.Conn
-	Connected     *AtomBool
-	Tick          *server.Msg
+	Config      map[string]string
+	Transport   Transport
+	InMsgs      chan *Msg
+	mutex       sync.RWMutex // guards SubToIndex and SubChannels, requests are send from other goroutines
+	SubToIndex  map[string]int
+	SubChannels []string
+	Connected   *AtomBool
+	Tick        *Msg
+	OnPublish   func(msg *Msg) // when set it is called with every message that is published
 }
 
 func New(config map[string]string) *Context {
 	ctx := &Context{}
 	ctx.Config = config
 	ctx.SubToIndex = make(map[string]int)
-	ctx.Subscriptions = make([]*server.Subscription, 0, 10)
 	ctx.SubChannels = make([]string, 0, 10)
 	ctx.Connected = new(AtomBool)
-	ctx.Tick = &server.Msg{Subject: "tick/", Data: nil}
+	ctx.Tick = &Msg{Subject: "tick/", Data: nil}
 	return ctx
 }
 
-func (ctx *Context) Topic(msg *server.Msg) string {
+func (ctx *Context) Topic(msg *Msg) string {
 	return msg.Subject
 }
 
-func (ctx *Context) Payload(msg *server.Msg) []byte {
+func (ctx *Context) Payload(msg *Msg) []byte {
 	return msg.Data
 }
 
+// Conn returns the NATS connection, it is nil when the transport is not NATS
+func (ctx *Context) Conn() *server.Conn {
+	if t, ok := ctx.Transport.(*NatsTransport); ok {
+		return t.Conn
+	}
+	return nil
+}
+
 func (ctx *Context) Connect(username string, register, subscribe []string) error {
 	var err error
-
-	ctx.Client, err = server.Connect(ctx.Config["host"],
-		server.Name(username),
-		server.Token(ctx.Config["secret"]),
-		server.DisconnectErrHandler(func(nc *server.Conn, err error) {
-			ctx.Connected.Set(false)
-			msg := &server.Msg{Subject: "client/disconnected/"}
-			ctx.InMsgs <- msg
-		}),
-		server.ReconnectHandler(func(nc *server.Conn) {
-			msg := &server.Msg{Subject: "client/reconnected/", Data: []byte(nc.ConnectedUrl())}
-			ctx.InMsgs <- msg
-		}),
-		server.ClosedHandler(func(nc *server.Conn) {
-			msg := &server.Msg{Subject: "client/closed/"}
-			ctx.InMsgs <- msg
-		}),
-	)
-
-	ctx.InMsgs = make(chan *server.Msg, 128)
-	for _, s := range subscribe {
-		err := ctx.Subscribe(s)
+	if ctx.Transport == nil {
+		ctx.Transport, err = NewTransport(ctx.Config)
 		if err != nil {
 			return err
 		}
 	}
 
-	if err == nil {
-		for _, r := range register {
-			ctx.Register(r)
+	ctx.InMsgs = make(chan *Msg, 128)
+	err = ctx.Transport.Connect(username, ctx.InMsgs)
+	if err != nil {
+		return err
+	}
+
+	for i, s := range subscribe {
+		if contains(subscribe[:i], s) {
+			continue
+		}
+		if err := ctx.Subscribe(s); err != nil {
+			ctx.Transport.Close()
+			return err
+		}
+	}
+	for _, r := range register {
+		ctx.Register(r)
+	}
+
+	ctx.Connected.Set(true)
+	go func() {
+		for ctx.Connected.IsTrue() {
+			time.Sleep(time.Duration(2) * time.Second)
+			ctx.InMsgs <- ctx.Tick
 		}
+	}()
+	return nil
+}
 
-		ctx.Connected.Set(true)
-		go func() {
-			for ctx.Connected.IsTrue() {
-				time.Sleep(time.Duration(2) * time.Second)
-				ctx.InMsgs <- ctx.Tick
-			}
-		}()
+func contains(channels []string, channel string) bool {
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
 	}
+	return false
+}
 
-	return err
+// Drain stops receiving messages, see Transport.Drain
+func (ctx *Context) Drain() error {
+	ctx.Connected.Set(false)
+	return ctx.Transport.Drain()
 }
 
 func (ctx *Context) Close() {
 	ctx.Connected.Set(false)
-	ctx.Client.Close()
-	ctx.Client = nil
+	ctx.Transport.Close()
 	time.Sleep(1)
 }
 
+// subject returns the subject of a registered or subscribed channel
+func (ctx *Context) subject(channel string) (string, bool) {
+	ctx.mutex.RLock()
+	defer ctx.mutex.RUnlock()
+	index, exists := ctx.SubToIndex[channel]
+	if exists {
+		return ctx.SubChannels[index], true
+	}
+	return "", false
+}
+
 func (ctx *Context) Register(channel string) error {
+	ctx.mutex.Lock()
+	defer ctx.mutex.Unlock()
 	_, exists := ctx.SubToIndex[channel]
 	if !exists {
-		subschannel := strings.Replace(channel, "/", ".", -1)
-		subschannel = strings.TrimSuffix(subschannel, ".")
+		subschannel := subjectOf(channel)
 		index := len(ctx.SubChannels)
 		ctx.SubToIndex[channel] = index
-		ctx.Subscriptions = append(ctx.Subscriptions, nil)
 		ctx.SubChannels = append(ctx.SubChannels, subschannel)
 	}
 	return nil
 }
 
 func (ctx *Context) Subscribe(channel string) (err error) {
+	ctx.mutex.Lock()
+	defer ctx.mutex.Unlock()
 	_, exists := ctx.SubToIndex[channel]
 	if !exists {
-		subschannel := strings.Replace(channel, "/", ".", -1)
-		subschannel = strings.TrimSuffix(subschannel, ".")
-		subscription, err := ctx.Client.ChanSubscribe(subschannel, ctx.InMsgs)
+		subschannel := subjectOf(channel)
+		err := ctx.Transport.Subscribe(subschannel)
 		index := len(ctx.SubChannels)
 		ctx.SubToIndex[channel] = index
-		ctx.Subscriptions = append(ctx.Subscriptions, subscription)
 		ctx.SubChannels = append(ctx.SubChannels, subschannel)
 		return err
 	}
 	return fmt.Errorf("PubSub.Subscribe failed for channel %s", channel)
 }
 
-func (ctx *Context) PublishStr(channel string, message string) error {
-	index, exists := ctx.SubToIndex[channel]
-	if exists {
-		ctx.Client.Publish(ctx.SubChannels[index], []byte(message))
-		return nil
+func (ctx *Context) publish(msg *Msg) error {
+	if ctx.OnPublish != nil {
+		ctx.OnPublish(msg)
 	}
-	return fmt.Errorf("PubSub.Publish failed for channel %s", channel)
+	return ctx.Transport.Publish(msg)
+}
+
+func (ctx *Context) PublishStr(channel string, message string) error {
+	return ctx.Publish(channel, []byte(message))
 }
 
 func (ctx *Context) Publish(channel string, message []byte) error {
-	index, exists := ctx.SubToIndex[channel]
+	subject, exists := ctx.subject(channel)
 	if exists {
-		ctx.Client.Publish(ctx.SubChannels[index], message)
+		ctx.publish(&Msg{Subject: subject, Data: message})
 		return nil
 	}
 	return fmt.Errorf("PubSub.Publish failed for channel %s", channel)
 }
 
 func (ctx *Context) PublishTTLStr(channel string, message string, ttl int) error {
-	index, exists := ctx.SubToIndex[channel]
+	return ctx.PublishTTL(channel, []byte(message), ttl)
+}
+
+// PublishTTL publishes a message that expires after 'ttl' seconds, receivers drop the
+// message when it is older. A 'ttl' of 0 or less publishes a message that does not expire.
+func (ctx *Context) PublishTTL(channel string, message []byte, ttl int) error {
+	subject, exists := ctx.subject(channel)
 	if exists {
-		ctx.Client.Publish(ctx.SubChannels[index], []byte(message))
+		msg := &Msg{Subject: subject, Data: message}
+		if ttl > 0 {
+			msg.Expires = time.Now().Add(time.Duration(ttl) * time.Second)
+		}
+		ctx.publish(msg)
 		return nil
 	}
 	return fmt.Errorf("PubSub.PublishTTL failed for channel %s", channel)
 }
 
-func (ctx *Context) PublishTTL(channel string, message []byte, ttl int) error {
-	index, exists := ctx.SubToIndex[channel]
+// Request publishes a request on a registered channel and waits at most 'timeout' for
+// the reply of one of the subscribers, the request expires after RequestTTL.
+func (ctx *Context) Request(channel string, message []byte, timeout time.Duration) ([]byte, error) {
+	subject, exists := ctx.subject(channel)
 	if exists {
-		ctx.Client.Publish(ctx.SubChannels[index], message)
-		return nil
+		msg := &Msg{Subject: subject, Data: message, Expires: time.Now().Add(RequestTTL)}
+		if ctx.OnPublish != nil {
+			ctx.OnPublish(msg)
+		}
+		return ctx.Transport.Request(msg, timeout)
 	}
-	return fmt.Errorf("PubSub.PublishTTL failed for channel %s", channel)
+	return nil, fmt.Errorf("PubSub.Request failed for channel %s", channel)
+}
+
+// Respond sends 'message' as the reply on a received request
+func (ctx *Context) Respond(msg *Msg, message []byte) error {
+	if msg.Reply == "" {
+		return fmt.Errorf("PubSub.Respond failed, %s is not a request", msg.Subject)
+	}
+	return ctx.publish(&Msg{Subject: msg.Reply, Data: message})
 }
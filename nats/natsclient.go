@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	server "github.com/nats-io/nats.go"
+)
+
+// NatsTransport is the Transport on top of a NATS server, the configuration holds the
+// "host" (e.g. tcp://10.0.0.22:4222) and how to authenticate:
+//
+//   - "credentials", a directory with the identity of every service that busauth
+//     generated, "<name>.nk" (an NKey seed) or "<name>.password"
+//   - "nkey", a file with an NKey seed
+//   - "user" and "password"
+//   - "secret", a token
+//
+// "tls_ca" is the certificate of the CA of the server, "tls_cert" and "tls_key" are
+// the certificate and key of the client when the server verifies them. The NATS client
+// reconnects by itself and restores the subscriptions, also when the server is not
+// running at startup.
+type NatsTransport struct {
+	Config map[string]string
+	Conn   *server.Conn
+	inmsgs chan *Msg
+}
+
+func NewNatsTransport(config map[string]string) *NatsTransport {
+	return &NatsTransport{Config: config}
+}
+
+func (t *NatsTransport) Connect(name string, inmsgs chan *Msg) error {
+	t.inmsgs = inmsgs
+	options, err := t.options(name)
+	if err != nil {
+		return err
+	}
+
+	t.Conn, err = server.Connect(t.Config["host"], append(options,
+		server.Name(name),
+		server.RetryOnFailedConnect(true),
+		server.MaxReconnects(-1),
+		server.ReconnectWait(2*time.Second),
+		server.ConnectHandler(func(nc *server.Conn) {
+			inmsgs <- &Msg{Subject: "client/connected/", Data: []byte(nc.ConnectedUrl())}
+		}),
+		server.DisconnectErrHandler(func(nc *server.Conn, err error) {
+			inmsgs <- &Msg{Subject: "client/disconnected/"}
+		}),
+		server.ReconnectHandler(func(nc *server.Conn) {
+			inmsgs <- &Msg{Subject: "client/reconnected/", Data: []byte(nc.ConnectedUrl())}
+		}),
+		server.ClosedHandler(func(nc *server.Conn) {
+			inmsgs <- &Msg{Subject: "client/closed/"}
+		}),
+	)...)
+	return err
+}
+
+// options returns the authentication and TLS options of the service 'name'
+func (t *NatsTransport) options(name string) ([]server.Option, error) {
+	options := []server.Option{}
+	if dir := t.Config["credentials"]; dir != "" {
+		seed := filepath.Join(dir, name+".nk")
+		password := filepath.Join(dir, name+".password")
+		if _, err := os.Stat(seed); err == nil {
+			nkey, err := server.NkeyOptionFromSeed(seed)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, nkey)
+		} else if data, err := ioutil.ReadFile(password); err == nil {
+			options = append(options, server.UserInfo(name, strings.TrimSpace(string(data))))
+		} else {
+			return nil, fmt.Errorf("no credentials for %s in %s", name, dir)
+		}
+		// the services can only subscribe to their own inbox
+		options = append(options, server.CustomInboxPrefix(InboxPrefix(name)))
+	} else if seed := t.Config["nkey"]; seed != "" {
+		nkey, err := server.NkeyOptionFromSeed(seed)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, nkey)
+	} else if user := t.Config["user"]; user != "" {
+		options = append(options, server.UserInfo(user, t.Config["password"]))
+	} else if token := t.Config["secret"]; token != "" {
+		options = append(options, server.Token(token))
+	}
+
+	if ca := t.Config["tls_ca"]; ca != "" {
+		options = append(options, server.RootCAs(ca))
+	}
+	if cert := t.Config["tls_cert"]; cert != "" {
+		options = append(options, server.ClientCert(cert, t.Config["tls_key"]))
+	}
+	return options, nil
+}
+
+// InboxPrefix is the prefix of the reply subjects of the requests of service 'name'
+// when it connects with its own credentials
+func InboxPrefix(name string) string {
+	return "_INBOX_" + strings.Replace(name, ".", "_", -1)
+}
+
+func (t *NatsTransport) Subscribe(subject string) error {
+	_, err := t.Conn.Subscribe(subject, func(msg *server.Msg) {
+		received := &Msg{Subject: msg.Subject, Reply: msg.Reply, Data: msg.Data}
+		if expires := msg.Header.Get(ExpiresHeader); expires != "" {
+			received.Expires, _ = time.Parse(time.RFC3339Nano, expires)
+		}
+		t.inmsgs <- received
+	})
+	return err
+}
+
+// natsMsg converts a message to a NATS message, the expiry is carried as a header
+func natsMsg(msg *Msg) *server.Msg {
+	m := &server.Msg{Subject: msg.Subject, Reply: msg.Reply, Data: msg.Data}
+	if !msg.Expires.IsZero() {
+		m.Header = server.Header{}
+		m.Header.Set(ExpiresHeader, msg.Expires.UTC().Format(time.RFC3339Nano))
+	}
+	return m
+}
+
+func (t *NatsTransport) Publish(msg *Msg) error {
+	return t.Conn.PublishMsg(natsMsg(msg))
+}
+
+func (t *NatsTransport) Request(msg *Msg, timeout time.Duration) ([]byte, error) {
+	reply, err := t.Conn.RequestMsg(natsMsg(msg), timeout)
+	if err == server.ErrNoResponders {
+		return nil, ErrNoResponders
+	} else if err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+func (t *NatsTransport) Drain() error {
+	return t.Conn.Drain()
+}
+
+func (t *NatsTransport) Close() {
+	if t.Conn != nil {
+		t.Conn.Close()
+		t.Conn = nil
+	}
+}
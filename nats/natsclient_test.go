@@ -0,0 +1,54 @@
+package pubsub
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	server "github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+func applied(t *testing.T, config map[string]string, name string) server.Options {
+	options, err := NewNatsTransport(config).options(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := server.GetDefaultOptions()
+	for _, option := range options {
+		if err := option(&opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return opts
+}
+
+func TestNatsCredentials(t *testing.T) {
+	dir := t.TempDir()
+	user, _ := nkeys.CreateUser()
+	seed, _ := user.Seed()
+	public, _ := user.PublicKey()
+	ioutil.WriteFile(filepath.Join(dir, "aqi.nk"), seed, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "samsung.tv.password"), []byte("s3cr3t\n"), 0600)
+
+	opts := applied(t, map[string]string{"credentials": dir, "secret": "token"}, "aqi")
+	if opts.Nkey != public || opts.Token != "" || opts.InboxPrefix != "_INBOX_aqi" {
+		t.Errorf("credentials of aqi = nkey %s, token %s, inbox %s", opts.Nkey, opts.Token, opts.InboxPrefix)
+	}
+	opts = applied(t, map[string]string{"credentials": dir}, "samsung.tv")
+	if opts.User != "samsung.tv" || opts.Password != "s3cr3t" || opts.InboxPrefix != "_INBOX_samsung_tv" {
+		t.Errorf("credentials of samsung.tv = user %s, password %s, inbox %s", opts.User, opts.Password, opts.InboxPrefix)
+	}
+	if _, err := NewNatsTransport(map[string]string{"credentials": dir}).options("shout"); err == nil {
+		t.Errorf("shout has no credentials but options() succeeded")
+	}
+
+	opts = applied(t, map[string]string{"user": "automation", "password": "pw"}, "automation")
+	if opts.User != "automation" || opts.Password != "pw" || opts.InboxPrefix != "" {
+		t.Errorf("user of automation = %s, password %s, inbox %s", opts.User, opts.Password, opts.InboxPrefix)
+	}
+	opts = applied(t, map[string]string{"secret": "token"}, "aqi")
+	if opts.Token != "token" {
+		t.Errorf("token of aqi = %s", opts.Token)
+	}
+}
@@ -0,0 +1,69 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoResponders is returned by a request on a subject that nobody is subscribed to
+var ErrNoResponders = errors.New("PubSub.Request failed, no responders")
+
+// ExpiresHeader is the header that carries the expiry of a message (RFC 3339, UTC)
+const ExpiresHeader = "Go-Home-Expires"
+
+// RequestTTL is the lifetime of a request, a request that is older is not handled anymore
+var RequestTTL = time.Minute
+
+// Msg is a message as it is published on or received from a transport, Reply is the
+// subject to send the reply to when the message is a request. A message with an Expires
+// time should not be handled after that time.
+type Msg struct {
+	Subject string
+	Reply   string
+	Data    []byte
+	Expires time.Time
+}
+
+// Expired returns true when the message has an expiry time that has passed
+func (m *Msg) Expired(now time.Time) bool {
+	return !m.Expires.IsZero() && now.After(m.Expires)
+}
+
+// Transport is the message bus underneath a Context. Subjects are NATS subjects like
+// 'state.light.kitchen', a transport with another topic syntax converts them.
+type Transport interface {
+	// Connect connects to the bus as 'name', received messages and client status messages
+	// are send to 'inmsgs'. A transport that reconnects by itself keeps its subscriptions
+	// and sends 'client/disconnected/' and 'client/reconnected/', 'client/closed/' is send
+	// when the connection is lost for good.
+	Connect(name string, inmsgs chan *Msg) error
+	Subscribe(subject string) error
+	Publish(msg *Msg) error
+	Request(msg *Msg, timeout time.Duration) ([]byte, error)
+	// Drain stops the subscriptions, messages that were already received are still send
+	// to 'inmsgs' and 'client/closed/' is send when it is done.
+	Drain() error
+	Close()
+}
+
+// NewTransport returns the transport that is selected by the "transport" entry of the
+// configuration, "nats" (default), "mqtt" or "memory".
+func NewTransport(config map[string]string) (Transport, error) {
+	switch config["transport"] {
+	case "", "nats":
+		return NewNatsTransport(config), nil
+	case "mqtt":
+		return NewMqttTransport(config), nil
+	case "memory":
+		return DefaultBroker.NewTransport(), nil
+	}
+	return nil, fmt.Errorf("PubSub transport '%s' is not supported", config["transport"])
+}
+
+// subjectOf converts a channel like 'state/light/kitchen/' to a subject
+func subjectOf(channel string) string {
+	subject := strings.Replace(channel, "/", ".", -1)
+	return strings.TrimSuffix(subject, ".")
+}
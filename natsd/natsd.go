@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jurgen-kluft/go-home/config"
+	logpkg "github.com/jurgen-kluft/go-home/logging"
+	"github.com/jurgen-kluft/go-home/natsserver"
+	"github.com/urfave/cli"
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "natsd"
+	app.Usage = "run the embedded NATS server of go-home"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "config", Value: "natsd.config.json", Usage: "the natsd configuration file"},
+	}
+	app.Action = func(c *cli.Context) error {
+		logger := logpkg.New(app.Name)
+		logger.AddEntry(app.Name)
+
+		data, err := ioutil.ReadFile(c.String("config"))
+		if err != nil {
+			return err
+		}
+		cfg, err := config.NatsdConfigFromJSON(data)
+		if err != nil {
+			return err
+		}
+		s, err := natsserver.Start(cfg)
+		if err != nil {
+			return err
+		}
+		logger.LogInfo(app.Name, "nats server listening on "+s.ClientURL())
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		logger.LogInfo(app.Name, "nats server shutting down")
+		s.Shutdown()
+		s.WaitForShutdown()
+		return nil
+	}
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+// Package natsserver runs an embedded NATS server, services reach it like any other
+// NATS server through a pubsub.Context with the "nats" transport.
+package natsserver
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// DefaultListen is the address the server listens on when the configuration has none
+const DefaultListen = "127.0.0.1:4222"
+
+// Options converts a NatsdConfig to the options of a NATS server
+func Options(cfg *config.NatsdConfig) (*server.Options, error) {
+	listen := cfg.Listen
+	if listen == "" {
+		listen = DefaultListen
+	}
+	host, portstr, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("listen address %q: %v", listen, err)
+	}
+	port, err := strconv.Atoi(portstr)
+	if err != nil {
+		return nil, fmt.Errorf("listen address %q: %v", listen, err)
+	}
+
+	opts := &server.Options{
+		ServerName: "go-home",
+		Host:       host,
+		Port:       port,
+		NoSigs:     true,
+	}
+
+	token := ""
+	if cfg.Token != nil {
+		token = cfg.Token.String
+	}
+	configured := 0
+	for _, set := range []bool{token != "", len(cfg.NKeys) > 0, cfg.Authorization != ""} {
+		if set {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return nil, fmt.Errorf("configure either a token, nkeys or an authorization file")
+	}
+	if token != "" {
+		opts.Authorization = token
+	}
+	for _, nkey := range cfg.NKeys {
+		opts.Nkeys = append(opts.Nkeys, &server.NkeyUser{Nkey: nkey})
+	}
+	if cfg.Authorization != "" {
+		auth, err := server.ProcessConfigFile(cfg.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		opts.Nkeys = auth.Nkeys
+		opts.Users = auth.Users
+	}
+
+	if cfg.TLSCert != "" {
+		tc, err := server.GenTLSConfig(&server.TLSConfigOpts{CertFile: cfg.TLSCert, KeyFile: cfg.TLSKey})
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS = true
+		opts.TLSConfig = tc
+	}
+
+	if cfg.JetStreamDir != "" {
+		opts.JetStream = true
+		opts.StoreDir = cfg.JetStreamDir
+	}
+	return opts, nil
+}
+
+// Start starts an embedded NATS server and returns when it accepts connections
+func Start(cfg *config.NatsdConfig) (*server.Server, error) {
+	opts, err := Options(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s, err := server.NewServer(opts)
+	if err != nil {
+		return nil, err
+	}
+	s.ConfigureLogger()
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		s.Shutdown()
+		return nil, fmt.Errorf("nats server on %s:%d is not ready for connections", opts.Host, opts.Port)
+	}
+	return s, nil
+}
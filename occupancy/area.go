@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/bayesian"
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+// observation is a config.OccupancyObservation that is an input of the bayesian sensor of an area
+type observation struct {
+	id        int64
+	topic     string
+	name      string
+	attribute string
+	value     string
+	hold      time.Duration
+	halfLife  time.Duration
+	matched   bool
+	lastMatch time.Time
+}
+
+// area is a room or the whole home with a bayesian sensor that tells if it is occupied
+type area struct {
+	name         string
+	bayes        *bayesian.Instance
+	observations []*observation
+	probability  float64
+	occupied     bool
+	computed     bool
+}
+
+func newArea(cfg config.OccupancyArea) (*area, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("area without a name")
+	}
+	a := &area{name: cfg.Name, bayes: bayesian.New(cfg.Prior, cfg.Threshold)}
+	for i, oc := range cfg.Observations {
+		o := &observation{id: int64(i), topic: microservice.NormalizeTopic(oc.Topic), name: oc.Name, attribute: oc.Attribute, value: oc.Value}
+		if oc.Hold != "" {
+			hold, err := time.ParseDuration(oc.Hold)
+			if err != nil {
+				return nil, fmt.Errorf("area '%s', observation '%s', %s", a.name, oc.Name, err.Error())
+			}
+			o.hold = hold
+		}
+		if oc.HalfLife != "" {
+			halfLife, err := time.ParseDuration(oc.HalfLife)
+			if err != nil {
+				return nil, fmt.Errorf("area '%s', observation '%s', %s", a.name, oc.Name, err.Error())
+			}
+			o.halfLife = halfLife
+		}
+		a.bayes.AddDecayingInput(o.id, true, oc.ProbGivenTrue, oc.ProbGivenFalse, o.halfLife)
+		a.observations = append(a.observations, o)
+	}
+	return a, nil
+}
+
+// attributeValue returns the value of a string or bool attribute as a string
+func attributeValue(state *config.SensorState, name string) (string, bool) {
+	for _, sa := range state.StringAttrs {
+		if sa.Name == name {
+			return sa.Value, true
+		}
+	}
+	for _, ba := range state.BoolAttrs {
+		if ba.Name == name {
+			return strconv.FormatBool(ba.Value), true
+		}
+	}
+	return "", false
+}
+
+// observe updates the observations that match the sensor state
+func (a *area) observe(topic string, state *config.SensorState, now time.Time) {
+	topic = microservice.NormalizeTopic(topic)
+	for _, o := range a.observations {
+		if (o.topic != "" && o.topic != topic) || o.name != state.Name {
+			continue
+		}
+		value, exists := attributeValue(state, o.attribute)
+		if !exists {
+			continue
+		}
+		if o.hold == 0 {
+			o.matched = value == o.value
+		} else if value == o.value {
+			// An event, like motion, is only true for 'hold' after it happened
+			o.lastMatch = now
+		}
+	}
+}
+
+// update computes the probability that the area is occupied, it returns true when
+// the area changed from occupied to unoccupied (or vice versa).
+func (a *area) update(now time.Time) bool {
+	for _, o := range a.observations {
+		if o.hold > 0 {
+			state := !o.lastMatch.IsZero() && now.Sub(o.lastMatch) < o.hold
+			a.bayes.SetInputStateAt(o.id, state, o.lastMatch)
+		} else {
+			a.bayes.SetInputState(o.id, o.matched)
+		}
+	}
+	occupied := a.bayes.ReadStateAt(now)
+	a.probability = a.bayes.Probability()
+	changed := !a.computed || occupied != a.occupied
+	a.occupied = occupied
+	a.computed = true
+	return changed
+}
+
+// explain describes which observations contributed to the probability, e.g. to tell why
+// the home is thought to be empty.
+func (a *area) explain() string {
+	reasons := []string{}
+	for _, c := range a.bayes.Contributions() {
+		if c.Active {
+			o := a.observations[c.ID]
+			reasons = append(reasons, fmt.Sprintf("%s %s=%s (weight %.2f, without it %.2f)", o.name, o.attribute, o.value, c.Weight, c.Without))
+		}
+	}
+	if len(reasons) == 0 {
+		return "nothing observed"
+	}
+	return strings.Join(reasons, ", ")
+}
+
+func (a *area) sensorState(now time.Time) *config.SensorState {
+	sensor := config.NewSensorState(a.name, "occupancy")
+	sensor.Time = now
+	sensor.AddFloatAttr("probability", a.probability)
+	sensor.AddBoolAttr("occupied", a.occupied)
+	return sensor
+}
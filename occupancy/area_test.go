@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+)
+
+func TestAreaOccupancy(t *testing.T) {
+	cfg := config.OccupancyArea{
+		Name:      "home",
+		Prior:     0.5,
+		Threshold: 0.8,
+		Observations: []config.OccupancyObservation{
+			{Topic: "state/presence/", Name: "state.presence", Attribute: "Jurgen", Value: "home", ProbGivenTrue: 0.9, ProbGivenFalse: 0.2},
+			{Name: "Kitchen Motion", Attribute: "motion", Value: "on", Hold: "10m", ProbGivenTrue: 0.7, ProbGivenFalse: 0.1},
+		},
+	}
+	a, err := newArea(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+	if a.update(now); a.occupied || a.probability != 0.5 {
+		t.Errorf("without observations occupied = %v (%v), want false (0.5)", a.occupied, a.probability)
+	}
+
+	presence := config.NewSensorState("state.presence", "presence")
+	presence.AddStringAttr("Jurgen", "home")
+	a.observe("state.presence", presence, now)
+	if changed := a.update(now); !changed || !a.occupied {
+		t.Errorf("when at home occupied = %v (%v), want true", a.occupied, a.probability)
+	}
+
+	presence.StringAttrs[0].Value = "away"
+	a.observe("state.presence", presence, now)
+	motion := config.NewSensorState("Kitchen Motion", "sensor.motion")
+	motion.AddStringAttr("motion", "on")
+	a.observe("state.sensor.xiaomi", motion, now)
+	motion.StringAttrs[0].Value = "off"
+	a.observe("state.sensor.xiaomi", motion, now.Add(time.Minute))
+	if a.update(now.Add(5 * time.Minute)); !a.occupied {
+		t.Errorf("after motion occupied = %v (%v), want true", a.occupied, a.probability)
+	}
+	if changed := a.update(now.Add(11 * time.Minute)); !changed || a.occupied {
+		t.Errorf("after the motion hold occupied = %v (%v), want false", a.occupied, a.probability)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+// occupancy publishes the probability that the home and its rooms are occupied by
+// combining presence, motion, doors and calendar states with a bayesian sensor per area.
+type occupancy struct {
+	config *config.OccupancyConfig
+	areas  []*area
+}
+
+func new() *occupancy {
+	return &occupancy{}
+}
+
+// initialize creates the areas from the given JSON configuration, the home is the first area
+func (o *occupancy) initialize(jsondata []byte) (err error) {
+	cfg, err := config.OccupancyConfigFromJSON(jsondata)
+	if err != nil {
+		return err
+	}
+	areas := []*area{}
+	for _, ac := range append([]config.OccupancyArea{cfg.Home}, cfg.Rooms...) {
+		a, err := newArea(ac)
+		if err != nil {
+			return err
+		}
+		areas = append(areas, a)
+	}
+	o.config = cfg
+	o.areas = areas
+	return nil
+}
+
+// handleState feeds a received sensor state into the observations of all areas
+func (o *occupancy) handleState(topic string, state *config.SensorState, now time.Time) {
+	for _, a := range o.areas {
+		a.observe(topic, state, now)
+	}
+}
+
+// update returns the sensor states of the areas, all of them when 'all' is true and
+// otherwise only the ones that changed.
+func (o *occupancy) update(m *microservice.Service, now time.Time, all bool) []*config.SensorState {
+	states := []*config.SensorState{}
+	for _, a := range o.areas {
+		changed := a.update(now)
+		if changed {
+			m.Logger.LogInfo(m.Name, fmt.Sprintf("%s occupied=%v (%.2f): %s", a.name, a.occupied, a.probability, a.explain()))
+		}
+		if changed || all {
+			states = append(states, a.sensorState(now))
+		}
+	}
+	return states
+}
+
+func (o *occupancy) publish(m *microservice.Service, states []*config.SensorState) {
+	for _, state := range states {
+		jsonbytes, err := state.ToJSON()
+		if err == nil {
+			m.Pubsub.Publish(o.config.Channel, jsonbytes)
+		} else {
+			m.Logger.LogError(m.Name, err.Error())
+		}
+	}
+}
+
+func main() {
+	occupancy := new()
+
+	register := []string{"config/request/"}
+	subscribe := []string{"config/occupancy/"}
+
+	m := microservice.New("occupancy")
+	m.RegisterAndSubscribe(register, subscribe)
+
+	m.RegisterHandler("config/occupancy/", func(m *microservice.Service, topic string, msg []byte) bool {
+		m.Logger.LogInfo(m.Name, "received configuration")
+		err := occupancy.initialize(msg)
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		if err = m.Pubsub.Register(occupancy.config.Channel); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		}
+		for _, ss := range occupancy.config.SubChannels {
+			if err := m.Pubsub.Subscribe(ss); err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+			}
+		}
+		return true
+	})
+
+	m.RegisterHandler("*", func(m *microservice.Service, topic string, msg []byte) bool {
+		if strings.HasPrefix(topic, "state") && occupancy.config != nil {
+			state, err := config.SensorStateFromJSON(msg)
+			if err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+				return true
+			}
+			now := time.Now()
+			occupancy.handleState(topic, state, now)
+			occupancy.publish(m, occupancy.update(m, now, false))
+		}
+		return true
+	})
+
+	tickCount := 0
+	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
+		if tickCount%5 == 0 { // every 10 seconds
+			if occupancy.config == nil {
+				m.Pubsub.PublishStr("config/request/", m.Name)
+			} else {
+				// Publish all areas every minute, otherwise only the ones that changed
+				occupancy.publish(m, occupancy.update(m, time.Now(), tickCount%30 == 0))
+			}
+		}
+		tickCount++
+		return true
+	})
+
+	m.Loop()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+	"github.com/jurgen-kluft/go-home/recording"
+)
+
+// recorder writes every message on the bus to rotating compressed files so that the
+// exact sequence of messages can be looked at or replayed (see gohomectl replay).
+type recorder struct {
+	config *config.RecorderConfig
+	writer *recording.Writer
+}
+
+func (r *recorder) configure(m *microservice.Service, cfg *config.RecorderConfig) {
+	if cfg.RotateMinutes <= 0 {
+		cfg.RotateMinutes = 60
+	}
+	rotate := time.Duration(cfg.RotateMinutes) * time.Minute
+	if r.writer != nil && r.writer.Dir == cfg.Dir {
+		r.writer.Rotate = rotate
+		r.writer.Keep = cfg.KeepFiles
+	} else {
+		r.close(m)
+		r.writer = recording.NewWriter(cfg.Dir, rotate, cfg.KeepFiles)
+	}
+	if r.config == nil {
+		for _, channel := range cfg.SubChannels {
+			if err := m.Subscribe(channel); err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+			}
+		}
+		m.Logger.LogInfo(m.Name, "recording to "+cfg.Dir)
+	}
+	r.config = cfg
+}
+
+func (r *recorder) close(m *microservice.Service) {
+	if r.writer != nil {
+		if err := r.writer.Close(); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		}
+	}
+}
+
+func main() {
+	r := &recorder{}
+
+	register := []string{"config/request/"}
+	subscribe := []string{"config/recorder/"}
+
+	m := microservice.New("recorder")
+	m.RegisterAndSubscribe(register, subscribe)
+
+	m.RegisterHandler("config/recorder/", func(m *microservice.Service, topic string, msg []byte) bool {
+		m.Logger.LogInfo(m.Name, "received configuration")
+		cfg, err := config.RecorderConfigFromJSON(msg)
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		r.configure(m, cfg)
+		return true
+	})
+
+	m.RegisterHandler("*", func(m *microservice.Service, topic string, msg []byte) bool {
+		if r.writer != nil {
+			record := recording.NewRecord(&pubsub.Msg{Subject: topic, Data: msg, Expires: m.MessageExpires}, time.Now())
+			if err := r.writer.Write(record); err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+			}
+		}
+		return true
+	})
+
+	tickCount := 0
+	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
+		if tickCount%5 == 0 {
+			if r.config == nil {
+				m.Pubsub.PublishStr("config/request/", m.Name)
+			} else if err := r.writer.Flush(); err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+			}
+		}
+		tickCount++
+		return true
+	})
+
+	m.Loop()
+	r.close(m)
+}
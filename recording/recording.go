@@ -0,0 +1,213 @@
+package recording
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+// Record is a message as it was received from the bus, Payload is the data as text since
+// the messages of go-home are JSON or plain text.
+type Record struct {
+	Time    time.Time  `json:"time"`
+	Subject string     `json:"subject"`
+	Payload string     `json:"payload"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// NewRecord returns the record of a message that was received at 'now'
+func NewRecord(msg *pubsub.Msg, now time.Time) *Record {
+	r := &Record{Time: now, Subject: msg.Subject, Payload: string(msg.Data)}
+	if !msg.Expires.IsZero() {
+		expires := msg.Expires
+		r.Expires = &expires
+	}
+	return r
+}
+
+const (
+	filePrefix = "bus-"
+	fileSuffix = ".jsonl.gz"
+	timeFormat = "20060102T150405Z"
+)
+
+// Writer writes records to gzip compressed files in Dir, one JSON object per line. A new
+// file is started every Rotate and only the last Keep files are kept (0 keeps all).
+type Writer struct {
+	Dir     string
+	Rotate  time.Duration
+	Keep    int
+	file    *os.File
+	zipper  *gzip.Writer
+	encoder *json.Encoder
+	started time.Time
+}
+
+func NewWriter(dir string, rotate time.Duration, keep int) *Writer {
+	return &Writer{Dir: dir, Rotate: rotate, Keep: keep}
+}
+
+func (w *Writer) Write(r *Record) error {
+	start := r.Time.UTC().Truncate(w.Rotate)
+	if w.file == nil || !start.Equal(w.started) {
+		if err := w.open(start); err != nil {
+			return err
+		}
+	}
+	return w.encoder.Encode(r)
+}
+
+func (w *Writer) open(start time.Time) error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		return err
+	}
+	filename := filepath.Join(w.Dir, filePrefix+start.Format(timeFormat)+fileSuffix)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.zipper = gzip.NewWriter(file)
+	w.encoder = json.NewEncoder(w.zipper)
+	w.started = start
+	return w.prune()
+}
+
+// Flush writes the buffered records to the file, a file that was flushed can be read
+// while it is still being written.
+func (w *Writer) Flush() error {
+	if w.zipper == nil {
+		return nil
+	}
+	return w.zipper.Flush()
+}
+
+func (w *Writer) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.zipper.Close()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file, w.zipper, w.encoder = nil, nil, nil
+	return err
+}
+
+// prune removes the oldest files when there are more than Keep
+func (w *Writer) prune() error {
+	if w.Keep <= 0 {
+		return nil
+	}
+	files, err := recordingFiles(w.Dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > w.Keep {
+		if err = os.Remove(files[0].name); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+type recordingFile struct {
+	name    string
+	started time.Time
+}
+
+// recordingFiles returns the files of a recording in 'path', oldest first, 'path' is a
+// directory that is written by a Writer or a single file.
+func recordingFiles(path string) ([]recordingFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []recordingFile{{name: path}}, nil
+	}
+	names, err := filepath.Glob(filepath.Join(path, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	files := []recordingFile{}
+	for _, name := range names {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), filePrefix), fileSuffix)
+		started, err := time.Parse(timeFormat, stamp)
+		if err == nil {
+			files = append(files, recordingFile{name: name, started: started})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].started.Before(files[j].started) })
+	return files, nil
+}
+
+// Read returns the records in 'path' that were received between 'from' and 'to', 'path'
+// is a directory that is written by a Writer or a single file (compressed when its name
+// ends with .gz). A file that ends in the middle of a record, e.g. the file that is
+// still being written, is read up to that record.
+func Read(path string, from time.Time, to time.Time) ([]*Record, error) {
+	files, err := recordingFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	records := []*Record{}
+	for i, f := range files {
+		if !f.started.IsZero() && f.started.After(to) {
+			break
+		}
+		if i+1 < len(files) && !files[i+1].started.IsZero() && !files[i+1].started.After(from) {
+			continue
+		}
+		if records, err = readFile(f.name, from, to, records); err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+func readFile(filename string, from time.Time, to time.Time, records []*Record) ([]*Record, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return records, err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(filename, ".gz") {
+		zipped, err := gzip.NewReader(file)
+		if err != nil {
+			return records, err
+		}
+		defer zipped.Close()
+		reader = zipped
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			// the last line of a file that was not closed can be incomplete
+			break
+		}
+		if !r.Time.Before(from) && !r.Time.After(to) {
+			records = append(records, r)
+		}
+	}
+	if err = scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+		return records, err
+	}
+	return records, nil
+}
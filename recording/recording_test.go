@@ -0,0 +1,104 @@
+package recording
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir, time.Hour, 2)
+	start := time.Date(2019, 3, 4, 1, 30, 0, 0, time.UTC)
+	for i := 0; i < 4*60; i++ {
+		msg := &pubsub.Msg{Subject: "state.light.conbee", Data: []byte(`{"name": "Bedroom Main"}`)}
+		if i == 90 {
+			msg.Expires = start.Add(91 * time.Minute)
+		}
+		if err := w.Write(NewRecord(msg, start.Add(time.Duration(i)*time.Minute))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the oldest files are removed and the last file can be read while it is written
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 || filepath.Base(files[0]) != "bus-20190304T040000Z.jsonl.gz" {
+		t.Fatalf("files = %v", files)
+	}
+	records, err := Read(dir, start.Add(150*time.Minute), start.Add(210*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 61 || !records[0].Time.Equal(start.Add(150*time.Minute)) || records[0].Payload != `{"name": "Bedroom Main"}` {
+		t.Errorf("Read() = %d records, first %+v", len(records), records[0])
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file that ends in the middle of a record is read up to that record
+	w = NewWriter(filepath.Join(dir, "expires"), time.Hour, 0)
+	w.Write(NewRecord(&pubsub.Msg{Subject: "state.sensor.sun", Expires: start.Add(time.Minute)}, start))
+	w.Close()
+	records, err = Read(filepath.Join(dir, "expires"), start, start.Add(time.Hour))
+	if err != nil || len(records) != 1 || records[0].Expires == nil || !records[0].Expires.Equal(start.Add(time.Minute)) {
+		t.Errorf("Read() = %v, %v", records, err)
+	}
+	files, _ = filepath.Glob(filepath.Join(dir, "expires", "*"))
+	data, _ := os.ReadFile(files[0])
+	os.WriteFile(files[0], data[:len(data)-12], 0644)
+	if _, err = Read(files[0], start, start.Add(time.Hour)); err != nil {
+		t.Errorf("Read() of a truncated file = %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	broker := pubsub.NewMemoryBroker()
+	subscriber := pubsub.New(map[string]string{})
+	subscriber.Transport = broker.NewTransport()
+	if err := subscriber.Connect("automation", nil, []string{"replay/state/light/conbee/"}); err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+
+	start := time.Date(2019, 3, 4, 3, 0, 0, 0, time.UTC)
+	expires := start.Add(time.Minute)
+	records := []*Record{
+		{Time: start, Subject: "state.light.conbee", Payload: "on"},
+		{Time: start.Add(time.Second), Subject: "state.light.conbee", Payload: "off", Expires: &expires},
+	}
+	replayer := &Replayer{Transport: broker.NewTransport(), Prefix: "replay.", Speed: 10}
+	began := time.Now()
+	if err := replayer.Replay(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(began); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("replaying 1 second at 10x took %v", elapsed)
+	}
+	for _, want := range []string{"on", "off"} {
+		select {
+		case msg := <-subscriber.InMsgs:
+			if msg.Subject != "replay.state.light.conbee" || string(msg.Data) != want {
+				t.Errorf("replayed %+v, want %s", msg, want)
+			}
+			if want == "off" && (msg.Expired(time.Now()) || msg.Expires.After(time.Now().Add(time.Minute))) {
+				t.Errorf("replayed expiry %v, want about a minute from now", msg.Expires)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s was not replayed", want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := replayer.Replay(ctx, records); err == nil {
+		t.Errorf("Replay() with a cancelled context should fail")
+	}
+}
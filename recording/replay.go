@@ -0,0 +1,47 @@
+package recording
+
+import (
+	"context"
+	"time"
+
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+// Replayer publishes recorded messages again on a Transport, e.g. a NATS server or the
+// memory broker of a test that runs automation or flux. The time between the messages
+// is divided by Speed (0 publishes them as fast as possible) and Prefix (e.g. "replay.")
+// is put in front of the subjects so that the replayed messages can be told apart.
+type Replayer struct {
+	Transport pubsub.Transport
+	Prefix    string
+	Speed     float64
+}
+
+// Replay publishes the records until they are all published or 'ctx' is cancelled, a
+// message that was published with a TTL expires as long after it is replayed as it did
+// after it was recorded.
+func (r *Replayer) Replay(ctx context.Context, records []*Record) error {
+	var previous time.Time
+	for _, record := range records {
+		if r.Speed > 0 && !previous.IsZero() {
+			wait := time.Duration(float64(record.Time.Sub(previous)) / r.Speed)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		previous = record.Time
+
+		msg := &pubsub.Msg{Subject: r.Prefix + record.Subject, Data: []byte(record.Payload)}
+		if record.Expires != nil {
+			msg.Expires = time.Now().Add(record.Expires.Sub(record.Time))
+		}
+		if err := r.Transport.Publish(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+// registry keeps track of the services that are alive from their heartbeats, it publishes
+// when a service comes up or goes down and answers requests for the status of the
+// services.
+type registry struct {
+	config   *config.RegistryConfig
+	services *services
+}
+
+func (r *registry) publishTransition(m *microservice.Service, name string, up bool) {
+	status := &microservice.Status{Name: name, Status: "down", Time: time.Now()}
+	if up {
+		status.Status = "up"
+	}
+	m.Logger.LogInfo(m.Name, "service "+name+" is "+status.Status)
+	jsondata, err := json.Marshal(status)
+	if err == nil {
+		err = m.Pubsub.Publish(r.config.TransitionChannel, jsondata)
+	}
+	if err != nil {
+		m.Logger.LogError(m.Name, err.Error())
+	}
+}
+
+func main() {
+	r := &registry{}
+
+	register := []string{"config/request/"}
+	subscribe := []string{"config/registry/"}
+
+	m := microservice.New("registry")
+	m.RegisterAndSubscribe(register, subscribe)
+
+	m.RegisterHandler("config/registry/", func(m *microservice.Service, topic string, msg []byte) bool {
+		m.Logger.LogInfo(m.Name, "received configuration")
+		cfg, err := config.RegistryConfigFromJSON(msg)
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		if cfg.Channel == "" {
+			cfg.Channel = microservice.RegistryChannel
+		}
+		if cfg.TransitionChannel == "" {
+			cfg.TransitionChannel = microservice.TransitionChannel
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = int(3 * microservice.HeartbeatInterval / time.Second)
+		}
+		timeout := time.Duration(cfg.Timeout) * time.Second
+		if r.services == nil {
+			r.services = newServices(timeout)
+			m.Register(cfg.TransitionChannel)
+			m.RegisterReplyHandler(cfg.Channel, func(m *microservice.Service, topic string, request []byte) *microservice.Reply {
+				jsondata, err := json.Marshal(r.services.list(string(request)))
+				if err != nil {
+					return microservice.Failed(err)
+				}
+				return microservice.Succeeded(jsondata)
+			})
+			m.Subscribe(cfg.Channel)
+			m.Subscribe(microservice.HeartbeatChannel)
+			m.Subscribe(microservice.StatusChannel)
+		}
+		r.services.timeout = timeout
+		r.config = cfg
+		return true
+	})
+
+	m.RegisterHandler(microservice.HeartbeatChannel, func(m *microservice.Service, topic string, msg []byte) bool {
+		heartbeat := &microservice.Heartbeat{}
+		if err := json.Unmarshal(msg, heartbeat); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		} else if r.services.heartbeat(heartbeat, time.Now()) {
+			r.publishTransition(m, heartbeat.Name, true)
+		}
+		return true
+	})
+
+	m.RegisterHandler(microservice.StatusChannel, func(m *microservice.Service, topic string, msg []byte) bool {
+		status := &microservice.Status{}
+		if err := json.Unmarshal(msg, status); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		} else if r.services.status(status, time.Now()) {
+			r.publishTransition(m, status.Name, status.Status == "online")
+		}
+		return true
+	})
+
+	tickCount := 0
+	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
+		if tickCount%5 == 0 {
+			if r.config == nil {
+				m.Pubsub.PublishStr("config/request/", m.Name)
+			}
+		}
+		if r.services != nil {
+			for _, name := range r.services.expire(time.Now()) {
+				r.publishTransition(m, name, false)
+			}
+		}
+		tickCount++
+		return true
+	})
+
+	m.Loop()
+}
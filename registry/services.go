@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+// services tracks the heartbeats and the online/offline status of the services
+type services struct {
+	timeout time.Duration
+	entries map[string]*microservice.ServiceEntry
+}
+
+func newServices(timeout time.Duration) *services {
+	return &services{timeout: timeout, entries: map[string]*microservice.ServiceEntry{}}
+}
+
+func (s *services) entry(name string) *microservice.ServiceEntry {
+	e, exists := s.entries[name]
+	if !exists {
+		e = &microservice.ServiceEntry{Name: name}
+		s.entries[name] = e
+	}
+	return e
+}
+
+// transition marks a service as up or down, it returns true when that changed
+func (s *services) transition(e *microservice.ServiceEntry, up bool, now time.Time) bool {
+	if e.Up == up && !e.Since.IsZero() {
+		return false
+	}
+	e.Up = up
+	e.Since = now
+	return true
+}
+
+// heartbeat records the heartbeat of a service, it returns true when the service came up
+func (s *services) heartbeat(heartbeat *microservice.Heartbeat, now time.Time) bool {
+	e := s.entry(heartbeat.Name)
+	e.Heartbeat = heartbeat
+	e.LastSeen = now
+	return s.transition(e, true, now)
+}
+
+// status records an "online" or "offline" status, it returns true when the service came
+// up or went down.
+func (s *services) status(status *microservice.Status, now time.Time) bool {
+	e := s.entry(status.Name)
+	e.LastSeen = now
+	return s.transition(e, status.Status == "online", now)
+}
+
+// expire marks the services that did not send a heartbeat in time as down and returns
+// their names.
+func (s *services) expire(now time.Time) []string {
+	down := []string{}
+	for name, e := range s.entries {
+		if e.Up && now.Sub(e.LastSeen) > s.timeout {
+			s.transition(e, false, now)
+			down = append(down, name)
+		}
+	}
+	sort.Strings(down)
+	return down
+}
+
+// list returns the service with 'name' or all the services when 'name' is empty
+func (s *services) list(name string) []microservice.ServiceEntry {
+	list := []microservice.ServiceEntry{}
+	for _, e := range s.entries {
+		if name == "" || e.Name == name {
+			list = append(list, *e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
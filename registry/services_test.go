@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+func TestServices(t *testing.T) {
+	s := newServices(30 * time.Second)
+	now := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+
+	if !s.status(&microservice.Status{Name: "flux", Status: "online"}, now) {
+		t.Errorf("flux did not come up when it was online")
+	}
+	if !s.heartbeat(&microservice.Heartbeat{Name: "shout", ConfigRevision: "1a2b3c4d"}, now) {
+		t.Errorf("shout did not come up on its first heartbeat")
+	}
+	if s.heartbeat(&microservice.Heartbeat{Name: "flux"}, now.Add(10*time.Second)) {
+		t.Errorf("flux came up twice")
+	}
+
+	if down := s.expire(now.Add(35 * time.Second)); len(down) != 1 || down[0] != "shout" {
+		t.Errorf("expire() = %v, want [shout]", down)
+	}
+	if !s.status(&microservice.Status{Name: "flux", Status: "offline"}, now.Add(36*time.Second)) {
+		t.Errorf("flux did not go down when it was offline")
+	}
+	if down := s.expire(now.Add(time.Minute)); len(down) != 0 {
+		t.Errorf("expire() = %v, services that are down should not go down again", down)
+	}
+
+	list := s.list("")
+	if len(list) != 2 || list[0].Name != "flux" || list[1].Name != "shout" {
+		t.Fatalf("list() = %+v", list)
+	}
+	if list[1].Up || list[1].Heartbeat == nil || list[1].Heartbeat.ConfigRevision != "1a2b3c4d" {
+		t.Errorf("shout = %+v", list[1])
+	}
+	if list := s.list("shout"); len(list) != 1 || list[0].Name != "shout" {
+		t.Errorf("list(shout) = %+v", list)
+	}
+}
@@ -4,6 +4,10 @@ package main
 // - Samsung TV: Turn On/Off
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/jurgen-kluft/go-home/config"
 	microservice "github.com/jurgen-kluft/go-home/micro-service"
 	"github.com/saljam/samote"
@@ -19,6 +23,7 @@ type tv struct {
 type instance struct {
 	name   string
 	config *config.SamsungTVConfig
+	mutex  sync.Mutex
 	tvs    map[string]*tv
 }
 
@@ -36,36 +41,45 @@ func (c *instance) Add(host string, name string, id string) error {
 	tv := &tv{name: name, host: host, id: id}
 	tv.remote, err = samote.Dial(host, name, id)
 	if err == nil {
+		c.mutex.Lock()
 		c.tvs[name] = tv
+		c.mutex.Unlock()
 	} else {
 		return err
 	}
 	return err
 }
 
-func (c *instance) poweron(name string) error {
+func (c *instance) get(name string) (*tv, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	tv, exists := c.tvs[name]
+	return tv, exists
+}
+
+func (c *instance) poweron(name string) error {
+	tv, exists := c.get(name)
 	if exists {
 		remote, err := samote.Dial(tv.host, tv.name, tv.id)
-		if err == nil {
-			remote.SendKey(samote.KEY_POWERON)
-		} else {
+		if err != nil {
 			return err
 		}
+		defer remote.Close()
+		return remote.SendKey(samote.KEY_POWERON)
 	}
-	return nil
+	return fmt.Errorf("TV '%s' doesn't exist", name)
 }
 func (c *instance) poweroff(name string) error {
-	tv, exists := c.tvs[name]
+	tv, exists := c.get(name)
 	if exists {
 		remote, err := samote.Dial(tv.host, tv.name, tv.id)
-		if err == nil {
-			remote.SendKey(samote.KEY_POWEROFF)
-		} else {
+		if err != nil {
 			return err
 		}
+		defer remote.Close()
+		return remote.SendKey(samote.KEY_POWEROFF)
 	}
-	return nil
+	return fmt.Errorf("TV '%s' doesn't exist", name)
 }
 
 func main() {
@@ -79,8 +93,12 @@ func main() {
 
 	m.RegisterHandler("config/samsung.tv/", func(m *microservice.Service, topic string, msg []byte) bool {
 		m.Logger.LogInfo(m.Name, "received configuration")
-		var err error
-		c.config, err = config.SamsungTVConfigFromJSON(msg)
+		cfg, err := config.SamsungTVConfigFromJSON(msg)
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		c.config = cfg
 		for _, tv := range c.config.Devices {
 			err = c.Add(tv.IP, tv.Name, tv.ID)
 			if err == nil {
@@ -92,8 +110,8 @@ func main() {
 		return true
 	})
 
-	m.RegisterHandler("state/samsung.tv/*/", func(m *microservice.Service, topic string, msg []byte) bool {
-		m.Logger.LogInfo(m.Name, "received configuration")
+	m.RegisterReplyHandler("state/samsung.tv/*/", func(m *microservice.Service, topic string, msg []byte) *microservice.Reply {
+		m.Logger.LogInfo(m.Name, "received state")
 		state, err := config.SensorStateFromJSON(msg)
 		if err == nil {
 			power := state.GetValueAttr("power", "idle")
@@ -102,14 +120,14 @@ func main() {
 			} else if power == "on" {
 				err = c.poweron(state.Name)
 			}
-			if err != nil {
-				m.Logger.LogError(m.Name, err.Error())
-			}
-		} else {
-			m.Logger.LogError(m.Name, err.Error())
 		}
-		return true
+		if err != nil {
+			return microservice.Failed(err)
+		}
+		return microservice.Succeeded(nil)
 	})
+	// Dialing a TV that is unreachable takes a while, only the commands of that TV wait for it
+	m.RunConcurrently("state/samsung.tv/*/", microservice.HandlerOptions{Key: microservice.ByName, Timeout: 10 * time.Second})
 
 	tickCount := 0
 	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
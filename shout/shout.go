@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/jurgen-kluft/go-home/config"
 	microservice "github.com/jurgen-kluft/go-home/micro-service"
@@ -12,6 +14,7 @@ import (
 type instance struct {
 	name    string
 	config  *config.ShoutConfig
+	mutex   sync.Mutex
 	client  *slack.Client
 	service *microservice.Service
 }
@@ -27,20 +30,31 @@ func (s *instance) initialize(jsondata []byte) error {
 	s.name = "shout"
 	config, err := config.ShoutConfigFromJSON(jsondata)
 	if err == nil {
+		s.mutex.Lock()
 		s.config = config
 		s.client = slack.New(config.Key.String)
+		s.mutex.Unlock()
 	}
 	return err
 }
 
+func (s *instance) connected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.client != nil
+}
+
 // postMessage posts a message to a channel
 func (s *instance) postMessage(jsondata []byte) {
-	if s.client != nil {
-		channelID, timestamp, err := s.client.PostMessage(s.config.Channel, slack.MsgOptionText(string(jsondata), false), slack.MsgOptionUsername("g0-h0m3"), slack.MsgOptionAsUser(true))
+	s.mutex.Lock()
+	client, config := s.client, s.config
+	s.mutex.Unlock()
+	if client != nil {
+		channelID, timestamp, err := client.PostMessage(config.Channel, slack.MsgOptionText(string(jsondata), false), slack.MsgOptionUsername("g0-h0m3"), slack.MsgOptionAsUser(true))
 		if err == nil {
 			s.service.Logger.LogInfo(s.name, fmt.Sprintf("message '%s' send (%s, %s)", string(jsondata), channelID, timestamp))
 		} else {
-			s.service.Logger.LogError(s.name, fmt.Sprintf("message '%s' not send (%s, %s)", string(jsondata), s.config.Channel, timestamp))
+			s.service.Logger.LogError(s.name, fmt.Sprintf("message '%s' not send (%s, %s)", string(jsondata), config.Channel, timestamp))
 			s.service.Logger.LogError(s.name, err.Error())
 		}
 	} else {
@@ -71,12 +85,14 @@ func main() {
 
 	m.RegisterHandler("shout/message/", func(m *microservice.Service, topic string, msg []byte) bool {
 		// Is this a message to send over slack ?
-		if c.client != nil {
+		if c.connected() {
 			m.Logger.LogInfo(m.Name, "message")
 			c.postMessage(msg)
 		}
 		return true
 	})
+	// A hanging post to Slack should not block the other messages
+	m.RunConcurrently("shout/message/", microservice.HandlerOptions{Key: microservice.ByTopic, Timeout: 30 * time.Second})
 
 	tickCount := 0
 	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
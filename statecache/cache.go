@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+	pubsub "github.com/jurgen-kluft/go-home/nats"
+)
+
+// retained is a state with its expiry, zero when it does not expire
+type retained struct {
+	payload json.RawMessage
+	expires time.Time
+}
+
+func (r *retained) expired(now time.Time) bool {
+	return !r.expires.IsZero() && now.After(r.expires)
+}
+
+// cache retains the last SensorState per subject and name
+type cache struct {
+	exclude []string
+	states  map[string]map[string]*retained
+}
+
+func newCache(cfg *config.StateCacheConfig) *cache {
+	c := &cache{states: map[string]map[string]*retained{}}
+	for _, channel := range cfg.ExcludeChannels {
+		c.exclude = append(c.exclude, subjectOf(channel))
+	}
+	return c
+}
+
+// subjectOf converts a channel like 'state/sensor/*/' to a subject
+func subjectOf(channel string) string {
+	subject := strings.Replace(channel, "/", ".", -1)
+	return strings.TrimSuffix(subject, ".")
+}
+
+func (c *cache) excluded(subject string) bool {
+	for _, pattern := range c.exclude {
+		if pubsub.MatchSubject(pattern, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// put retains a message when it is a SensorState, returns false when it is not
+func (c *cache) put(subject string, payload []byte, expires time.Time) bool {
+	if c.excluded(subject) {
+		return false
+	}
+	state, err := config.SensorStateFromJSON(payload)
+	if err != nil || state.Name == "" {
+		return false
+	}
+	names, exists := c.states[subject]
+	if !exists {
+		names = map[string]*retained{}
+		c.states[subject] = names
+	}
+	names[state.Name] = &retained{payload: append(json.RawMessage{}, payload...), expires: expires}
+	return true
+}
+
+// prune removes the states that have expired
+func (c *cache) prune(now time.Time) {
+	for subject, names := range c.states {
+		for name, r := range names {
+			if r.expired(now) {
+				delete(names, name)
+			}
+		}
+		if len(names) == 0 {
+			delete(c.states, subject)
+		}
+	}
+}
+
+// get returns the retained states of the subjects that match the channel, sorted by
+// subject and name, states that have expired are not returned.
+func (c *cache) get(channel string, now time.Time) []microservice.RetainedState {
+	pattern := subjectOf(channel)
+	subjects := []string{}
+	for subject := range c.states {
+		if pubsub.MatchSubject(pattern, subject) {
+			subjects = append(subjects, subject)
+		}
+	}
+	sort.Strings(subjects)
+
+	states := []microservice.RetainedState{}
+	for _, subject := range subjects {
+		names := []string{}
+		for name := range c.states[subject] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r := c.states[subject][name]
+			if r.expired(now) {
+				continue
+			}
+			state := microservice.RetainedState{Topic: subject, Payload: r.payload}
+			if !r.expires.IsZero() {
+				expires := r.expires
+				state.Expires = &expires
+			}
+			states = append(states, state)
+		}
+	}
+	return states
+}
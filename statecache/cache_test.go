@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+)
+
+func TestCache(t *testing.T) {
+	c := newCache(&config.StateCacheConfig{ExcludeChannels: []string{"state/*/automation/", "state/*/*/automation/"}})
+
+	puts := []struct {
+		subject string
+		payload string
+		put     bool
+	}{
+		{"state.sensor.sun", `{"name": "sun", "type": "sensor", "stringattrs": [{"name": "state", "value": "sunrise"}]}`, true},
+		{"state.sensor.weather", `{"name": "weather", "type": "sensor", "floatattrs": [{"name": "temperature", "value": 7.5}]}`, true},
+		{"state.light.conbee", `{"name": "Kitchen", "type": "light", "stringattrs": [{"name": "power", "value": "off"}]}`, true},
+		{"state.light.conbee", `{"name": "Bedroom Main", "type": "light", "stringattrs": [{"name": "power", "value": "on"}]}`, true},
+		{"state.light.conbee", `{"name": "Kitchen", "type": "light", "stringattrs": [{"name": "power", "value": "on"}]}`, true},
+		{"state.light.automation", `{"name": "Kitchen", "stringattrs": [{"name": "power", "value": "off"}]}`, false},
+		{"state.samsung.tv.automation", `{"name": "Bedroom Samsung-TV", "stringattrs": [{"name": "power", "value": "off"}]}`, false},
+		{"state.presence", `not a sensor state`, false},
+	}
+	now := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+	for _, p := range puts {
+		if put := c.put(p.subject, []byte(p.payload), time.Time{}); put != p.put {
+			t.Errorf("put(%s, %s) = %v", p.subject, p.payload, put)
+		}
+	}
+
+	lights := c.get("state/light/conbee/", now)
+	if len(lights) != 2 || lights[0].Topic != "state.light.conbee" {
+		t.Fatalf("get(state/light/conbee/) = %v", lights)
+	}
+	kitchen, err := config.SensorStateFromJSON(lights[1].Payload)
+	if err != nil || kitchen.Name != "Kitchen" || kitchen.GetValueAttr("power", "") != "on" {
+		t.Errorf("retained state of Kitchen = %s", lights[1].Payload)
+	}
+
+	if sensors := c.get("state/sensor/*/", now); len(sensors) != 2 || sensors[0].Topic != "state.sensor.sun" || sensors[1].Topic != "state.sensor.weather" {
+		t.Errorf("get(state/sensor/*/) = %v", sensors)
+	}
+	if all := c.get("state/>/", now); len(all) != 4 {
+		t.Errorf("get(state/>/) = %d states, want 4", len(all))
+	}
+	if none := c.get("state/switch/xiaomi/", now); len(none) != 0 {
+		t.Errorf("get(state/switch/xiaomi/) = %v", none)
+	}
+
+	// A state that was published with a TTL expires
+	c.put("state.sensor.aqi", []byte(`{"name": "aqi", "type": "sensor"}`), now.Add(5*time.Minute))
+	if aqi := c.get("state/sensor/aqi/", now.Add(4*time.Minute)); len(aqi) != 1 || aqi[0].Expires == nil || !aqi[0].Expires.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("get(state/sensor/aqi/) before expiry = %v", aqi)
+	}
+	if aqi := c.get("state/sensor/aqi/", now.Add(6*time.Minute)); len(aqi) != 0 {
+		t.Errorf("get(state/sensor/aqi/) after expiry = %v", aqi)
+	}
+	c.prune(now.Add(6 * time.Minute))
+	if _, exists := c.states["state.sensor.aqi"]; exists || len(c.states) != 3 {
+		t.Errorf("prune() left %d subjects", len(c.states))
+	}
+}
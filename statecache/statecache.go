@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+	microservice "github.com/jurgen-kluft/go-home/micro-service"
+)
+
+// statecache retains the last state of every sensor, light and switch so that services
+// that start (e.g. flux) do not have to wait for the next publish to know the state of
+// the house.
+type statecache struct {
+	config *config.StateCacheConfig
+	cache  *cache
+}
+
+func main() {
+	sc := &statecache{}
+
+	register := []string{"config/request/"}
+	subscribe := []string{"config/statecache/"}
+
+	m := microservice.New("statecache")
+	m.RegisterAndSubscribe(register, subscribe)
+
+	m.RegisterHandler("config/statecache/", func(m *microservice.Service, topic string, msg []byte) bool {
+		m.Logger.LogInfo(m.Name, "received configuration")
+		cfg, err := config.StateCacheConfigFromJSON(msg)
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		cache := newCache(cfg)
+		if sc.cache != nil {
+			// keep the retained states when the configuration is changed
+			cache.states = sc.cache.states
+		}
+		sc.cache = cache
+		if sc.config == nil {
+			channel := cfg.Channel
+			if channel == "" {
+				channel = microservice.StateCacheChannel
+			}
+			m.RegisterReplyHandler(channel, func(m *microservice.Service, topic string, request []byte) *microservice.Reply {
+				jsondata, err := json.Marshal(sc.cache.get(string(request), time.Now()))
+				if err != nil {
+					return microservice.Failed(err)
+				}
+				return microservice.Succeeded(jsondata)
+			})
+			m.Subscribe(channel)
+			for _, channel := range cfg.SubChannels {
+				if err := m.Subscribe(channel); err != nil {
+					m.Logger.LogError(m.Name, err.Error())
+				}
+			}
+			m.Logger.LogInfo(m.Name, fmt.Sprintf("retaining the states of %d channels", len(cfg.SubChannels)))
+		}
+		sc.config = cfg
+		return true
+	})
+
+	m.RegisterHandler("*", func(m *microservice.Service, topic string, msg []byte) bool {
+		if sc.cache != nil {
+			sc.cache.put(topic, msg, m.MessageExpires)
+		}
+		return true
+	})
+
+	tickCount := 0
+	m.RegisterHandler("tick/", func(m *microservice.Service, topic string, msg []byte) bool {
+		if tickCount%5 == 0 {
+			if sc.config == nil {
+				m.Pubsub.PublishStr("config/request/", m.Name)
+			}
+		}
+		if tickCount%30 == 0 && sc.cache != nil {
+			sc.cache.prune(time.Now())
+		}
+		tickCount++
+		return true
+	})
+
+	m.Loop()
+}
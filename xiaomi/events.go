@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jurgen-kluft/go-home/config"
+)
+
+// event is a device report of the Aqara gateway as it is passed from the migateway
+// state messages onto the process messages channel of the micro-service.
+type event struct {
+	Kind          string  `json:"kind"` // gateway, motion, magnet, switch, plug or wiredswitch
+	ID            string  `json:"id"`
+	Motion        bool    `json:"motion,omitempty"`
+	Opened        bool    `json:"opened,omitempty"`
+	Click         string  `json:"click,omitempty"`
+	Battery       float64 `json:"battery,omitempty"`
+	InUse         bool    `json:"inuse,omitempty"`
+	IsOn          bool    `json:"ison,omitempty"`
+	LoadVoltage   float64 `json:"loadvoltage,omitempty"`
+	LoadPower     float64 `json:"loadpower,omitempty"`
+	PowerConsumed float64 `json:"powerconsumed,omitempty"`
+	Channel0On    bool    `json:"channel0,omitempty"`
+	Channel1On    bool    `json:"channel1,omitempty"`
+	Illumination  float64 `json:"illumination,omitempty"`
+	RGB           int64   `json:"rgb,omitempty"`
+}
+
+// published is a sensor state that should be published on a channel
+type published struct {
+	channel string
+	state   *config.SensorState
+}
+
+// clickName converts the click reported by the gateway (e.g. "double_click") to the
+// click names that automation uses.
+func clickName(click string) string {
+	switch strings.ToLower(strings.Replace(click, "_", " ", -1)) {
+	case "click", "single click":
+		return config.WirelessSwitchSingleClick
+	case "double click":
+		return config.WirelessSwitchDoubleClick
+	case "long click press", "long press":
+		return config.WirelessSwitchLongPress
+	case "long click release", "long release":
+		return config.WirelessSwitchLongRelease
+	}
+	return ""
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// initialize applies the configuration, channels that are not configured get their
+// default name.
+func (x *xiaomi) initialize(jsondata []byte) (err error) {
+	x.config, err = config.XiaomiConfigFromJSON(jsondata)
+	if err != nil {
+		return err
+	}
+	if x.config.SensorsOut == "" {
+		x.config.SensorsOut = "state/sensor/xiaomi/"
+	}
+	if x.config.SwitchesOut == "" {
+		x.config.SwitchesOut = "state/switch/xiaomi/"
+	}
+	if x.config.CommandsIn == "" {
+		x.config.CommandsIn = "state/xiaomi/"
+	}
+	x.initializeHealth()
+	return nil
+}
+
+// handleEvent converts a device report into the sensor states to publish, the states
+// have the same shape as the ones of conbee so that automation can use either.
+func (x *xiaomi) handleEvent(ev *event, now time.Time) []published {
+	result := []published{}
+	var sensor *config.SensorState
+	channel := x.config.SensorsOut
+	battery := false
+
+	switch ev.Kind {
+	case "gateway":
+		sensor = config.NewSensorState("gateway", "gateway")
+		sensor.AddFloatAttr("illumination", ev.Illumination)
+		sensor.AddIntAttr("rgb", ev.RGB)
+	case "motion":
+		sensor = config.NewSensorState(x.GetNameOfMotionSensor(ev.ID), "motion")
+		sensor.AddStringAttr("motion", onOff(ev.Motion))
+		battery = true
+	case "magnet":
+		sensor = config.NewSensorState(x.GetNameOfMagnetSensor(ev.ID), "contact")
+		if ev.Opened {
+			sensor.AddStringAttr("state", "open")
+		} else {
+			sensor.AddStringAttr("state", "close")
+		}
+		battery = true
+	case "switch":
+		channel = x.config.SwitchesOut
+		sensor = config.NewSensorState(x.GetNameOfSwitch(ev.ID), "switch")
+		if click := clickName(ev.Click); click != "" {
+			sensor.AddStringAttr("click", click)
+		}
+		battery = true
+	case "wiredswitch":
+		channel = x.config.SwitchesOut
+		sensor = config.NewSensorState(x.GetNameOfSwitch(ev.ID), "switch")
+		sensor.AddStringAttr("switch0", onOff(ev.Channel0On))
+		sensor.AddStringAttr("switch1", onOff(ev.Channel1On))
+	case "plug":
+		sensor = config.NewSensorState(x.GetNameOfPlug(ev.ID), "powerplug")
+		sensor.AddStringAttr("power", onOff(ev.IsOn))
+		sensor.AddBoolAttr("inuse", ev.InUse)
+		sensor.AddFloatAttr("loadvoltage", ev.LoadVoltage)
+		sensor.AddFloatAttr("loadpower", ev.LoadPower)
+		sensor.AddFloatAttr("powerconsumed", ev.PowerConsumed)
+	default:
+		return result
+	}
+	sensor.Time = now
+	result = append(result, published{channel: channel, state: sensor})
+
+	if battery && x.config.HealthOut != "" {
+		if h := x.seen(sensor.Name, ev.Battery, now); h != nil {
+			result = append(result, published{channel: x.config.HealthOut, state: h.sensorState()})
+		}
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testConfig = `{
+	"sensors.out": "state/sensor/xiaomi/",
+	"switches.out": "state/switch/xiaomi/",
+	"health.out": "state/health/xiaomi/",
+	"motion": [{"id": "158d0001a9113b", "name": "Kitchen Motion", "battery_type": "cr2450"}],
+	"magnet": [{"id": "158d0001214763", "name": "Front Door Magnet", "battery_type": "cr1632"}],
+	"switch": [{"id": "158d00015db32c", "name": "Bedroom Switch", "battery_type": "cr2032"}],
+	"plug": [{"id": "158d0001dc35fb", "name": "Bedroom Plug"}]
+}`
+
+func TestHandleEvent(t *testing.T) {
+	x := &xiaomi{}
+	if err := x.initialize([]byte(testConfig)); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		event     event
+		channel   string
+		name      string
+		attribute string
+		value     string
+	}{
+		{event{Kind: "motion", ID: "158d0001a9113b", Motion: true, Battery: 3005}, "state/sensor/xiaomi/", "Kitchen Motion", "motion", "on"},
+		{event{Kind: "magnet", ID: "158d0001214763", Opened: false, Battery: 3015}, "state/sensor/xiaomi/", "Front Door Magnet", "state", "close"},
+		{event{Kind: "switch", ID: "158d00015db32c", Click: "double_click", Battery: 3010}, "state/switch/xiaomi/", "Bedroom Switch", "click", "double click"},
+		{event{Kind: "switch", ID: "158d00015db32c", Click: "long_click_press", Battery: 3010}, "state/switch/xiaomi/", "Bedroom Switch", "click", "long press"},
+		{event{Kind: "plug", ID: "158d0001dc35fb", IsOn: true, InUse: true, LoadPower: 42.5}, "state/sensor/xiaomi/", "Bedroom Plug", "power", "on"},
+	}
+	for _, tt := range tests {
+		states := x.handleEvent(&tt.event, now)
+		if len(states) == 0 || states[0].channel != tt.channel || states[0].state.Name != tt.name {
+			t.Fatalf("handleEvent(%s) = %v", tt.event.ID, states)
+		}
+		if value := states[0].state.GetValueAttr(tt.attribute, ""); value != tt.value {
+			t.Errorf("handleEvent(%s) %s = '%s', want '%s'", tt.event.ID, tt.attribute, value, tt.value)
+		}
+		// battery powered devices also publish their health
+		if tt.event.Battery > 0 {
+			if len(states) != 2 || states[1].channel != "state/health/xiaomi/" || states[1].state.GetFloatAttr("battery", 0) != tt.event.Battery || !states[1].state.Time.Equal(now) {
+				t.Errorf("handleEvent(%s) health = %v", tt.event.ID, states)
+			}
+		} else if len(states) != 1 {
+			t.Errorf("handleEvent(%s) = %d states, want 1", tt.event.ID, len(states))
+		}
+	}
+
+	states := x.handleEvent(&event{Kind: "plug", ID: "158d0001dc35fb", LoadPower: 42.5, PowerConsumed: 1200}, now)
+	if p := states[0].state; p.GetFloatAttr("loadpower", 0) != 42.5 || p.GetFloatAttr("powerconsumed", 0) != 1200 {
+		t.Errorf("handleEvent(plug) = %+v", p)
+	}
+
+	if health := x.healthStates(); len(health) != 3 {
+		t.Errorf("healthStates() = %d states, want 3", len(health))
+	}
+}
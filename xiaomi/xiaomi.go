@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/jurgen-kluft/go-home/config"
@@ -18,6 +19,7 @@ import (
 
 // Publish state of:
 // - Motion Sensor(s)
+// - Magnet Sensor(s)
 // - Wireless Switch(es)
 // - WiredDualWallSwitch(es)
 // - Electric Power Plug(s)
@@ -25,6 +27,103 @@ import (
 type xiaomi struct {
 	config *config.XiaomiConfig
 	aqara  *migateway.AqaraManager
+	health []*deviceHealth
+}
+
+// deviceHealth is the battery and last-seen of a battery powered device, Battery is
+// as reported by the gateway and -1 when nothing has been received yet.
+type deviceHealth struct {
+	Name        string
+	Device      string // motion, contact or switch
+	BatteryType string
+	LastSeen    time.Time
+	Battery     float64
+}
+
+// initializeHealth creates the health of every configured battery powered device
+func (x *xiaomi) initializeHealth() {
+	x.health = []*deviceHealth{}
+	add := func(name string, device string, batteryType string) {
+		if batteryType != "" {
+			x.health = append(x.health, &deviceHealth{Name: name, Device: device, BatteryType: batteryType, LastSeen: time.Now(), Battery: -1})
+		}
+	}
+	for _, dev := range x.config.Motion {
+		add(dev.Name, "motion", dev.BType)
+	}
+	for _, dev := range x.config.Magnet {
+		add(dev.Name, "contact", dev.BType)
+	}
+	for _, dev := range x.config.Switch {
+		add(dev.Name, "switch", dev.BType)
+	}
+}
+
+// seen registers the battery of a device that reported a state change
+func (x *xiaomi) seen(name string, battery float64, now time.Time) *deviceHealth {
+	for _, h := range x.health {
+		if h.Name == name {
+			h.LastSeen = now
+			h.Battery = battery
+			return h
+		}
+	}
+	return nil
+}
+
+func (h *deviceHealth) sensorState() *config.SensorState {
+	sensor := config.NewSensorState(h.Name, "health")
+	sensor.Time = h.LastSeen
+	sensor.AddStringAttr("source", "xiaomi")
+	sensor.AddStringAttr("device", h.Device)
+	sensor.AddStringAttr("battery_type", h.BatteryType)
+	if h.Battery >= 0 {
+		sensor.AddFloatAttr("battery", h.Battery)
+	}
+	return sensor
+}
+
+// healthStates returns the health of all the battery powered devices
+func (x *xiaomi) healthStates() []published {
+	result := []published{}
+	if x.config.HealthOut != "" {
+		for _, h := range x.health {
+			result = append(result, published{channel: x.config.HealthOut, state: h.sensorState()})
+		}
+	}
+	return result
+}
+
+func publish(m *microservice.Service, states []published) {
+	for _, p := range states {
+		jsondata, err := p.state.ToJSON()
+		if err == nil {
+			err = m.Pubsub.Publish(p.channel, jsondata)
+		}
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+		}
+	}
+}
+
+// eventOfStateMessage converts a migateway state message into an event, it returns nil
+// for messages that we are not interested in.
+func eventOfStateMessage(msg interface{}) *event {
+	switch state := msg.(type) {
+	case *migateway.GatewayStateChange:
+		return &event{Kind: "gateway", Illumination: float64(state.To.Illumination), RGB: int64(state.To.RGB)}
+	case *migateway.MagnetStateChange:
+		return &event{Kind: "magnet", ID: state.ID, Opened: state.To.Opened, Battery: float64(state.To.Battery)}
+	case *migateway.MotionStateChange:
+		return &event{Kind: "motion", ID: state.ID, Motion: state.To.HasMotion, Battery: float64(state.To.Battery)}
+	case *migateway.PlugStateChange:
+		return &event{Kind: "plug", ID: state.ID, InUse: state.To.InUse, IsOn: state.To.IsOn, LoadVoltage: float64(state.To.LoadVoltage), LoadPower: float64(state.To.LoadPower), PowerConsumed: float64(state.To.PowerConsumed)}
+	case *migateway.SwitchStateChange:
+		return &event{Kind: "switch", ID: state.ID, Click: state.To.Click.String(), Battery: float64(state.To.Battery)}
+	case *migateway.DualWiredWallSwitchStateChange:
+		return &event{Kind: "wiredswitch", ID: state.ID, Channel0On: state.To.Channel0On, Channel1On: state.To.Channel1On}
+	}
+	return nil
 }
 
 func (x *xiaomi) GetNameOfMotionSensor(ID string) string {
@@ -90,31 +189,14 @@ func main() {
 	xiaomi := &xiaomi{}
 	xiaomi.aqara = migateway.NewAqaraManager()
 
-	register := []string{"state/xiaomi/", "config/request/"}
+	register := []string{"config/request/"}
 	subscribe := []string{"config/xiaomi/"}
 
 	m := microservice.New("xiaomi")
 	m.RegisterAndSubscribe(register, subscribe)
 
-	m.RegisterHandler("config/xiaomi/", func(m *microservice.Service, topic string, msg []byte) bool {
-		m.Logger.LogInfo("xiaomi", "received configuration")
-		var err error
-		xiaomi.config, err = config.XiaomiConfigFromJSON(msg)
-		if err == nil {
-			err = xiaomi.aqara.Start(nil)
-			if err == nil {
-				xiaomi.aqara.SetAESKey(xiaomi.config.Key.String)
-			} else {
-				m.Logger.LogError(m.Name, err.Error())
-			}
-		} else {
-			m.Logger.LogError(m.Name, err.Error())
-		}
-		return true
-	})
-
-	m.RegisterHandler("state/xiaomi/", func(m *microservice.Service, topic string, msg []byte) bool {
-		m.Logger.LogInfo(m.Name, "received state")
+	handleCommand := func(m *microservice.Service, topic string, msg []byte) bool {
+		m.Logger.LogInfo(m.Name, "received command")
 		state, err := config.SensorStateFromJSON(msg)
 		if err == nil {
 			if state.Name == "gateway" {
@@ -150,6 +232,52 @@ func main() {
 			}
 		}
 		return true
+	}
+
+	subscribed := false
+	m.RegisterHandler("config/xiaomi/", func(m *microservice.Service, topic string, msg []byte) bool {
+		m.Logger.LogInfo("xiaomi", "received configuration")
+		started := xiaomi.config != nil
+		err := xiaomi.initialize(msg)
+		if err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		for _, channel := range []string{xiaomi.config.SensorsOut, xiaomi.config.SwitchesOut, xiaomi.config.HealthOut} {
+			if channel != "" {
+				m.Register(channel)
+			}
+		}
+		m.RegisterHandler(xiaomi.config.CommandsIn, handleCommand)
+		// Only the first configuration subscribes, the subscriptions are restored on a reconnect
+		if !subscribed {
+			if err := m.Subscribe(xiaomi.config.CommandsIn); err != nil {
+				m.Logger.LogError(m.Name, err.Error())
+			}
+			subscribed = true
+		}
+
+		if !started {
+			err = xiaomi.aqara.Start(nil)
+			if err == nil {
+				xiaomi.aqara.SetAESKey(xiaomi.config.Key.String)
+			} else {
+				m.Logger.LogError(m.Name, err.Error())
+			}
+		}
+		return true
+	})
+
+	m.RegisterHandler("xiaomi/event/", func(m *microservice.Service, topic string, msg []byte) bool {
+		ev := &event{}
+		if err := json.Unmarshal(msg, ev); err != nil {
+			m.Logger.LogError(m.Name, err.Error())
+			return true
+		}
+		if xiaomi.config != nil {
+			publish(m, xiaomi.handleEvent(ev, time.Now()))
+		}
+		return true
 	})
 
 	tickCount := 0
@@ -159,99 +287,20 @@ func main() {
 				m.Pubsub.PublishStr("config/request/", m.Name)
 			}
 		}
+		if tickCount%300 == 0 && xiaomi.config != nil { // every 10 minutes
+			publish(m, xiaomi.healthStates())
+		}
 		tickCount++
 		return true
 	})
 
 	// Xiaomi Aqara Gateway and device state changes onto the process messages channel of the micro-service
 	go func() {
-		for true {
-			select {
-			case msg := <-xiaomi.aqara.StateMessages:
-				//fmt.Printf("STATE message received %v (type: %s)\n", msg, reflect.TypeOf(msg))
-
-				switch msg.(type) {
-				case *migateway.GatewayStateChange:
-					state := msg.(*migateway.GatewayStateChange)
-					name := "gateway"
-					sensor := config.NewSensorState(name, "gateway")
-					sensor.AddFloatAttr("illumination", state.To.Illumination)
-					sensor.AddIntAttr("rgb", int64(state.To.RGB))
-					jsondata, err := sensor.ToJSON()
-					if err == nil {
-						//fmt.Println(jsonstr)
-						msg := &microservice.Message{Topic: "state/xiaomi/", Payload: jsondata}
-						m.ProcessMessages <- msg
-					}
-
-				case *migateway.MagnetStateChange:
-					state := msg.(*migateway.MagnetStateChange)
-					name := xiaomi.GetNameOfMagnetSensor(state.ID)
-					sensor := config.NewSensorState(name, "contact")
-					sensor.AddBoolAttr("open", state.To.Opened)
-					sensor.AddFloatAttr("battery", float64(state.To.Battery))
-					jsondata, err := sensor.ToJSON()
-					if err == nil {
-						//fmt.Println(jsonstr)
-						msg := &microservice.Message{Topic: "state/xiaomi/", Payload: jsondata}
-						m.ProcessMessages <- msg
-					}
-
-				case *migateway.MotionStateChange:
-					state := msg.(*migateway.MotionStateChange)
-					name := xiaomi.GetNameOfMotionSensor(state.ID)
-					sensor := config.NewSensorState(name, "motion")
-					sensor.AddTimeWndAttr("lastmotion", state.To.LastMotion, time.Now())
-					sensor.AddBoolAttr("motion", state.To.HasMotion)
-					sensor.AddFloatAttr("battery", float64(state.To.Battery))
-					jsondata, err := sensor.ToJSON()
-					if err == nil {
-						//fmt.Println(jsonstr)
-						msg := &microservice.Message{Topic: "state/xiaomi/", Payload: jsondata}
-						m.ProcessMessages <- msg
-					}
-
-				case *migateway.PlugStateChange:
-					state := msg.(*migateway.PlugStateChange)
-					name := xiaomi.GetNameOfPlug(state.ID)
-					sensor := config.NewSensorState(name, "powerplug")
-					sensor.AddBoolAttr("inuse", state.To.InUse)
-					sensor.AddBoolAttr("ison", state.To.IsOn)
-					sensor.AddIntAttr("loadvoltage", int64(state.To.LoadVoltage))
-					sensor.AddIntAttr("loadpower", int64(state.To.LoadPower))
-					sensor.AddIntAttr("powerconsumed", int64(state.To.PowerConsumed))
-					jsondata, err := sensor.ToJSON()
-					if err == nil {
-						//fmt.Println(jsonstr)
-						msg := &microservice.Message{Topic: "state/xiaomi/", Payload: jsondata}
-						m.ProcessMessages <- msg
-					}
-
-				case *migateway.SwitchStateChange:
-					state := msg.(*migateway.SwitchStateChange)
-					name := xiaomi.GetNameOfSwitch(state.ID)
-					sensor := config.NewSensorState(name, "switch")
-					sensor.AddStringAttr("click", state.To.Click.String())
-					sensor.AddFloatAttr("battery", float64(state.To.Battery))
-					jsondata, err := sensor.ToJSON()
-					if err == nil {
-						//fmt.Println(jsonstr)
-						msg := &microservice.Message{Topic: "state/xiaomi/", Payload: jsondata}
-						m.ProcessMessages <- msg
-					}
-
-				case *migateway.DualWiredWallSwitchStateChange:
-					state := msg.(*migateway.DualWiredWallSwitchStateChange)
-					name := xiaomi.GetNameOfSwitch(state.ID)
-					sensor := config.NewSensorState(name, "switch")
-					sensor.AddBoolAttr("channel0", state.To.Channel0On)
-					sensor.AddBoolAttr("channel1", state.To.Channel1On)
-					jsondata, err := sensor.ToJSON()
-					if err == nil {
-						//fmt.Println(jsonstr)
-						msg := &microservice.Message{Topic: "state/xiaomi/", Payload: jsondata}
-						m.ProcessMessages <- msg
-					}
+		for msg := range xiaomi.aqara.StateMessages {
+			if ev := eventOfStateMessage(msg); ev != nil {
+				jsondata, err := json.Marshal(ev)
+				if err == nil {
+					m.ProcessMessages <- &microservice.Message{Topic: "xiaomi/event/", Payload: jsondata}
 				}
 			}
 		}
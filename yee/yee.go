@@ -95,7 +95,7 @@ func main() {
 		return true
 	})
 
-	m.RegisterHandler("state/light/yee/*/", func(m *microservice.Service, topic string, msg []byte) bool {
+	m.RegisterReplyHandler("state/light/yee/*/", func(m *microservice.Service, topic string, msg []byte) *microservice.Reply {
 		sensor, err := config.SensorStateFromJSON(msg)
 		if err == nil {
 			m.Logger.LogInfo(m.Name, "received state")
@@ -119,7 +119,7 @@ func main() {
 						light.SetBright(fmt.Sprintf("%f", bri), "smooth", "500")
 					})
 				} else {
-					fmt.Println(lightname + " doesn't exist")
+					return microservice.Failed(fmt.Errorf("light '%s' doesn't exist", lightname))
 				}
 			} else if lightname == "all" {
 				for _, light := range c.lights {
@@ -131,10 +131,12 @@ func main() {
 					})
 				}
 			} else {
-				fmt.Println(lightname + " doesn't exist")
+				return microservice.Failed(fmt.Errorf("light '%s' doesn't exist", lightname))
 			}
+		} else {
+			return microservice.Failed(err)
 		}
-		return true
+		return microservice.Succeeded(nil)
 	})
 
 	tickCount := 0
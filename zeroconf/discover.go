@@ -0,0 +1,148 @@
+package zeroconf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// BusEntry returns the entry that announces the message bus of a PubSub configuration, a
+// bus on this machine is announced with the addresses of this machine. The credentials of
+// the configuration are never announced.
+func BusEntry(cfg map[string]string) (Entry, error) {
+	transport := cfg["transport"]
+	if transport == "" {
+		transport = "nats"
+	}
+	if transport == "memory" {
+		return Entry{}, fmt.Errorf("an in-process message bus cannot be announced")
+	}
+	e, scheme, err := entryFromURL(BusType, cfg["host"])
+	if err != nil {
+		return Entry{}, err
+	}
+	e.Text = map[string]string{"transport": transport, "scheme": scheme}
+	return e, nil
+}
+
+// InfluxEntry returns the entry that announces the InfluxDB of 'secrets' (see config.InfluxSecrets)
+func InfluxEntry(secrets map[string]string) (Entry, error) {
+	e, scheme, err := entryFromURL(InfluxType, secrets["host"])
+	if err != nil {
+		return Entry{}, err
+	}
+	e.Text = map[string]string{"scheme": scheme, "database": secrets["database"]}
+	return e, nil
+}
+
+func entryFromURL(typ string, address string) (Entry, string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return Entry{}, "", err
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return Entry{}, "", fmt.Errorf("%s has no port", address)
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		host = ""
+	}
+	return Entry{Name: "go-home", Type: typ, Host: host, Port: port}, u.Scheme, nil
+}
+
+// Enabled tells if the message bus and InfluxDB are looked for on the LAN, which has to be
+// switched on with GO_HOME_DISCOVER=1 and is off when GO_HOME_PUBSUB selects the bus.
+func Enabled() bool {
+	discover, _ := strconv.ParseBool(os.Getenv("GO_HOME_DISCOVER"))
+	return discover && os.Getenv("GO_HOME_PUBSUB") == ""
+}
+
+// CanDiscoverBus tells if 'base' (a PubSub configuration) may be used with a bus that was
+// found on the LAN. Any host can announce a bus, so a configuration with credentials is only
+// used with a NATS server that proves itself with a certificate of the CA in "tls_ca".
+func CanDiscoverBus(base map[string]string) bool {
+	return !hasCredentials(base) || base["tls_ca"] != ""
+}
+
+func hasCredentials(cfg map[string]string) bool {
+	for _, key := range []string{"credentials", "nkey", "user", "username", "password", "secret"} {
+		if cfg[key] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscoverBus looks for the message bus on the LAN and returns 'base' (a PubSub configuration)
+// with the transport and host of the bus that was found, or 'base' itself when it was not found
+// or when CanDiscoverBus does not allow it.
+func DiscoverBus(ctx context.Context, timeout time.Duration, base map[string]string) (map[string]string, bool) {
+	if !CanDiscoverBus(base) {
+		return base, false
+	}
+	found := Lookup(ctx, BusType, timeout, func(found []Entry) bool {
+		_, ok := usableBus(found, base)
+		return ok
+	})
+	if e, ok := usableBus(found, base); ok {
+		return busConfig(e, base), true
+	}
+	return base, false
+}
+
+// DiscoverInflux looks for InfluxDB on the LAN and returns 'base' (see config.InfluxSecrets)
+// with the host and database that were found, or 'base' itself when it was not found.
+func DiscoverInflux(ctx context.Context, timeout time.Duration, base map[string]string) (map[string]string, bool) {
+	found := Lookup(ctx, InfluxType, timeout, func(found []Entry) bool { return len(found) > 0 })
+	if len(found) == 0 {
+		return base, false
+	}
+	return influxSecrets(found[0], base), true
+}
+
+// usableBus returns the first bus of 'found' that 'base' can be used with, the credentials
+// of 'base' are only sent to a NATS server since MQTT does not check the "tls_ca".
+func usableBus(found []Entry, base map[string]string) (Entry, bool) {
+	for _, e := range found {
+		if !hasCredentials(base) || textOr(e, "transport", "nats") == "nats" {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+func busConfig(e Entry, base map[string]string) map[string]string {
+	cfg := copyMap(base)
+	cfg["transport"] = textOr(e, "transport", "nats")
+	cfg["host"] = textOr(e, "scheme", "nats") + "://" + e.Address()
+	return cfg
+}
+
+func influxSecrets(e Entry, base map[string]string) map[string]string {
+	secrets := copyMap(base)
+	secrets["host"] = textOr(e, "scheme", "http") + "://" + e.Address()
+	if database := e.Text["database"]; database != "" {
+		secrets["database"] = database
+	}
+	return secrets
+}
+
+func textOr(e Entry, key string, def string) string {
+	if value, ok := e.Text[key]; ok && value != "" {
+		return value
+	}
+	return def
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for key, value := range m {
+		c[key] = value
+	}
+	return c
+}
@@ -0,0 +1,86 @@
+package zeroconf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBusEntry(t *testing.T) {
+	base := map[string]string{"transport": "nats", "host": "nats://127.0.0.1:4222", "secret": "s3cr3t"}
+	e, err := BusEntry(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != BusType || e.Host != "" || e.Port != 4222 || e.Text["secret"] != "" {
+		t.Errorf("BusEntry() = %+v", e)
+	}
+
+	e.Host = "10.0.0.22"
+	cfg := busConfig(e, base)
+	if cfg["host"] != "nats://10.0.0.22:4222" || cfg["transport"] != "nats" || cfg["secret"] != "s3cr3t" {
+		t.Errorf("busConfig() = %v", cfg)
+	}
+	if base["host"] != "nats://127.0.0.1:4222" {
+		t.Errorf("busConfig() changed the base configuration")
+	}
+
+	e, err = BusEntry(map[string]string{"transport": "mqtt", "host": "tcp://10.0.0.5:1883"})
+	if err != nil || e.Host != "10.0.0.5" {
+		t.Errorf("BusEntry() = %+v, %v", e, err)
+	}
+	if cfg := busConfig(e, nil); cfg["host"] != "tcp://10.0.0.5:1883" || cfg["transport"] != "mqtt" {
+		t.Errorf("busConfig() = %v", cfg)
+	}
+
+	if _, err := BusEntry(map[string]string{"transport": "memory"}); err == nil {
+		t.Errorf("BusEntry() announces an in-process bus")
+	}
+}
+
+func TestInfluxEntry(t *testing.T) {
+	base := map[string]string{"host": "http://localhost:8086", "username": "influxdb", "database": "gohome"}
+	e, err := InfluxEntry(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != InfluxType || e.Host != "" || e.Port != 8086 || e.Text["database"] != "gohome" {
+		t.Errorf("InfluxEntry() = %+v", e)
+	}
+
+	e.Host = "10.0.0.22"
+	secrets := influxSecrets(e, map[string]string{"host": "http://localhost:8086", "username": "influxdb"})
+	if secrets["host"] != "http://10.0.0.22:8086" || secrets["database"] != "gohome" || secrets["username"] != "influxdb" {
+		t.Errorf("influxSecrets() = %v", secrets)
+	}
+}
+
+func TestCanDiscoverBus(t *testing.T) {
+	tests := []struct {
+		base map[string]string
+		can  bool
+	}{
+		{map[string]string{"host": "nats://127.0.0.1:4222"}, true},
+		{map[string]string{"host": "nats://127.0.0.1:4222", "secret": "s3cr3t"}, false},
+		{map[string]string{"host": "nats://127.0.0.1:4222", "nkey": "/etc/go-home/nkey"}, false},
+		{map[string]string{"host": "nats://127.0.0.1:4222", "secret": "s3cr3t", "tls_ca": "/etc/go-home/ca.pem"}, true},
+	}
+	for _, tt := range tests {
+		if can := CanDiscoverBus(tt.base); can != tt.can {
+			t.Errorf("CanDiscoverBus(%v) = %v, want %v", tt.base, can, tt.can)
+		}
+	}
+
+	mqtt := Entry{Name: "mqtt", Type: BusType, Host: "10.0.0.5", Port: 1883, Text: map[string]string{"transport": "mqtt", "scheme": "tcp"}}
+	nats := Entry{Name: "nats", Type: BusType, Host: "10.0.0.22", Port: 4222, Text: map[string]string{"transport": "nats", "scheme": "nats"}}
+	withCA := map[string]string{"secret": "s3cr3t", "tls_ca": "/etc/go-home/ca.pem"}
+	if e, ok := usableBus([]Entry{mqtt, nats}, withCA); !ok || e.Name != "nats" {
+		t.Errorf("usableBus() = %+v, %v, the credentials are sent to an MQTT broker", e, ok)
+	}
+	if e, ok := usableBus([]Entry{mqtt}, map[string]string{}); !ok || e.Name != "mqtt" {
+		t.Errorf("usableBus() = %+v, %v", e, ok)
+	}
+	if _, ok := DiscoverBus(context.Background(), time.Millisecond, map[string]string{"secret": "s3cr3t"}); ok {
+		t.Errorf("DiscoverBus() looked for a bus for a configuration with credentials and no tls_ca")
+	}
+}
@@ -0,0 +1,126 @@
+// Package zeroconf announces and finds the parts of go-home on the LAN with mDNS / DNS-SD,
+// so that a service can find the message bus and InfluxDB without a configuration.
+package zeroconf
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutella/dnssd"
+)
+
+const (
+	// ServiceType is announced by every micro-service, the instance name is the name of the service
+	ServiceType = "_gohome._tcp"
+	// BusType is the message bus, announced by the config service
+	BusType = "_gohome-bus._tcp"
+	// InfluxType is InfluxDB, announced by the config service
+	InfluxType = "_gohome-influx._tcp"
+	// Domain is the domain that everything is announced in
+	Domain = "local"
+	// NoPort is the port of an entry that does not accept connections, DNS-SD needs a port
+	// and 9 is the discard port
+	NoPort = 9
+)
+
+// Entry is a service that is announced or that was found on the LAN
+type Entry struct {
+	Name string
+	Type string
+	Host string // an IP address, empty to announce the addresses of this machine
+	Port int
+	Text map[string]string
+}
+
+// Address returns the host:port of the entry
+func (e Entry) Address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// Announce announces the entries until the context is done
+func Announce(ctx context.Context, entries ...Entry) error {
+	responder, err := dnssd.NewResponder()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		cfg := dnssd.Config{
+			Name:   e.Name,
+			Type:   e.Type,
+			Domain: Domain,
+			Port:   e.Port,
+			Text:   e.Text,
+		}
+		if ip := net.ParseIP(e.Host); ip != nil {
+			cfg.IPs = []net.IP{ip}
+		}
+		service, err := dnssd.NewService(cfg)
+		if err != nil {
+			return err
+		}
+		if _, err := responder.Add(service); err != nil {
+			return err
+		}
+	}
+	err = responder.Respond(ctx)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Lookup browses for the entries of type 'typ' until the timeout expires or 'enough' returns
+// true for the entries that were found so far, 'enough' may be nil.
+func Lookup(ctx context.Context, typ string, timeout time.Duration, enough func(found []Entry) bool) []Entry {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var mutex sync.Mutex
+	found := []Entry{}
+	add := func(be dnssd.BrowseEntry) {
+		e := fromBrowseEntry(be)
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, f := range found {
+			if f.Name == e.Name {
+				return
+			}
+		}
+		found = append(found, e)
+		if enough != nil && enough(found) {
+			cancel()
+		}
+	}
+	remove := func(be dnssd.BrowseEntry) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for i, f := range found {
+			if f.Name == be.Name {
+				found = append(found[:i], found[i+1:]...)
+				return
+			}
+		}
+	}
+	dnssd.LookupType(ctx, typ+"."+Domain+".", add, remove)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return append([]Entry{}, found...)
+}
+
+func fromBrowseEntry(be dnssd.BrowseEntry) Entry {
+	e := Entry{Name: be.Name, Type: strings.TrimSuffix(be.Type, "."), Port: be.Port, Text: be.Text}
+	for _, ip := range be.IPs {
+		if e.Host == "" || ip.To4() != nil && net.ParseIP(e.Host).To4() == nil {
+			e.Host = ip.String()
+		}
+	}
+	if e.Host == "" {
+		e.Host = strings.TrimSuffix(be.Host, ".")
+	}
+	return e
+}